@@ -0,0 +1,91 @@
+package schedule
+
+// A ChainScheduler overlays a secondary scheduler behind a primary one, as when
+// migrating tasks from one scheduler to another. Tasks are put in to the primary,
+// and Next() returns tasks from the secondary only once the primary is empty.
+// Contains, Remove, Size and the other methods span both schedulers.
+type ChainScheduler struct {
+	primary   Scheduler
+	secondary Scheduler
+	schedulerStatsRecorder
+}
+
+func NewChainScheduler(primary, secondary Scheduler) *ChainScheduler {
+	return &ChainScheduler{primary, secondary, schedulerStatsRecorder{}}
+}
+
+func (c *ChainScheduler) Contains(t Task) bool {
+	return c.primary.Contains(t) || c.secondary.Contains(t)
+}
+
+func (c *ChainScheduler) ContainsId(id string) bool {
+	return c.primary.ContainsId(id) || c.secondary.ContainsId(id)
+}
+
+// Put puts the tasks in to the primary scheduler, ignoring those already held by
+// the secondary.
+func (c *ChainScheduler) Put(tasks ...Task) {
+	before := c.Size()
+	for _, t := range tasks {
+		if !c.secondary.Contains(t) {
+			c.primary.Put(t)
+		}
+	}
+	c.recordPut(c.Size()-before, c.Size())
+}
+
+func (c *ChainScheduler) Next() ScheduledTask {
+	if next := c.primary.Next(); next != nil {
+		return c.recordNext(next)
+	}
+	return c.recordNext(c.secondary.Next())
+}
+
+func (c *ChainScheduler) NextN(n int) []ScheduledTask {
+	return nextN(c, n)
+}
+
+func (c *ChainScheduler) PeekN(n int) []Task {
+	return peekN(c, n)
+}
+
+func (c *ChainScheduler) CopyInto(dst Scheduler) {
+	copyInto(c, dst)
+}
+
+// Each visits the tasks of the primary scheduler followed by those of the secondary.
+func (c *ChainScheduler) Each(f func(Task) bool) {
+	stopped := false
+	c.primary.Each(func(t Task) bool {
+		stopped = !f(t)
+		return !stopped
+	})
+	if !stopped {
+		c.secondary.Each(f)
+	}
+}
+
+// Drain returns the tasks of the primary scheduler followed by those of the secondary.
+func (c *ChainScheduler) Drain() []Task {
+	return append(c.primary.Drain(), c.secondary.Drain()...)
+}
+
+func (c *ChainScheduler) Remove(id string) Task {
+	if t := c.primary.Remove(id); t != nil {
+		return c.recordRemove(t)
+	}
+	return c.recordRemove(c.secondary.Remove(id))
+}
+
+func (c *ChainScheduler) Size() int {
+	return c.primary.Size() + c.secondary.Size()
+}
+
+func (c *ChainScheduler) Stats() SchedulerStats {
+	return c.stats(c.Size())
+}
+
+func (c *ChainScheduler) Clear() {
+	c.primary.Clear()
+	c.secondary.Clear()
+}
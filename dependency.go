@@ -0,0 +1,225 @@
+package schedule
+
+import (
+	"sort"
+)
+
+// dependencyTask is a ScheduledTask that marks its task as complete in the
+// DependencyScheduler it was emitted from upon Close().
+type dependencyTask struct {
+	st        ScheduledTask
+	scheduler *DependencyScheduler
+}
+
+func (d *dependencyTask) Task() Task { return d.st.Task() }
+
+func (d *dependencyTask) Id() string { return d.st.Id() }
+
+func (d *dependencyTask) release(res []int) bool { return releasePartial(d.st, res) }
+
+func (d *dependencyTask) returnResource() bool { return returnEarly(d.st) }
+
+func (d *dependencyTask) OnComplete(f func()) { onComplete(d.st, f) }
+
+// Close closes the ScheduledTask it wraps and releases any tasks whose
+// prerequisites are now all complete.
+func (d *dependencyTask) Close() {
+	d.st.Close()
+	d.scheduler.complete(d.st.Id())
+}
+
+// blockedTask is a task waiting on prerequisites, along with the order it was put in.
+type blockedTask struct {
+	t         Task
+	remaining int
+	seq       uint64
+}
+
+// A DependencyScheduler holds back each task until all of its prerequisites
+// have completed, then passes it to the underlying scheduler. A prerequisite
+// completes when the ScheduledTask emitted for it is closed. Tasks with
+// prerequisites that are never put in to the scheduler are never returned.
+//
+// The id of every completed task is remembered so tasks put after their
+// prerequisites complete are not held back, which grows without bound unless
+// callers Forget ids no task still to be put depends on, or Clear the scheduler.
+type DependencyScheduler struct {
+	underlying   Scheduler
+	dependencies map[string][]string
+	blocked      map[string]*blockedTask
+	dependents   map[string][]string
+	completed    map[string]struct{}
+	seq          uint64
+	schedulerStatsRecorder
+}
+
+// NewDependencyScheduler returns a DependencyScheduler that maps each task id
+// to the ids of the tasks that must complete before it can be scheduled.
+func NewDependencyScheduler(underlying Scheduler, dependencies map[string][]string) *DependencyScheduler {
+	return &DependencyScheduler{
+		underlying:   underlying,
+		dependencies: dependencies,
+		blocked:      map[string]*blockedTask{},
+		dependents:   map[string][]string{},
+		completed:    map[string]struct{}{},
+	}
+}
+
+func (d *DependencyScheduler) Contains(t Task) bool {
+	return d.ContainsId(t.Id())
+}
+
+func (d *DependencyScheduler) ContainsId(id string) bool {
+	if _, ok := d.blocked[id]; ok {
+		return true
+	}
+	return d.underlying.ContainsId(id)
+}
+
+func (d *DependencyScheduler) Put(tasks ...Task) {
+	before := d.Size()
+	for _, t := range tasks {
+		if d.Contains(t) {
+			continue
+		}
+		id := t.Id()
+		remaining := 0
+		for _, pre := range d.dependencies[id] {
+			if _, ok := d.completed[pre]; !ok {
+				d.dependents[pre] = append(d.dependents[pre], id)
+				remaining++
+			}
+		}
+		if remaining == 0 {
+			d.underlying.Put(t)
+			continue
+		}
+		d.seq++
+		d.blocked[id] = &blockedTask{t, remaining, d.seq}
+	}
+	d.recordPut(d.Size()-before, d.Size())
+}
+
+func (d *DependencyScheduler) Next() ScheduledTask {
+	next := d.underlying.Next()
+	if next == nil {
+		return nil
+	}
+	return d.recordNext(&dependencyTask{next, d})
+}
+
+func (d *DependencyScheduler) NextN(n int) []ScheduledTask {
+	return nextN(d, n)
+}
+
+func (d *DependencyScheduler) PeekN(n int) []Task {
+	return peekN(d, n)
+}
+
+func (d *DependencyScheduler) CopyInto(dst Scheduler) {
+	copyInto(d, dst)
+}
+
+func (d *DependencyScheduler) Underlying() Scheduler {
+	return d.underlying
+}
+
+// Drain returns the tasks of the underlying scheduler followed by the tasks still
+// waiting on prerequisites in the order they were put. Tasks that have already
+// been returned by Next are unaffected and still complete when closed.
+func (d *DependencyScheduler) Drain() []Task {
+	tasks := append(d.underlying.Drain(), d.blockedTasks()...)
+	d.blocked = map[string]*blockedTask{}
+	d.dependents = map[string][]string{}
+	return tasks
+}
+
+// Each visits the tasks of the underlying scheduler followed by the tasks still
+// waiting on prerequisites in the order they were put.
+func (d *DependencyScheduler) Each(f func(Task) bool) {
+	stopped := false
+	d.underlying.Each(func(t Task) bool {
+		stopped = !f(t)
+		return !stopped
+	})
+	if !stopped {
+		visit(d.blockedTasks(), f)
+	}
+}
+
+// blockedTasks returns the tasks waiting on prerequisites in the order they were put.
+func (d *DependencyScheduler) blockedTasks() []Task {
+	blocked := make([]*blockedTask, 0, len(d.blocked))
+	for _, b := range d.blocked {
+		blocked = append(blocked, b)
+	}
+	sort.Slice(blocked, func(i, j int) bool {
+		return blocked[i].seq < blocked[j].seq
+	})
+	tasks := make([]Task, len(blocked))
+	for i, b := range blocked {
+		tasks[i] = b.t
+	}
+	return tasks
+}
+
+// complete marks the task with the given id as complete and passes each task
+// no longer waiting on a prerequisite to the underlying scheduler.
+func (d *DependencyScheduler) complete(id string) {
+	d.completed[id] = struct{}{}
+	for _, dep := range d.dependents[id] {
+		b, ok := d.blocked[dep]
+		if !ok {
+			continue
+		}
+		b.remaining--
+		if b.remaining == 0 {
+			delete(d.blocked, dep)
+			d.underlying.Put(b.t)
+		}
+	}
+	delete(d.dependents, id)
+}
+
+// Forget forgets that the tasks with the given ids have completed, so tasks put
+// later that depend on them are held back until they complete again.
+func (d *DependencyScheduler) Forget(ids ...string) {
+	for _, id := range ids {
+		delete(d.completed, id)
+	}
+}
+
+func (d *DependencyScheduler) Remove(id string) Task {
+	b, ok := d.blocked[id]
+	if !ok {
+		return d.recordRemove(d.underlying.Remove(id))
+	}
+	delete(d.blocked, id)
+	for _, pre := range d.dependencies[id] {
+		dependents := d.dependents[pre]
+		for i, dep := range dependents {
+			if dep == id {
+				d.dependents[pre] = append(dependents[:i], dependents[i+1:]...)
+				break
+			}
+		}
+	}
+	return d.recordRemove(b.t)
+}
+
+func (d *DependencyScheduler) Size() int {
+	return len(d.blocked) + d.underlying.Size()
+}
+
+func (d *DependencyScheduler) Stats() SchedulerStats {
+	return d.stats(d.Size())
+}
+
+// Clear removes all pending tasks, including those waiting on prerequisites, and
+// forgets which tasks have completed.
+func (d *DependencyScheduler) Clear() {
+	d.underlying.Clear()
+	d.blocked = map[string]*blockedTask{}
+	d.dependents = map[string][]string{}
+	d.completed = map[string]struct{}{}
+}
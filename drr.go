@@ -0,0 +1,172 @@
+package schedule
+
+// A DeficitRoundRobinScheduler partitions tasks like a PartitionedScheduler but shares
+// each priority level among its partitions using deficit round robin. Each time the
+// round robin reaches a partition, its deficit grows by the partition's quantum, and
+// the partition returns tasks while its deficit covers the cost of its next task,
+// which is then deducted. Over many rounds each partition is served in proportion
+// to its quantum, whatever the costs of its tasks. A partition that runs out of
+// tasks, or that cannot return one when reached, such as when waiting on resources,
+// forfeits its deficit, so a partition that stalls does not save up its quanta for
+// a burst once it can run again. Keys without a positive quantum have a quantum of 1.
+type DeficitRoundRobinScheduler struct {
+	partitioner Partitioner
+	quanta      map[string]int
+	cost        func(Task) int
+	pos         int
+	visiting    bool
+	partitionSet
+	schedulerStatsRecorder
+}
+
+func NewDeficitRoundRobinScheduler(p Partitioner, quanta map[string]int, cost func(Task) int) *DeficitRoundRobinScheduler {
+	return &DeficitRoundRobinScheduler{p, quanta, cost, 0, false, newPartitionSet(partitionKey(p)), schedulerStatsRecorder{}}
+}
+
+func (d *DeficitRoundRobinScheduler) quantum(key string) int {
+	if quantum := d.quanta[key]; quantum > 0 {
+		return quantum
+	}
+	return 1
+}
+
+func (d *DeficitRoundRobinScheduler) Put(tasks ...Task) {
+	before := d.Size()
+	for _, t := range tasks {
+		key, pri, fact := d.partitioner(t)
+		part := d.partition(key)
+		if part == nil {
+			part = d.add(key, pri, fact())
+		}
+		part.scheduler.Put(t)
+	}
+	d.prune()
+	d.recordPut(d.Size()-before, d.Size())
+}
+
+// Next visits the partitions of the highest priority in round robin order, topping
+// up the deficit of each partition as it is reached, and returns the next task of
+// the first partition whose deficit covers its cost.
+func (d *DeficitRoundRobinScheduler) Next() ScheduledTask {
+	top, ok := d.topPriority()
+	if !ok {
+		return nil
+	}
+	// partitions returning nil despite holding tasks, such as those waiting on
+	// resources, are passed over until every partition has been
+	for stalled := 0; stalled < len(d.partitions); {
+		part := d.partitions[d.pos]
+		if part.priority != top {
+			d.advance()
+			continue
+		}
+		if !d.visiting {
+			part.deficit += d.quantum(part.key)
+			d.visiting = true
+		}
+		var head Task
+		part.scheduler.Each(func(t Task) bool {
+			head = t
+			return false
+		})
+		if d.cost(head) > part.deficit {
+			d.advance()
+			continue
+		}
+		next := part.scheduler.Next()
+		if next == nil {
+			part.deficit = 0
+			stalled++
+			d.advance()
+			continue
+		}
+		part.deficit -= d.cost(next.Task())
+		d.prune()
+		return d.recordNext(next)
+	}
+	return nil
+}
+
+// topPriority returns the highest priority of the partitions, which all hold tasks.
+func (d *DeficitRoundRobinScheduler) topPriority() (top uint, ok bool) {
+	for _, part := range d.partitions {
+		if !ok || part.priority > top {
+			top, ok = part.priority, true
+		}
+	}
+	return
+}
+
+// advance moves the round robin on to the next partition.
+func (d *DeficitRoundRobinScheduler) advance() {
+	d.pos = (d.pos + 1) % len(d.partitions)
+	d.visiting = false
+}
+
+func (d *DeficitRoundRobinScheduler) NextN(n int) []ScheduledTask {
+	return nextN(d, n)
+}
+
+func (d *DeficitRoundRobinScheduler) PeekN(n int) []Task {
+	return peekN(d, n)
+}
+
+func (d *DeficitRoundRobinScheduler) CopyInto(dst Scheduler) {
+	copyInto(d, dst)
+}
+
+// Drain drains each partition and orders their tasks as Next would. The partitions
+// are drained rather than emitted from, so resources are not requested for their tasks.
+func (d *DeficitRoundRobinScheduler) Drain() []Task {
+	tasks := drain(d.shadow(Scheduler.Drain).Next)
+	d.Clear()
+	return tasks
+}
+
+// Each visits the tasks of each partition in the order Next would return them.
+func (d *DeficitRoundRobinScheduler) Each(f func(Task) bool) {
+	visit(drain(d.shadow(collect).Next), f)
+}
+
+// shadow returns a copy of the scheduler whose partitions are FifoSchedulers holding
+// the tasks taken from each partition using take, so the order of Next can be
+// replayed without emitting from the partitions themselves.
+func (d *DeficitRoundRobinScheduler) shadow(take func(Scheduler) []Task) *DeficitRoundRobinScheduler {
+	s := NewDeficitRoundRobinScheduler(d.partitioner, d.quanta, d.cost)
+	s.pos, s.visiting = d.pos, d.visiting
+	for _, part := range d.partitions {
+		f := NewFifoScheduler()
+		f.Put(take(part.scheduler)...)
+		s.add(part.key, part.priority, f).deficit = part.deficit
+	}
+	return s
+}
+
+func (d *DeficitRoundRobinScheduler) Remove(id string) Task {
+	if t := d.remove(id); t != nil {
+		d.prune()
+		return d.recordRemove(t)
+	}
+	return nil
+}
+
+// prune discards partitions whose schedulers are empty, forfeiting their deficit,
+// and keeps the round robin position on the partition that would have been served
+// next. If the partition being visited is discarded, the next one starts a new visit.
+func (d *DeficitRoundRobinScheduler) prune() {
+	pos, discarded := d.pruneAt(nonEmpty, d.pos)
+	d.pos = pos
+	if discarded {
+		d.visiting = false
+	}
+}
+
+func (d *DeficitRoundRobinScheduler) Stats() SchedulerStats {
+	return d.stats(d.Size())
+}
+
+func (d *DeficitRoundRobinScheduler) Clear() {
+	d.clear()
+	d.pos = 0
+	d.visiting = false
+}
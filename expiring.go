@@ -0,0 +1,118 @@
+package schedule
+
+// An ExpiringScheduler drops tasks that have been queued for too long, as in
+// latency critical systems where stale work is worthless. Next() discards each
+// task from the underlying scheduler whose age exceeds the maximum, returning any
+// resource granted to it, and returns the first task that has not expired. A
+// discarded task never ran, so it is not closed, and an underlying scheduler such as
+// a DependencyScheduler does not see it complete. Dropped() reports the number of
+// tasks discarded.
+//
+// Time is virtual: a task's age is the time the scheduler's clock was last advanced
+// to, less the enqueue time reported for it in milliseconds.
+type ExpiringScheduler struct {
+	underlying Scheduler
+	enqueued   func(Task) int
+	maxAgeMs   int
+	nowMs      int
+	dropped    int
+	schedulerStatsRecorder
+}
+
+// NewExpiringScheduler returns an ExpiringScheduler dropping the tasks of underlying
+// older than maxAgeMs milliseconds, as measured from the enqueue time enqueued
+// returns for each.
+func NewExpiringScheduler(underlying Scheduler, enqueued func(Task) int, maxAgeMs int) *ExpiringScheduler {
+	return &ExpiringScheduler{underlying: underlying, enqueued: enqueued, maxAgeMs: maxAgeMs}
+}
+
+// Advance moves the scheduler's clock forward to nowMs milliseconds. Moving the clock
+// backwards has no effect.
+func (e *ExpiringScheduler) Advance(nowMs int) {
+	if nowMs > e.nowMs {
+		e.nowMs = nowMs
+	}
+}
+
+// Now returns the time the scheduler's clock was last advanced to.
+func (e *ExpiringScheduler) Now() int {
+	return e.nowMs
+}
+
+// Dropped returns the number of tasks Next has discarded because they expired.
+func (e *ExpiringScheduler) Dropped() int {
+	return e.dropped
+}
+
+func (e *ExpiringScheduler) expired(t Task) bool {
+	return e.nowMs-e.enqueued(t) > e.maxAgeMs
+}
+
+func (e *ExpiringScheduler) Contains(t Task) bool {
+	return e.underlying.Contains(t)
+}
+
+func (e *ExpiringScheduler) ContainsId(id string) bool {
+	return e.underlying.ContainsId(id)
+}
+
+func (e *ExpiringScheduler) Put(tasks ...Task) {
+	before := e.Size()
+	e.underlying.Put(tasks...)
+	e.recordPut(e.Size()-before, e.Size())
+}
+
+func (e *ExpiringScheduler) Next() ScheduledTask {
+	for {
+		next := e.underlying.Next()
+		if next == nil || !e.expired(next.Task()) {
+			return e.recordNext(next)
+		}
+		returnEarly(next)
+		e.dropped++
+	}
+}
+
+func (e *ExpiringScheduler) NextN(n int) []ScheduledTask {
+	return nextN(e, n)
+}
+
+func (e *ExpiringScheduler) PeekN(n int) []Task {
+	return peekN(e, n)
+}
+
+func (e *ExpiringScheduler) CopyInto(dst Scheduler) {
+	copyInto(e, dst)
+}
+
+func (e *ExpiringScheduler) Underlying() Scheduler {
+	return e.underlying
+}
+
+// Each visits the tasks of the underlying scheduler, including those that have
+// expired but have not yet been dropped by Next.
+func (e *ExpiringScheduler) Each(f func(Task) bool) {
+	e.underlying.Each(f)
+}
+
+// Drain drains the underlying scheduler, including tasks that have expired but have
+// not yet been dropped by Next.
+func (e *ExpiringScheduler) Drain() []Task {
+	return e.underlying.Drain()
+}
+
+func (e *ExpiringScheduler) Remove(id string) Task {
+	return e.recordRemove(e.underlying.Remove(id))
+}
+
+func (e *ExpiringScheduler) Size() int {
+	return e.underlying.Size()
+}
+
+func (e *ExpiringScheduler) Stats() SchedulerStats {
+	return e.stats(e.Size())
+}
+
+func (e *ExpiringScheduler) Clear() {
+	e.underlying.Clear()
+}
@@ -0,0 +1,154 @@
+package schedule
+
+import "sort"
+
+// fairShareTask is a ScheduledTask that charges its runtime to its key in the
+// FairShareScheduler it was emitted from upon Close().
+type fairShareTask struct {
+	st        ScheduledTask
+	scheduler *FairShareScheduler
+	key       string
+	closed    bool
+}
+
+func (f *fairShareTask) Task() Task { return f.st.Task() }
+
+func (f *fairShareTask) Id() string { return f.st.Id() }
+
+func (f *fairShareTask) release(res []int) bool { return releasePartial(f.st, res) }
+
+func (f *fairShareTask) returnResource() bool { return returnEarly(f.st) }
+
+func (f *fairShareTask) OnComplete(fn func()) { onComplete(f.st, fn) }
+
+// Close closes the ScheduledTask it wraps and, the first time it is called,
+// charges the runtime of its task to its key.
+func (f *fairShareTask) Close() {
+	f.st.Close()
+	if f.closed {
+		return
+	}
+	f.closed = true
+	f.scheduler.usage[f.key] += f.scheduler.runtime(f.st.Task())
+}
+
+// A FairShareScheduler partitions tasks like a PartitionedScheduler but shares each
+// priority level among its partitions by their historical usage rather than their
+// arrival. Each task returned from Next() charges its runtime to its key when it is
+// closed, and Next() serves the partition whose key has accumulated the least usage,
+// so a key that has consumed more is passed over until the others catch up. Ties go
+// to the partition created first. Usage outlives the partitions, and is kept when
+// the scheduler is cleared.
+type FairShareScheduler struct {
+	partitioner Partitioner
+	runtime     func(Task) int
+	usage       map[string]int
+	partitionSet
+	schedulerStatsRecorder
+}
+
+// NewFairShareScheduler returns a FairShareScheduler charging runtime(t) to the key
+// of each task t when it is closed, such as the RuntimeMs of a SimTask.
+func NewFairShareScheduler(p Partitioner, runtime func(Task) int) *FairShareScheduler {
+	return &FairShareScheduler{p, runtime, map[string]int{}, newPartitionSet(partitionKey(p)), schedulerStatsRecorder{}}
+}
+
+// Usage returns the runtime charged to the given key by the tasks closed so far.
+func (f *FairShareScheduler) Usage(key string) int {
+	return f.usage[key]
+}
+
+// ordered returns the partitions in the order they are served: by priority, then by
+// the usage of their key, then by the order they were created.
+func (f *FairShareScheduler) ordered() []*keyedPartition {
+	ordered := make([]*keyedPartition, len(f.partitions))
+	copy(ordered, f.partitions)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].priority != ordered[j].priority {
+			return ordered[i].priority > ordered[j].priority
+		}
+		return f.usage[ordered[i].key] < f.usage[ordered[j].key]
+	})
+	return ordered
+}
+
+func (f *FairShareScheduler) Put(tasks ...Task) {
+	before := f.Size()
+	for _, t := range tasks {
+		key, pri, fact := f.partitioner(t)
+		part := f.partition(key)
+		if part == nil {
+			part = f.add(key, pri, fact())
+		}
+		part.scheduler.Put(t)
+	}
+	f.prune(nonEmpty)
+	f.recordPut(f.Size()-before, f.Size())
+}
+
+// Next returns the next task of the partition with the highest priority and the
+// least usage. Partitions returning nil despite holding tasks, such as those waiting
+// on resources, are passed over for the next in order.
+func (f *FairShareScheduler) Next() ScheduledTask {
+	for _, part := range f.ordered() {
+		if next := part.scheduler.Next(); next != nil {
+			f.prune(nonEmpty)
+			return f.recordNext(&fairShareTask{next, f, part.key, false})
+		}
+	}
+	return nil
+}
+
+func (f *FairShareScheduler) NextN(n int) []ScheduledTask {
+	return nextN(f, n)
+}
+
+func (f *FairShareScheduler) PeekN(n int) []Task {
+	return peekN(f, n)
+}
+
+func (f *FairShareScheduler) CopyInto(dst Scheduler) {
+	copyInto(f, dst)
+}
+
+// Drain drains each partition in the order Next would serve them, as usage only
+// changes when tasks are closed.
+func (f *FairShareScheduler) Drain() []Task {
+	tasks := []Task{}
+	for _, part := range f.ordered() {
+		tasks = append(tasks, part.scheduler.Drain()...)
+	}
+	f.Clear()
+	return tasks
+}
+
+// Each visits the tasks of each partition in the order Next would return them if
+// none were closed in between.
+func (f *FairShareScheduler) Each(fn func(Task) bool) {
+	for _, part := range f.ordered() {
+		stopped := false
+		part.scheduler.Each(func(t Task) bool {
+			stopped = !fn(t)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+func (f *FairShareScheduler) Remove(id string) Task {
+	if t := f.remove(id); t != nil {
+		f.prune(nonEmpty)
+		return f.recordRemove(t)
+	}
+	return nil
+}
+
+func (f *FairShareScheduler) Stats() SchedulerStats {
+	return f.stats(f.Size())
+}
+
+func (f *FairShareScheduler) Clear() {
+	f.clear()
+}
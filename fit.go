@@ -0,0 +1,192 @@
+package schedule
+
+import "sort"
+
+// fitScheduler grants resources to the pending task whose request best fits the
+// resources available in the pool, as judged by prefer, which compares the total
+// resources left available after granting two requests. Tasks whose requests
+// exceed the resources available are skipped, and ties are broken in first in,
+// first out order.
+type fitScheduler struct {
+	pool               ResourceVectorPool
+	resourceCalculator ResourceCalculator
+	prefer             func(leftA, leftB int) bool
+	tasks              []Task
+	elementMap         map[string]struct{}
+	schedulerStatsRecorder
+}
+
+func newFitScheduler(pool ResourceVectorPool, calc ResourceCalculator, prefer func(leftA, leftB int) bool) fitScheduler {
+	return fitScheduler{pool, calc, prefer, []Task{}, map[string]struct{}{}, schedulerStatsRecorder{}}
+}
+
+// index returns the position of the pending task with the given id, or -1 if there
+// is none.
+func (f *fitScheduler) index(id string) int {
+	if !f.ContainsId(id) {
+		return -1
+	}
+	for i, t := range f.tasks {
+		if t.Id() == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *fitScheduler) Contains(t Task) bool {
+	return f.ContainsId(t.Id())
+}
+
+func (f *fitScheduler) ContainsId(id string) bool {
+	_, ok := f.elementMap[id]
+	return ok
+}
+
+func (f *fitScheduler) Put(tasks ...Task) {
+	n := 0
+	for _, t := range tasks {
+		if f.Contains(t) {
+			continue
+		}
+		f.tasks = append(f.tasks, t)
+		f.elementMap[t.Id()] = struct{}{}
+		n++
+	}
+	f.recordPut(n, len(f.tasks))
+}
+
+// Next requests resources for the pending tasks that fit in the available resources,
+// in order of preference, and returns the first to be granted them.
+func (f *fitScheduler) Next() ScheduledTask {
+	type candidate struct {
+		idx       int
+		requested Resource
+		left      int
+	}
+	available := f.pool.Available()
+	candidates := []candidate{}
+	for i, t := range f.tasks {
+		requested := f.resourceCalculator(t)
+		if left, ok := leftover(available, requested); ok {
+			candidates = append(candidates, candidate{i, requested, left})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return f.prefer(candidates[i].left, candidates[j].left)
+	})
+	for _, c := range candidates {
+		if allocated := f.pool.Request(c.requested); allocated != nil {
+			t := f.remove(c.idx)
+			return f.recordNext(newResourceTask(&defaultScheduledTask{t: t}, allocated))
+		}
+	}
+	return nil
+}
+
+// leftover returns the total resources left available after granting the requested
+// resource, and false if it is not a resource vector or exceeds what is available.
+func leftover(available []int, requested Resource) (int, bool) {
+	v, ok := requested.(*resourceVector)
+	if !ok || len(v.resources) != len(available) {
+		return 0, false
+	}
+	left := 0
+	for i := range available {
+		if v.resources[i] > available[i] {
+			return 0, false
+		}
+		left += available[i] - v.resources[i]
+	}
+	return left, true
+}
+
+func (f *fitScheduler) remove(i int) Task {
+	t := f.tasks[i]
+	last := len(f.tasks) - 1
+	copy(f.tasks[i:], f.tasks[i+1:])
+	f.tasks[last] = nil
+	f.tasks = f.tasks[:last]
+	delete(f.elementMap, t.Id())
+	return t
+}
+
+func (f *fitScheduler) NextN(n int) []ScheduledTask {
+	return nextN(f, n)
+}
+
+func (f *fitScheduler) PeekN(n int) []Task {
+	return peekN(f, n)
+}
+
+func (f *fitScheduler) CopyInto(dst Scheduler) {
+	copyInto(f, dst)
+}
+
+// Each visits the pending tasks in the order they were put.
+func (f *fitScheduler) Each(fn func(Task) bool) {
+	for _, t := range f.tasks {
+		if !fn(t) {
+			return
+		}
+	}
+}
+
+// Drain returns the pending tasks in the order they were put without requesting
+// any resources.
+func (f *fitScheduler) Drain() []Task {
+	tasks := make([]Task, len(f.tasks))
+	copy(tasks, f.tasks)
+	f.Clear()
+	return tasks
+}
+
+func (f *fitScheduler) Remove(id string) Task {
+	if i := f.index(id); i != -1 {
+		return f.recordRemove(f.remove(i))
+	}
+	return nil
+}
+
+func (f *fitScheduler) Size() int {
+	return len(f.tasks)
+}
+
+func (f *fitScheduler) Stats() SchedulerStats {
+	return f.stats(f.Size())
+}
+
+func (f *fitScheduler) Clear() {
+	for i := range f.tasks {
+		f.tasks[i] = nil
+	}
+	f.tasks = f.tasks[:0]
+	f.elementMap = map[string]struct{}{}
+}
+
+// A BestFitScheduler packs tasks in to a pool of resources by returning the pending
+// task whose request most tightly fits the resources currently available, leaving
+// the least total resources behind. Tasks requesting more than is available are
+// skipped, and Next() returns nil if none fit. Ties are broken in first in, first
+// out order. The resource granted to a task is returned to the pool on Close().
+type BestFitScheduler struct {
+	fitScheduler
+}
+
+func NewBestFitScheduler(pool ResourceVectorPool, calc ResourceCalculator) *BestFitScheduler {
+	return &BestFitScheduler{newFitScheduler(pool, calc, func(leftA, leftB int) bool { return leftA < leftB })}
+}
+
+// A WorstFitScheduler spreads tasks over a pool of resources by returning the pending
+// task that leaves the most total resources available after it is granted, keeping
+// large amounts of capacity free for as long as possible. Tasks requesting more than
+// is available are skipped, and Next() returns nil if none fit. Ties are broken in
+// first in, first out order. The resource granted to a task is returned to the pool
+// on Close().
+type WorstFitScheduler struct {
+	fitScheduler
+}
+
+func NewWorstFitScheduler(pool ResourceVectorPool, calc ResourceCalculator) *WorstFitScheduler {
+	return &WorstFitScheduler{newFitScheduler(pool, calc, func(leftA, leftB int) bool { return leftA > leftB })}
+}
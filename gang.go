@@ -0,0 +1,206 @@
+package schedule
+
+// A GangPartitioner maps a task to the gang it belongs to and the number of
+// tasks in that gang.
+type GangPartitioner func(t Task) (gang string, size int)
+
+type gang struct {
+	id    string
+	size  int
+	tasks []Task
+}
+
+// A GangScheduler schedules gangs of tasks that must run together. A gang is
+// held until all of its tasks have been put and resources can be granted to
+// every one of them, at which point all of its tasks are returned from Next()
+// before any other. Gangs are granted resources in the order they were first
+// put, and a gang that cannot be granted resources blocks the gangs after it.
+//
+// Resources are reserved by requesting each task's resource in turn and returning
+// those already granted if any request fails, so no gang ever holds part of its
+// resources once Next() returns.
+type GangScheduler struct {
+	partitioner        GangPartitioner
+	pool               ResourcePool
+	resourceCalculator ResourceCalculator
+	gangs              []*gang
+	ready              []ScheduledTask
+	elementMap         map[string]struct{}
+	schedulerStatsRecorder
+}
+
+func NewGangScheduler(partitioner GangPartitioner, pool ResourcePool, calc ResourceCalculator) *GangScheduler {
+	return &GangScheduler{partitioner, pool, calc, []*gang{}, []ScheduledTask{}, map[string]struct{}{}, schedulerStatsRecorder{}}
+}
+
+func (g *GangScheduler) Contains(t Task) bool {
+	return g.ContainsId(t.Id())
+}
+
+func (g *GangScheduler) ContainsId(id string) bool {
+	_, ok := g.elementMap[id]
+	return ok
+}
+
+// Put adds each task to the gang it belongs to. A task put after its gang has
+// been granted resources starts a new gang with the same id.
+func (g *GangScheduler) Put(tasks ...Task) {
+	n := 0
+	for _, t := range tasks {
+		if g.Contains(t) {
+			continue
+		}
+		id, size := g.partitioner(t)
+		var gg *gang
+		for _, existing := range g.gangs {
+			if existing.id == id {
+				gg = existing
+				break
+			}
+		}
+		if gg == nil {
+			gg = &gang{id, size, []Task{}}
+			g.gangs = append(g.gangs, gg)
+		}
+		gg.tasks = append(gg.tasks, t)
+		g.elementMap[t.Id()] = struct{}{}
+		n++
+	}
+	g.recordPut(n, len(g.elementMap))
+}
+
+func (g *GangScheduler) Next() ScheduledTask {
+	if len(g.ready) == 0 {
+		for i, gg := range g.gangs {
+			if len(gg.tasks) < gg.size {
+				continue
+			}
+			if !g.grant(gg) {
+				break
+			}
+			g.removeGang(i)
+			break
+		}
+	}
+	if len(g.ready) == 0 {
+		return nil
+	}
+	next := g.ready[0]
+	g.ready[0] = nil
+	g.ready = g.ready[1:]
+	delete(g.elementMap, next.Id())
+	return g.recordNext(next)
+}
+
+// grant requests resources for every task of the gang, adding its tasks to
+// the ready queue if all are granted and returning any granted resources otherwise.
+func (g *GangScheduler) grant(gg *gang) bool {
+	granted := make([]ScheduledTask, 0, len(gg.tasks))
+	for _, t := range gg.tasks {
+		allocated := g.pool.Request(g.resourceCalculator(t))
+		if allocated == nil {
+			for _, st := range granted {
+				st.Close()
+			}
+			return false
+		}
+		granted = append(granted, newResourceTask(&defaultScheduledTask{t: t}, allocated))
+	}
+	g.ready = append(g.ready, granted...)
+	return true
+}
+
+func (g *GangScheduler) removeGang(i int) {
+	last := len(g.gangs) - 1
+	copy(g.gangs[i:], g.gangs[i+1:])
+	g.gangs[last] = nil
+	g.gangs = g.gangs[:last]
+}
+
+func (g *GangScheduler) NextN(n int) []ScheduledTask {
+	return nextN(g, n)
+}
+
+func (g *GangScheduler) PeekN(n int) []Task {
+	return peekN(g, n)
+}
+
+func (g *GangScheduler) CopyInto(dst Scheduler) {
+	copyInto(g, dst)
+}
+
+// Remove removes the task with the given id. If its gang has already been granted
+// resources, the resource granted to the task is returned to the pool.
+func (g *GangScheduler) Remove(id string) Task {
+	if _, ok := g.elementMap[id]; !ok {
+		return nil
+	}
+	delete(g.elementMap, id)
+	for i, st := range g.ready {
+		if st.Id() == id {
+			copy(g.ready[i:], g.ready[i+1:])
+			g.ready[len(g.ready)-1] = nil
+			g.ready = g.ready[:len(g.ready)-1]
+			st.Close()
+			return g.recordRemove(st.Task())
+		}
+	}
+	for i, gg := range g.gangs {
+		for j, t := range gg.tasks {
+			if t.Id() == id {
+				gg.tasks = append(gg.tasks[:j], gg.tasks[j+1:]...)
+				if len(gg.tasks) == 0 {
+					g.removeGang(i)
+				}
+				return g.recordRemove(t)
+			}
+		}
+	}
+	return nil
+}
+
+func (g *GangScheduler) Size() int {
+	return len(g.elementMap)
+}
+
+func (g *GangScheduler) Stats() SchedulerStats {
+	return g.stats(g.Size())
+}
+
+// Drain returns the tasks of the gang granted resources followed by the tasks
+// of the remaining gangs in the order they were first put. Resources already
+// granted are returned to the pool.
+func (g *GangScheduler) Drain() []Task {
+	tasks := []Task{}
+	for _, st := range g.ready {
+		tasks = append(tasks, st.Task())
+		st.Close()
+	}
+	for _, gg := range g.gangs {
+		tasks = append(tasks, gg.tasks...)
+	}
+	g.ready = []ScheduledTask{}
+	g.gangs = []*gang{}
+	g.elementMap = map[string]struct{}{}
+	return tasks
+}
+
+// Each visits the tasks of the gang granted resources followed by the tasks of
+// the remaining gangs in the order they were first put.
+func (g *GangScheduler) Each(f func(Task) bool) {
+	for _, st := range g.ready {
+		if !f(st.Task()) {
+			return
+		}
+	}
+	for _, gg := range g.gangs {
+		if !visit(gg.tasks, f) {
+			return
+		}
+	}
+}
+
+// Clear removes all pending tasks, returning any resources already granted to them.
+func (g *GangScheduler) Clear() {
+	g.Drain()
+}
@@ -0,0 +1,296 @@
+package schedule
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// heapItem is a task held in a taskHeap along with the key it is ordered by.
+type heapItem struct {
+	task  Task
+	key   int
+	seq   uint64
+	index int
+}
+
+// taskHeap implements heap.Interface, ordering items by ascending key and
+// breaking ties by insertion order.
+type taskHeap []*heapItem
+
+func (h taskHeap) Len() int { return len(h) }
+
+func (h taskHeap) Less(i, j int) bool {
+	if h[i].key != h[j].key {
+		return h[i].key < h[j].key
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h taskHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *taskHeap) Push(x interface{}) {
+	item := x.(*heapItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *taskHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil // clear the vacated slot so the task can be garbage collected
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+// comparatorHeap orders the items of a taskHeap with a less function over their
+// tasks rather than by key, breaking ties by insertion order.
+type comparatorHeap struct {
+	*taskHeap
+	less func(a, b Task) bool
+}
+
+func (h comparatorHeap) Less(i, j int) bool {
+	a, b := (*h.taskHeap)[i], (*h.taskHeap)[j]
+	if h.less(a.task, b.task) {
+		return true
+	}
+	if h.less(b.task, a.task) {
+		return false
+	}
+	return a.seq < b.seq
+}
+
+// heapScheduler returns tasks in ascending order of the key computed for each
+// task on insertion, or as ordered by less if it is set, breaking ties in first
+// in, first out order.
+type heapScheduler struct {
+	keyFunc    func(Task) int
+	less       func(a, b Task) bool
+	elements   taskHeap
+	elementMap map[string]*heapItem
+	seq        uint64
+	schedulerStatsRecorder
+}
+
+func newHeapScheduler(keyFunc func(Task) int) heapScheduler {
+	return heapScheduler{
+		keyFunc:    keyFunc,
+		elements:   taskHeap{},
+		elementMap: map[string]*heapItem{},
+	}
+}
+
+// order returns items as a heap.Interface ordered as the scheduler returns tasks.
+func (h *heapScheduler) order(items *taskHeap) heap.Interface {
+	if h.less == nil {
+		return items
+	}
+	return comparatorHeap{items, h.less}
+}
+
+func (h *heapScheduler) Contains(t Task) bool {
+	return h.ContainsId(t.Id())
+}
+
+func (h *heapScheduler) ContainsId(id string) bool {
+	_, ok := h.elementMap[id]
+	return ok
+}
+
+func (h *heapScheduler) Put(tasks ...Task) {
+	n := 0
+	for _, t := range tasks {
+		if _, ok := h.elementMap[t.Id()]; ok {
+			continue
+		}
+		item := &heapItem{task: t, key: h.keyFunc(t), seq: h.seq}
+		h.seq++
+		heap.Push(h.order(&h.elements), item)
+		h.elementMap[t.Id()] = item
+		n++
+	}
+	h.recordPut(n, len(h.elements))
+}
+
+func (h *heapScheduler) Next() ScheduledTask {
+	return h.recordNext(h.pop())
+}
+
+// pop removes and returns the task with the lowest key without counting it in Stats.
+func (h *heapScheduler) pop() ScheduledTask {
+	if len(h.elements) == 0 {
+		return nil
+	}
+	item := heap.Pop(h.order(&h.elements)).(*heapItem)
+	delete(h.elementMap, item.task.Id())
+	return &defaultScheduledTask{t: item.task}
+}
+
+func (h *heapScheduler) NextN(n int) []ScheduledTask {
+	return nextN(h, n)
+}
+
+func (h *heapScheduler) PeekN(n int) []Task {
+	return peekN(h, n)
+}
+
+func (h *heapScheduler) CopyInto(dst Scheduler) {
+	copyInto(h, dst)
+}
+
+func (h *heapScheduler) Each(f func(Task) bool) {
+	items := make(taskHeap, len(h.elements))
+	copy(items, h.elements)
+	sort.Slice(items, h.order(&items).Less)
+	for _, item := range items {
+		if !f(item.task) {
+			return
+		}
+	}
+}
+
+// EstimatedWaitMs returns the sum of the costs of the tasks ahead of the task with
+// the given id, or -1 if the scheduler does not contain it.
+func (h *heapScheduler) EstimatedWaitMs(id string, cost func(Task) int) int {
+	return estimatedWaitMs(h, id, cost)
+}
+
+func (h *heapScheduler) Drain() []Task {
+	return drain(h.pop)
+}
+
+func (h *heapScheduler) Remove(id string) Task {
+	item, ok := h.elementMap[id]
+	if !ok {
+		return nil
+	}
+	heap.Remove(h.order(&h.elements), item.index)
+	delete(h.elementMap, id)
+	return h.recordRemove(item.task)
+}
+
+func (h *heapScheduler) Size() int {
+	return len(h.elements)
+}
+
+func (h *heapScheduler) Stats() SchedulerStats {
+	return h.stats(h.Size())
+}
+
+func (h *heapScheduler) Clear() {
+	for i := range h.elements {
+		h.elements[i] = nil
+	}
+	h.elements = h.elements[:0]
+	for id := range h.elementMap {
+		delete(h.elementMap, id)
+	}
+	h.seq = 0
+}
+
+// A ShortestJobScheduler is a scheduler that returns tasks in ascending order of
+// their estimated cost. Tasks of equal cost are returned in first in, first out order.
+type ShortestJobScheduler struct {
+	heapScheduler
+}
+
+func NewShortestJobScheduler(cost func(Task) int) *ShortestJobScheduler {
+	return &ShortestJobScheduler{newHeapScheduler(cost)}
+}
+
+// An EarliestDeadlineScheduler is a scheduler that returns tasks in ascending order of
+// their absolute deadline in milliseconds. Tasks with equal deadlines are returned in
+// first in, first out order.
+type EarliestDeadlineScheduler struct {
+	heapScheduler
+}
+
+func NewEarliestDeadlineScheduler(deadline func(Task) int) *EarliestDeadlineScheduler {
+	return &EarliestDeadlineScheduler{newHeapScheduler(deadline)}
+}
+
+// Deadline returns the deadline of the task with the given id, computed when the task
+// was put. ok is false if the scheduler does not contain a task with that id.
+func (e *EarliestDeadlineScheduler) Deadline(id string) (deadline int, ok bool) {
+	item, ok := e.elementMap[id]
+	if !ok {
+		return 0, false
+	}
+	return item.key, true
+}
+
+// A PriorityScheduler is a scheduler that returns tasks in descending order of
+// their priority. Tasks of equal priority are returned in first in, first out order.
+type PriorityScheduler struct {
+	heapScheduler
+}
+
+// NewPriorityScheduler returns a PriorityScheduler ordering tasks by comparing their
+// priorities rather than negating them, so every int is a valid priority.
+func NewPriorityScheduler(priority func(Task) int) *PriorityScheduler {
+	h := newHeapScheduler(priority)
+	h.less = func(a, b Task) bool { return priority(a) > priority(b) }
+	return &PriorityScheduler{h}
+}
+
+// A ComparatorScheduler is a scheduler that returns tasks in the order given by a
+// less function, so an ordering needs no scheduler type of its own. Tasks neither
+// less than the other are returned in first in, first out order. Put and Next take
+// O(log n) time, as does Remove.
+type ComparatorScheduler struct {
+	heapScheduler
+}
+
+// NewComparatorScheduler returns a ComparatorScheduler returning a task before
+// another if less(a, b) is true. less must be a strict weak ordering.
+func NewComparatorScheduler(less func(a, b Task) bool) *ComparatorScheduler {
+	h := newHeapScheduler(func(Task) int { return 0 })
+	h.less = less
+	return &ComparatorScheduler{h}
+}
+
+// A DelayScheduler holds each task until its release time in milliseconds, as for
+// tasks scheduled to run at a later time. Next() returns released tasks in ascending
+// order of their release time, breaking ties in first in, first out order, and
+// returns nil while no task has been released.
+//
+// Time is virtual: tasks are only released by calls to Advance(), which makes the
+// scheduler suitable for simulation.
+type DelayScheduler struct {
+	heapScheduler
+	nowMs int
+}
+
+func NewDelayScheduler(release func(Task) int) *DelayScheduler {
+	return &DelayScheduler{newHeapScheduler(release), 0}
+}
+
+// Advance moves the scheduler's clock forward to nowMs milliseconds, releasing the
+// tasks whose release time has been reached. Moving the clock backwards has no effect.
+func (d *DelayScheduler) Advance(nowMs int) {
+	if nowMs > d.nowMs {
+		d.nowMs = nowMs
+	}
+}
+
+// Now returns the time the scheduler's clock was last advanced to.
+func (d *DelayScheduler) Now() int {
+	return d.nowMs
+}
+
+func (d *DelayScheduler) Next() ScheduledTask {
+	if len(d.elements) == 0 || d.elements[0].key > d.nowMs {
+		return nil
+	}
+	return d.heapScheduler.Next()
+}
+
+func (d *DelayScheduler) NextN(n int) []ScheduledTask {
+	return nextN(d, n)
+}
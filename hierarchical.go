@@ -0,0 +1,33 @@
+package schedule
+
+// A HierarchicalPartitioner is a function that takes a task and returns the key of
+// each level of its partition, from the outermost in, along with the priority of
+// the task and a factory for the scheduler of its innermost partition. Tasks sharing
+// a key at one level should have the same number of levels below it.
+type HierarchicalPartitioner func(t Task) (keys []string, priority uint, factory SchedulerFactory)
+
+// NewHierarchicalPartitionedScheduler returns a PartitionedScheduler whose partitions
+// form a tree, such as lanes partitioned by user, rather than requiring the nesting to
+// be built by hand through the factories of a Partitioner. Each level is itself a
+// PartitionedScheduler, so Next() round robins over the partitions of every level,
+// and each level serves the priority of the task within it. A task with no keys is put
+// in to a partition with an empty key.
+func NewHierarchicalPartitionedScheduler(p HierarchicalPartitioner) *PartitionedScheduler {
+	return NewPartitionedScheduler(p.level(0))
+}
+
+// level returns a Partitioner for the partitions at the given depth of the tree.
+func (p HierarchicalPartitioner) level(depth int) Partitioner {
+	return func(t Task) (string, uint, SchedulerFactory) {
+		keys, priority, factory := p(t)
+		if depth >= len(keys) {
+			return "", priority, factory
+		}
+		if depth == len(keys)-1 {
+			return keys[depth], priority, factory
+		}
+		return keys[depth], priority, func() Scheduler {
+			return NewPartitionedScheduler(p.level(depth + 1))
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package schedule
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// A LotteryScheduler partitions tasks like a PartitionedScheduler but selects the
+// partition to return a task from at random rather than round robinning. Within
+// the highest priority, each partition holds a number of tickets given by its key,
+// and Next() draws a ticket to choose the partition, so over many draws each partition
+// is served in proportion to its tickets. Keys without a positive ticket count hold
+// one ticket. The draws are determined by the seed.
+type LotteryScheduler struct {
+	partitioner Partitioner
+	tickets     map[string]int
+	rand        *rand.Rand
+	partitionSet
+	schedulerStatsRecorder
+}
+
+func NewLotteryScheduler(p Partitioner, tickets map[string]int, seed int64) *LotteryScheduler {
+	return &LotteryScheduler{p, tickets, rand.New(rand.NewSource(seed)), newPartitionSet(partitionKey(p)), schedulerStatsRecorder{}}
+}
+
+func (l *LotteryScheduler) ticketCount(key string) int {
+	if tickets := l.tickets[key]; tickets > 0 {
+		return tickets
+	}
+	return 1
+}
+
+func (l *LotteryScheduler) Put(tasks ...Task) {
+	before := l.Size()
+	for _, t := range tasks {
+		key, pri, fact := l.partitioner(t)
+		part := l.partition(key)
+		if part == nil {
+			part = l.add(key, pri, fact())
+		}
+		part.scheduler.Put(t)
+	}
+	l.recordPut(l.Size()-before, l.Size())
+}
+
+// Next draws among the partitions of the highest priority, redrawing without any
+// partition that returns nil before moving on to lower priorities.
+func (l *LotteryScheduler) Next() ScheduledTask {
+	tried := map[*keyedPartition]struct{}{}
+	for {
+		candidates := l.candidates(func(part *keyedPartition) bool {
+			_, ok := tried[part]
+			return !ok && part.scheduler.Size() > 0
+		})
+		if len(candidates) == 0 {
+			return nil
+		}
+
+		winner := l.draw(candidates)
+		if next := winner.scheduler.Next(); next != nil {
+			l.prune(nonEmpty)
+			return l.recordNext(next)
+		}
+		tried[winner] = struct{}{}
+	}
+}
+
+// candidates returns the partitions of the highest priority among those that are
+// eligible for a draw.
+func (l *LotteryScheduler) candidates(eligible func(*keyedPartition) bool) []*keyedPartition {
+	var pri uint
+	candidates := []*keyedPartition{}
+	for _, part := range l.partitions {
+		if !eligible(part) {
+			continue
+		}
+		if len(candidates) == 0 || part.priority > pri {
+			pri = part.priority
+			candidates = append(candidates[:0], part)
+		} else if part.priority == pri {
+			candidates = append(candidates, part)
+		}
+	}
+	return candidates
+}
+
+// draw returns the partition holding a randomly drawn ticket.
+func (l *LotteryScheduler) draw(candidates []*keyedPartition) *keyedPartition {
+	total := 0
+	for _, part := range candidates {
+		total += l.ticketCount(part.key)
+	}
+	ticket := l.rand.Intn(total)
+	for _, part := range candidates {
+		if ticket < l.ticketCount(part.key) {
+			return part
+		}
+		ticket -= l.ticketCount(part.key)
+	}
+	return candidates[len(candidates)-1]
+}
+
+func (l *LotteryScheduler) NextN(n int) []ScheduledTask {
+	return nextN(l, n)
+}
+
+// PeekN returns up to n tasks in the order Each visits them, which Next need not
+// follow within a priority.
+func (l *LotteryScheduler) PeekN(n int) []Task {
+	return peekN(l, n)
+}
+
+func (l *LotteryScheduler) CopyInto(dst Scheduler) {
+	copyInto(l, dst)
+}
+
+// Each visits the partitions from the highest priority down, in an arbitrary order
+// within each priority, as the order of Next is not determined until it is called.
+func (l *LotteryScheduler) Each(f func(Task) bool) {
+	partitions := make([]*keyedPartition, len(l.partitions))
+	copy(partitions, l.partitions)
+	sort.SliceStable(partitions, func(i, j int) bool {
+		return partitions[i].priority > partitions[j].priority
+	})
+	for _, part := range partitions {
+		if !visit(collect(part.scheduler), f) {
+			return
+		}
+	}
+}
+
+// Drain drains each partition and draws among them as Next would, without
+// requesting resources from partitions that manage them.
+func (l *LotteryScheduler) Drain() []Task {
+	drained := map[*keyedPartition][]Task{}
+	for _, part := range l.partitions {
+		drained[part] = part.scheduler.Drain()
+	}
+	tasks := []Task{}
+	for {
+		candidates := l.candidates(func(part *keyedPartition) bool {
+			return len(drained[part]) > 0
+		})
+		if len(candidates) == 0 {
+			break
+		}
+		winner := l.draw(candidates)
+		tasks = append(tasks, drained[winner][0])
+		drained[winner] = drained[winner][1:]
+	}
+	l.Clear()
+	return tasks
+}
+
+func (l *LotteryScheduler) Remove(id string) Task {
+	if t := l.remove(id); t != nil {
+		l.prune(nonEmpty)
+		return l.recordRemove(t)
+	}
+	return nil
+}
+
+func (l *LotteryScheduler) Stats() SchedulerStats {
+	return l.stats(l.Size())
+}
+
+func (l *LotteryScheduler) Clear() {
+	l.clear()
+}
@@ -0,0 +1,102 @@
+package schedule
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// taskTypes maps the names of the task types registered with RegisterTaskType
+// to their factories, and their types back to their names.
+var taskTypes = struct {
+	sync.RWMutex
+	factories map[string]func() Task
+	names     map[reflect.Type]string
+}{
+	factories: map[string]func() Task{},
+	names:     map[reflect.Type]string{},
+}
+
+// RegisterTaskType registers a concrete task type under the given name so tasks
+// of that type can be marshaled with MarshalTasks and revived by UnmarshalTasks.
+// The factory must return a pointer to a new zero value, which is decoded in to
+// with encoding/json, and the tasks marshaled must be of that pointer type.
+// Registering a name or type twice panics.
+func RegisterTaskType(name string, factory func() Task) {
+	typ := reflect.TypeOf(factory())
+	taskTypes.Lock()
+	defer taskTypes.Unlock()
+	if _, ok := taskTypes.factories[name]; ok {
+		panic(fmt.Sprintf("schedule: task type %q registered twice", name))
+	}
+	if _, ok := taskTypes.names[typ]; ok {
+		panic(fmt.Sprintf("schedule: task type %v registered twice", typ))
+	}
+	taskTypes.factories[name] = factory
+	taskTypes.names[typ] = name
+}
+
+// marshaledTask is the JSON representation of a task along with the name its
+// type is registered under.
+type marshaledTask struct {
+	Type string          `json:"type"`
+	Task json.RawMessage `json:"task"`
+}
+
+// MarshalTasks encodes the pending tasks of s as JSON in the order Next would
+// return them, without removing them. Each task must be of a registered type.
+func MarshalTasks(s Scheduler) ([]byte, error) {
+	marshaled := []marshaledTask{}
+	var err error
+	s.Each(func(t Task) bool {
+		taskTypes.RLock()
+		name, ok := taskTypes.names[reflect.TypeOf(t)]
+		taskTypes.RUnlock()
+		if !ok {
+			err = fmt.Errorf("schedule: task %s has unregistered type %T", t.Id(), t)
+			return false
+		}
+		var data []byte
+		if data, err = json.Marshal(t); err != nil {
+			return false
+		}
+		marshaled = append(marshaled, marshaledTask{name, data})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(marshaled)
+}
+
+// UnmarshalTasks decodes tasks encoded by MarshalTasks, in the order they were encoded.
+func UnmarshalTasks(data []byte) ([]Task, error) {
+	marshaled := []marshaledTask{}
+	if err := json.Unmarshal(data, &marshaled); err != nil {
+		return nil, err
+	}
+	tasks := make([]Task, len(marshaled))
+	for i, m := range marshaled {
+		taskTypes.RLock()
+		factory, ok := taskTypes.factories[m.Type]
+		taskTypes.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("schedule: unregistered task type %q", m.Type)
+		}
+		t := factory()
+		if err := json.Unmarshal(m.Task, t); err != nil {
+			return nil, err
+		}
+		tasks[i] = t
+	}
+	return tasks, nil
+}
+
+// NewFifoSchedulerWithTasks returns a FifoScheduler holding the given tasks, such
+// as those revived by UnmarshalTasks, so they are returned in the same order.
+func NewFifoSchedulerWithTasks(tasks ...Task) *FifoScheduler {
+	f := NewFifoScheduler()
+	f.Put(tasks...)
+	return f
+}
@@ -0,0 +1,63 @@
+package schedule
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshalTasks(t *testing.T) {
+	original := NewEarliestDeadlineScheduler(func(t Task) int { return t.(*SimTask).DeadlineMs })
+	original.Put(
+		&SimTask{Identifier: 1, UserId: 1, RuntimeMs: 10, DeadlineMs: 300},
+		&SimTask{Identifier: 2, UserId: 2, RuntimeMs: 20, ArrivalMs: 5, DeadlineMs: 100, Priority: 2},
+		&SimTask{Identifier: 3, UserId: 1, RuntimeMs: 30, ArrivalMs: 10, DeadlineMs: 200,
+			Releases: []SimRelease{{AtMs: 10, Resources: []int{1}}}},
+	)
+	data, err := MarshalTasks(original)
+	if err != nil {
+		t.Fatalf("expected no error, received %v", err)
+	}
+	expectSizeEquals(t, original, 3)
+
+	tasks, err := UnmarshalTasks(data)
+	if err != nil {
+		t.Fatalf("expected no error, received %v", err)
+	}
+	restored := NewFifoSchedulerWithTasks(tasks...)
+	expectSizeEquals(t, restored, 3)
+	for next := original.Next(); next != nil; next = original.Next() {
+		revived := restored.Next()
+		if revived == nil {
+			t.Fatal("expected not nil task")
+		}
+		if !reflect.DeepEqual(revived.Task(), next.Task()) {
+			t.Errorf("expected task %+v, received %+v", next.Task(), revived.Task())
+		}
+	}
+	expectNilTask(t, restored.Next())
+
+	// an empty scheduler round trips to no tasks
+	if data, err = MarshalTasks(NewFifoScheduler()); err != nil {
+		t.Fatalf("expected no error, received %v", err)
+	}
+	if tasks, err = UnmarshalTasks(data); err != nil || len(tasks) != 0 {
+		t.Errorf("expected no tasks, received %v and %v", tasks, err)
+	}
+
+	// tasks of unregistered types can't be marshaled or revived
+	if _, err = MarshalTasks(NewFifoSchedulerWithTasks(&SimTask{Identifier: 1}, testTask{2})); err == nil {
+		t.Error("expected error marshaling an unregistered task type")
+	}
+	if _, err = UnmarshalTasks([]byte(`[{"type":"unknown","task":{}}]`)); err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("expected error reviving an unregistered task type, received %v", err)
+	}
+
+	// registering a name twice panics
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic registering a task type twice")
+		}
+	}()
+	RegisterTaskType("SimTask", func() Task { return &SimTask{} })
+}
@@ -0,0 +1,136 @@
+package schedule
+
+// A SchedulerObserver holds callbacks for the lifecycle events of tasks passing
+// through an ObservableScheduler. Callbacks left nil are not called.
+type SchedulerObserver struct {
+	// OnPut is called for each task admitted to the scheduler.
+	OnPut func(Task)
+
+	// OnNext is called for each task returned from Next().
+	OnNext func(ScheduledTask)
+
+	// OnClose is called the first time a task returned from Next() is closed,
+	// after the ScheduledTask it wraps has been closed.
+	OnClose func(ScheduledTask)
+}
+
+// observedTask is a ScheduledTask that reports its first Close() to an observer.
+type observedTask struct {
+	st       ScheduledTask
+	observer *SchedulerObserver
+	closed   bool
+}
+
+func (o *observedTask) Task() Task { return o.st.Task() }
+
+func (o *observedTask) Id() string { return o.st.Id() }
+
+func (o *observedTask) release(res []int) bool { return releasePartial(o.st, res) }
+
+func (o *observedTask) returnResource() bool { return returnEarly(o.st) }
+
+func (o *observedTask) OnComplete(f func()) { onComplete(o.st, f) }
+
+// Close closes the ScheduledTask it wraps and calls OnClose if this is the first call.
+func (o *observedTask) Close() {
+	o.st.Close()
+	if o.closed {
+		return
+	}
+	o.closed = true
+	if o.observer.OnClose != nil {
+		o.observer.OnClose(o)
+	}
+}
+
+// An ObservableScheduler passes tasks to and from the underlying scheduler unchanged,
+// calling the callbacks of its observer as they are put, returned from Next() and
+// closed. Tasks removed or drained from the scheduler are not reported.
+type ObservableScheduler struct {
+	underlying Scheduler
+	observer   SchedulerObserver
+	schedulerStatsRecorder
+}
+
+func NewObservableScheduler(underlying Scheduler, observer SchedulerObserver) *ObservableScheduler {
+	return &ObservableScheduler{underlying, observer, schedulerStatsRecorder{}}
+}
+
+func (o *ObservableScheduler) Contains(t Task) bool {
+	return o.underlying.Contains(t)
+}
+
+func (o *ObservableScheduler) ContainsId(id string) bool {
+	return o.underlying.ContainsId(id)
+}
+
+// Put puts each task in turn, calling OnPut for those the underlying scheduler admits.
+func (o *ObservableScheduler) Put(tasks ...Task) {
+	n := 0
+	for _, t := range tasks {
+		if o.underlying.Contains(t) {
+			continue
+		}
+		o.underlying.Put(t)
+		if !o.underlying.Contains(t) {
+			continue
+		}
+		n++
+		if o.observer.OnPut != nil {
+			o.observer.OnPut(t)
+		}
+	}
+	o.recordPut(n, o.Size())
+}
+
+func (o *ObservableScheduler) Next() ScheduledTask {
+	next := o.underlying.Next()
+	if next == nil {
+		return nil
+	}
+	observed := &observedTask{next, &o.observer, false}
+	if o.observer.OnNext != nil {
+		o.observer.OnNext(observed)
+	}
+	return o.recordNext(observed)
+}
+
+func (o *ObservableScheduler) NextN(n int) []ScheduledTask {
+	return nextN(o, n)
+}
+
+func (o *ObservableScheduler) PeekN(n int) []Task {
+	return peekN(o, n)
+}
+
+func (o *ObservableScheduler) CopyInto(dst Scheduler) {
+	copyInto(o, dst)
+}
+
+func (o *ObservableScheduler) Underlying() Scheduler {
+	return o.underlying
+}
+
+func (o *ObservableScheduler) Each(f func(Task) bool) {
+	o.underlying.Each(f)
+}
+
+func (o *ObservableScheduler) Drain() []Task {
+	return o.underlying.Drain()
+}
+
+func (o *ObservableScheduler) Remove(id string) Task {
+	return o.recordRemove(o.underlying.Remove(id))
+}
+
+func (o *ObservableScheduler) Size() int {
+	return o.underlying.Size()
+}
+
+func (o *ObservableScheduler) Stats() SchedulerStats {
+	return o.stats(o.Size())
+}
+
+func (o *ObservableScheduler) Clear() {
+	o.underlying.Clear()
+}
@@ -0,0 +1,130 @@
+package schedule
+
+// keyedPartition is a partition of the tasks of a scheduler that partitions them by
+// key, holding them in a scheduler of its own. deficit is only used by the
+// DeficitRoundRobinScheduler and finish by the WeightedFairScheduler.
+type keyedPartition struct {
+	key       string
+	priority  uint
+	scheduler Scheduler
+	deficit   int
+	finish    float64
+}
+
+// partitionSet holds the partitions of a scheduler that partitions tasks by key, in
+// the order they were created, and keeps the bookkeeping such schedulers share. A
+// scheduler embedding it looks in to every partition for Contains, ContainsId and Size.
+type partitionSet struct {
+	key        func(Task) string
+	partitions []*keyedPartition
+}
+
+func newPartitionSet(key func(Task) string) partitionSet {
+	return partitionSet{key, []*keyedPartition{}}
+}
+
+// partitionKey returns the key p partitions a task by.
+func partitionKey(p Partitioner) func(Task) string {
+	return func(t Task) string {
+		key, _, _ := p(t)
+		return key
+	}
+}
+
+// partition returns the partition with the given key, or nil if there is none.
+func (s *partitionSet) partition(key string) *keyedPartition {
+	for _, part := range s.partitions {
+		if part.key == key {
+			return part
+		}
+	}
+	return nil
+}
+
+// add appends a new partition with the given key, priority and scheduler.
+func (s *partitionSet) add(key string, priority uint, scheduler Scheduler) *keyedPartition {
+	part := &keyedPartition{key: key, priority: priority, scheduler: scheduler}
+	s.partitions = append(s.partitions, part)
+	return part
+}
+
+func (s *partitionSet) Contains(t Task) bool {
+	if part := s.partition(s.key(t)); part != nil {
+		return part.scheduler.Contains(t)
+	}
+	return false
+}
+
+// ContainsId looks for the id in every partition, as the key of its partition can
+// only be found from the task itself.
+func (s *partitionSet) ContainsId(id string) bool {
+	for _, part := range s.partitions {
+		if part.scheduler.ContainsId(id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *partitionSet) Size() (size int) {
+	for _, part := range s.partitions {
+		size += part.scheduler.Size()
+	}
+	return
+}
+
+// remove removes the task with the given id from the partition holding it, returning
+// nil if there is none. Emptied partitions are left for the caller to prune.
+func (s *partitionSet) remove(id string) Task {
+	for _, part := range s.partitions {
+		if t := part.scheduler.Remove(id); t != nil {
+			return t
+		}
+	}
+	return nil
+}
+
+// nonEmpty returns true if part holds tasks.
+func nonEmpty(part *keyedPartition) bool {
+	return part.scheduler.Size() > 0
+}
+
+// prune discards the partitions keep returns false for, keeping the rest in order.
+func (s *partitionSet) prune(keep func(*keyedPartition) bool) {
+	s.pruneAt(keep, 0)
+}
+
+// pruneAt prunes like prune and returns the new position of the partition at pos,
+// or of the next one kept if it was discarded, wrapping around to the first.
+// discarded is true if the partition at pos was discarded.
+func (s *partitionSet) pruneAt(keep func(*keyedPartition) bool, pos int) (next int, discarded bool) {
+	kept := s.partitions[:0]
+	for i, part := range s.partitions {
+		if !keep(part) {
+			if i == pos {
+				discarded = true
+			}
+			continue
+		}
+		if i < pos {
+			next++
+		}
+		kept = append(kept, part)
+	}
+	for i := len(kept); i < len(s.partitions); i++ {
+		s.partitions[i] = nil
+	}
+	s.partitions = kept
+	if next >= len(s.partitions) {
+		next = 0
+	}
+	return
+}
+
+// clear discards every partition.
+func (s *partitionSet) clear() {
+	for i := range s.partitions {
+		s.partitions[i] = nil
+	}
+	s.partitions = s.partitions[:0]
+}
@@ -0,0 +1,229 @@
+package schedule
+
+// quotaTask is a ScheduledTask that releases its key's quota in the QuotaScheduler
+// it was emitted from upon Close().
+type quotaTask struct {
+	st        ScheduledTask
+	scheduler *QuotaScheduler
+	key       string
+	closed    bool
+}
+
+func (q *quotaTask) Task() Task { return q.st.Task() }
+
+func (q *quotaTask) Id() string { return q.st.Id() }
+
+func (q *quotaTask) release(res []int) bool { return releasePartial(q.st, res) }
+
+func (q *quotaTask) returnResource() bool { return returnEarly(q.st) }
+
+func (q *quotaTask) OnComplete(f func()) { onComplete(q.st, f) }
+
+// Close closes the ScheduledTask it wraps and, the first time it is called,
+// frees a slot of its key's quota.
+func (q *quotaTask) Close() {
+	q.st.Close()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.scheduler.release(q.key)
+}
+
+// A QuotaScheduler caps the number of tasks of each key that may run at once, such
+// as the tasks of each user in a scheduler partitioned by user. A task is running
+// from when it is returned from Next() until it is closed. Tasks taken from the
+// underlying scheduler whose key is at its limit are held, in the order they were
+// taken, and returned ahead of the underlying scheduler once their key has room.
+// The held tasks of each key are capped, so a key with many pending tasks does not
+// move the whole underlying scheduler in to the held tasks, and under a
+// ResourceManagedScheduler only the held tasks are granted resources they cannot
+// yet use. Tasks behind a key at its cap wait in the underlying scheduler, in its
+// order, until the key has room.
+type QuotaScheduler struct {
+	underlying Scheduler
+	key        func(Task) string
+	limit      int
+	maxHeld    int
+	running    map[string]int
+	held       []ScheduledTask
+	schedulerStatsRecorder
+}
+
+// defaultQuotaMaxHeld is the number of tasks of each key a QuotaScheduler holds by default.
+const defaultQuotaMaxHeld = 16
+
+// NewQuotaScheduler returns a QuotaScheduler that runs at most limit tasks of each
+// key at once and holds at most 16 more of each key. A limit below 1 is treated as 1.
+func NewQuotaScheduler(underlying Scheduler, key func(Task) string, limit int) *QuotaScheduler {
+	return NewQuotaSchedulerWithLookahead(underlying, key, limit, defaultQuotaMaxHeld)
+}
+
+// NewQuotaSchedulerWithLookahead returns a QuotaScheduler that holds at most maxHeld
+// tasks of each key while the key is at its limit, looking past them in the
+// underlying scheduler for tasks of keys with room. A limit or maxHeld below 1 is
+// treated as 1.
+func NewQuotaSchedulerWithLookahead(underlying Scheduler, key func(Task) string, limit, maxHeld int) *QuotaScheduler {
+	if limit < 1 {
+		limit = 1
+	}
+	if maxHeld < 1 {
+		maxHeld = 1
+	}
+	return &QuotaScheduler{underlying, key, limit, maxHeld, map[string]int{}, []ScheduledTask{}, schedulerStatsRecorder{}}
+}
+
+// Running returns the number of tasks of the given key that are running.
+func (q *QuotaScheduler) Running(key string) int {
+	return q.running[key]
+}
+
+func (q *QuotaScheduler) release(key string) {
+	q.running[key]--
+	if q.running[key] <= 0 {
+		delete(q.running, key)
+	}
+}
+
+func (q *QuotaScheduler) Contains(t Task) bool {
+	return q.ContainsId(t.Id())
+}
+
+func (q *QuotaScheduler) ContainsId(id string) bool {
+	for _, h := range q.held {
+		if h.Id() == id {
+			return true
+		}
+	}
+	return q.underlying.ContainsId(id)
+}
+
+func (q *QuotaScheduler) Put(tasks ...Task) {
+	before := q.Size()
+	for _, t := range tasks {
+		if !q.Contains(t) {
+			q.underlying.Put(t)
+		}
+	}
+	q.recordPut(q.Size()-before, q.Size())
+}
+
+func (q *QuotaScheduler) Next() ScheduledTask {
+	for i, h := range q.held {
+		if key := q.key(h.Task()); q.running[key] < q.limit {
+			q.removeHeld(i)
+			return q.emit(h, key)
+		}
+	}
+	for {
+		head := q.underlying.PeekN(1)
+		if len(head) == 0 {
+			return nil
+		}
+		if key := q.key(head[0]); q.running[key] >= q.limit && q.heldOf(key) >= q.maxHeld {
+			return nil
+		}
+		next := q.underlying.Next()
+		if next == nil {
+			return nil
+		}
+		if key := q.key(next.Task()); q.running[key] < q.limit {
+			return q.emit(next, key)
+		}
+		q.held = append(q.held, next)
+	}
+}
+
+// heldOf returns the number of held tasks of the given key.
+func (q *QuotaScheduler) heldOf(key string) (n int) {
+	for _, h := range q.held {
+		if q.key(h.Task()) == key {
+			n++
+		}
+	}
+	return
+}
+
+func (q *QuotaScheduler) emit(st ScheduledTask, key string) ScheduledTask {
+	q.running[key]++
+	return q.recordNext(&quotaTask{st, q, key, false})
+}
+
+func (q *QuotaScheduler) removeHeld(i int) {
+	last := len(q.held) - 1
+	copy(q.held[i:], q.held[i+1:])
+	q.held[last] = nil
+	q.held = q.held[:last]
+}
+
+func (q *QuotaScheduler) NextN(n int) []ScheduledTask {
+	return nextN(q, n)
+}
+
+func (q *QuotaScheduler) PeekN(n int) []Task {
+	return peekN(q, n)
+}
+
+func (q *QuotaScheduler) CopyInto(dst Scheduler) {
+	copyInto(q, dst)
+}
+
+func (q *QuotaScheduler) Underlying() Scheduler {
+	return q.underlying
+}
+
+// Each visits the held tasks followed by the tasks of the underlying scheduler.
+func (q *QuotaScheduler) Each(f func(Task) bool) {
+	for _, h := range q.held {
+		if !f(h.Task()) {
+			return
+		}
+	}
+	q.underlying.Each(f)
+}
+
+// Drain returns the held tasks followed by the tasks of the underlying scheduler.
+// The held tasks are dropped as with Remove.
+func (q *QuotaScheduler) Drain() []Task {
+	tasks := []Task{}
+	for i, h := range q.held {
+		tasks = append(tasks, h.Task())
+		returnEarly(h)
+		q.held[i] = nil
+	}
+	q.held = q.held[:0]
+	return append(tasks, q.underlying.Drain()...)
+}
+
+// Remove removes the task with the given id. If it is held, the resource of the
+// ScheduledTask it was emitted from the underlying scheduler with is returned, but
+// the ScheduledTask is not closed, as the task never ran.
+func (q *QuotaScheduler) Remove(id string) Task {
+	for i, h := range q.held {
+		if h.Id() == id {
+			q.removeHeld(i)
+			returnEarly(h)
+			return q.recordRemove(h.Task())
+		}
+	}
+	return q.recordRemove(q.underlying.Remove(id))
+}
+
+func (q *QuotaScheduler) Size() int {
+	return len(q.held) + q.underlying.Size()
+}
+
+func (q *QuotaScheduler) Stats() SchedulerStats {
+	return q.stats(q.Size())
+}
+
+// Clear removes all pending tasks, including those held, which are dropped as with
+// Remove. Running tasks still count against their key's quota until they are closed.
+func (q *QuotaScheduler) Clear() {
+	for i, h := range q.held {
+		returnEarly(h)
+		q.held[i] = nil
+	}
+	q.held = q.held[:0]
+	q.underlying.Clear()
+}
@@ -0,0 +1,118 @@
+package schedule
+
+import (
+	"math/rand"
+)
+
+// A RandomScheduler is a scheduler that returns a uniformly random task on each
+// call to Next(). The order is determined by the seed, so schedulers created with
+// the same seed and given the same sequence of calls return tasks in the same order.
+type RandomScheduler struct {
+	elements   []Task
+	elementMap map[string]int
+	rand       *rand.Rand
+	schedulerStatsRecorder
+}
+
+func NewRandomScheduler(seed int64) *RandomScheduler {
+	return &RandomScheduler{
+		elements:   []Task{},
+		elementMap: map[string]int{},
+		rand:       rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (r *RandomScheduler) Contains(t Task) bool {
+	return r.ContainsId(t.Id())
+}
+
+func (r *RandomScheduler) ContainsId(id string) bool {
+	_, ok := r.elementMap[id]
+	return ok
+}
+
+func (r *RandomScheduler) Put(tasks ...Task) {
+	n := 0
+	for _, t := range tasks {
+		if _, ok := r.elementMap[t.Id()]; !ok {
+			r.elementMap[t.Id()] = len(r.elements)
+			r.elements = append(r.elements, t)
+			n++
+		}
+	}
+	r.recordPut(n, len(r.elements))
+}
+
+func (r *RandomScheduler) Next() ScheduledTask {
+	return r.recordNext(r.pop())
+}
+
+// pop removes and returns a random task without counting it in Stats.
+func (r *RandomScheduler) pop() ScheduledTask {
+	if len(r.elements) == 0 {
+		return nil
+	}
+	return &defaultScheduledTask{t: r.removeAt(r.rand.Intn(len(r.elements)))}
+}
+
+func (r *RandomScheduler) NextN(n int) []ScheduledTask {
+	return nextN(r, n)
+}
+
+// PeekN returns up to n tasks in the order Each visits them, which Next need not
+// follow.
+func (r *RandomScheduler) PeekN(n int) []Task {
+	return peekN(r, n)
+}
+
+func (r *RandomScheduler) CopyInto(dst Scheduler) {
+	copyInto(r, dst)
+}
+
+// Each visits the tasks in an arbitrary order, as the order of Next is not
+// determined until it is called.
+func (r *RandomScheduler) Each(f func(Task) bool) {
+	visit(r.elements, f)
+}
+
+func (r *RandomScheduler) Drain() []Task {
+	return drain(r.pop)
+}
+
+func (r *RandomScheduler) Remove(id string) Task {
+	idx, ok := r.elementMap[id]
+	if !ok {
+		return nil
+	}
+	return r.recordRemove(r.removeAt(idx))
+}
+
+// removeAt removes the task at idx by swapping the last task in to its place.
+func (r *RandomScheduler) removeAt(idx int) Task {
+	t := r.elements[idx]
+	last := len(r.elements) - 1
+	r.elements[idx] = r.elements[last]
+	r.elementMap[r.elements[idx].Id()] = idx
+	r.elements[last] = nil
+	r.elements = r.elements[:last]
+	delete(r.elementMap, t.Id())
+	return t
+}
+
+func (r *RandomScheduler) Size() int {
+	return len(r.elements)
+}
+
+func (r *RandomScheduler) Stats() SchedulerStats {
+	return r.stats(r.Size())
+}
+
+func (r *RandomScheduler) Clear() {
+	for i := range r.elements {
+		r.elements[i] = nil
+	}
+	r.elements = r.elements[:0]
+	for id := range r.elementMap {
+		delete(r.elementMap, id)
+	}
+}
@@ -0,0 +1,107 @@
+package schedule
+
+// A RateLimitedScheduler meters tasks out of the underlying scheduler using a
+// token bucket. Each task returned from Next() consumes a token, and Next()
+// returns nil while the bucket is empty. The bucket holds at most capacity
+// tokens and is refilled at a rate of refill tokens per millisecond.
+//
+// Time is virtual: the bucket is only refilled by calls to Advance(), which
+// makes the scheduler suitable for simulation.
+type RateLimitedScheduler struct {
+	underlying Scheduler
+	capacity   int
+	refill     int
+	tokens     int
+	schedulerStatsRecorder
+}
+
+// NewRateLimitedScheduler returns a RateLimitedScheduler whose bucket starts full.
+func NewRateLimitedScheduler(underlying Scheduler, capacity, refill int) *RateLimitedScheduler {
+	return &RateLimitedScheduler{underlying, capacity, refill, capacity, schedulerStatsRecorder{}}
+}
+
+// Advance moves the scheduler's clock forward by ms milliseconds, refilling
+// the bucket up to its capacity.
+func (r *RateLimitedScheduler) Advance(ms int) {
+	if ms <= 0 {
+		return
+	}
+	if r.refill > 0 && ms > (r.capacity-r.tokens)/r.refill {
+		r.tokens = r.capacity
+		return
+	}
+	r.tokens += ms * r.refill
+}
+
+// Tokens returns the number of tokens currently available.
+func (r *RateLimitedScheduler) Tokens() int {
+	return r.tokens
+}
+
+func (r *RateLimitedScheduler) Contains(t Task) bool {
+	return r.underlying.Contains(t)
+}
+
+func (r *RateLimitedScheduler) ContainsId(id string) bool {
+	return r.underlying.ContainsId(id)
+}
+
+func (r *RateLimitedScheduler) Put(tasks ...Task) {
+	before := r.Size()
+	r.underlying.Put(tasks...)
+	r.recordPut(r.Size()-before, r.Size())
+}
+
+func (r *RateLimitedScheduler) Next() ScheduledTask {
+	if r.tokens < 1 {
+		return nil
+	}
+	next := r.underlying.Next()
+	if next != nil {
+		r.tokens--
+	}
+	return r.recordNext(next)
+}
+
+func (r *RateLimitedScheduler) NextN(n int) []ScheduledTask {
+	return nextN(r, n)
+}
+
+func (r *RateLimitedScheduler) PeekN(n int) []Task {
+	return peekN(r, n)
+}
+
+func (r *RateLimitedScheduler) CopyInto(dst Scheduler) {
+	copyInto(r, dst)
+}
+
+func (r *RateLimitedScheduler) Underlying() Scheduler {
+	return r.underlying
+}
+
+func (r *RateLimitedScheduler) Each(f func(Task) bool) {
+	r.underlying.Each(f)
+}
+
+// Drain drains the underlying scheduler without consuming any tokens.
+func (r *RateLimitedScheduler) Drain() []Task {
+	return r.underlying.Drain()
+}
+
+func (r *RateLimitedScheduler) Remove(id string) Task {
+	return r.recordRemove(r.underlying.Remove(id))
+}
+
+func (r *RateLimitedScheduler) Size() int {
+	return r.underlying.Size()
+}
+
+func (r *RateLimitedScheduler) Stats() SchedulerStats {
+	return r.stats(r.Size())
+}
+
+// Clear removes all tasks from the underlying scheduler and refills the bucket.
+func (r *RateLimitedScheduler) Clear() {
+	r.underlying.Clear()
+	r.tokens = r.capacity
+}
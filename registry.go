@@ -0,0 +1,34 @@
+package schedule
+
+import "sync"
+
+// factories maps the names registered with RegisterFactory to their factories.
+var factories = struct {
+	mut    sync.RWMutex
+	byName map[string]SchedulerFactory
+}{byName: map[string]SchedulerFactory{
+	"fifo": func() Scheduler { return NewFifoScheduler() },
+	"lifo": func() Scheduler { return NewLifoScheduler() },
+}}
+
+// RegisterFactory registers f under name so it can be looked up with FactoryByName,
+// as when a simulation selects its policies by name. Registering a name again
+// replaces its factory. The names "fifo" and "lifo" are registered with factories
+// for a FifoScheduler and a LifoScheduler; schedulers that need arguments, such as
+// a ShortestJobScheduler and its cost function, must be registered by the caller.
+func RegisterFactory(name string, f SchedulerFactory) {
+	if f == nil {
+		panic("schedule: RegisterFactory called with a nil factory for " + name)
+	}
+	factories.mut.Lock()
+	defer factories.mut.Unlock()
+	factories.byName[name] = f
+}
+
+// FactoryByName returns the factory registered under name, and false if there is none.
+func FactoryByName(name string) (SchedulerFactory, bool) {
+	factories.mut.RLock()
+	defer factories.mut.RUnlock()
+	f, ok := factories.byName[name]
+	return f, ok
+}
@@ -1,6 +1,7 @@
 package schedule
 
 import (
+	"sort"
 	"sync"
 )
 
@@ -21,6 +22,18 @@ type ResourcePool interface {
 	Request(r Resource) Resource
 }
 
+// A ResourceVectorPool is a ResourcePool of resources indexed by position, as
+// created by NewResourceVectorPool.
+type ResourceVectorPool interface {
+	ResourcePool
+
+	// Available returns a copy of the resources not currently granted.
+	Available() []int
+
+	// Capacity returns a copy of the resources the pool was created with.
+	Capacity() []int
+}
+
 type resourceVector struct {
 	pool      *resourceVectorPool
 	resources []int
@@ -35,39 +48,476 @@ func (r *resourceVector) Return() bool {
 	return true
 }
 
+// A GrantedResource is a Resource that reports how much it holds, such as those
+// granted by a pool created with NewResourceVectorPool.
+type GrantedResource interface {
+	Resource
+
+	// Granted returns a copy of the resources held. For a resource granted by
+	// RequestPartial this may be less than was requested, and it shrinks as
+	// resources are released ahead of Return.
+	Granted() []int
+}
+
+func (r *resourceVector) Granted() []int {
+	if pool := r.pool; pool != nil {
+		pool.mut.Lock()
+		defer pool.mut.Unlock()
+	}
+	granted := make([]int, len(r.resources))
+	copy(granted, r.resources)
+	return granted
+}
+
 func NewResourceVectorRequest(res []int) Resource {
 	return &resourceVector{pool: nil, resources: res}
 }
 
+// A CloneableResource is a Resource that can be copied in to a request of the same
+// resources, such as those created by NewResourceVectorRequest or granted by a pool
+// created with NewResourceVectorPool.
+type CloneableResource interface {
+	Resource
+
+	// Clone returns a request for the resources held by the Resource that is
+	// independent of it and not attached to any pool.
+	Clone() Resource
+}
+
+func (r *resourceVector) Clone() Resource {
+	return NewResourceVectorRequest(r.Granted())
+}
+
+// EqualResources returns true if a and b are resource vectors holding the same
+// resources, whether they are requests or have been granted by a pool. Other
+// resources are equal only if they are the same resource.
+func EqualResources(a, b Resource) bool {
+	va, okA := a.(*resourceVector)
+	vb, okB := b.(*resourceVector)
+	if !okA || !okB {
+		return a == b
+	}
+	ra, rb := va.Granted(), vb.Granted()
+	if len(ra) != len(rb) {
+		return false
+	}
+	for i := range ra {
+		if ra[i] != rb[i] {
+			return false
+		}
+	}
+	return true
+}
+
 type resourceVectorPool struct {
-	mut       *sync.Mutex
-	resources []int
+	mut         *sync.Mutex
+	resources   []int
+	capacity    []int
+	outstanding map[*resourceVector]outstandingGrant
+	grants      uint64
+	preempt     PreemptFunc
+	floor       []int
+	expiries    map[*resourceVector]int
+	nowMs       int
+	stats       poolStatsRecorder
+}
+
+// outstandingGrant records the priority and order of an outstanding resource.
+type outstandingGrant struct {
+	priority int
+	seq      uint64
+}
+
+// exceedsCapacity returns true if the pool could never grant the request, even with
+// all of its resources available. Pools that don't report their capacity never do.
+func exceedsCapacity(pool ResourcePool, res Resource) bool {
+	if set, ok := pool.(*poolSet); ok {
+		if b, ok := res.(*resourceBundle); ok && len(b.resources) == len(set.pools) {
+			for i, r := range b.resources {
+				if r != nil && exceedsCapacity(set.pools[i], r) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	p, ok := pool.(ResourceVectorPool)
+	if !ok {
+		return false
+	}
+	v, ok := res.(*resourceVector)
+	if !ok {
+		return false
+	}
+	capacity := p.Capacity()
+	if len(v.resources) != len(capacity) {
+		return true
+	}
+	if vp, ok := p.(*resourceVectorPool); ok {
+		for i := range capacity {
+			capacity[i] -= vp.floor[i]
+		}
+	}
+	for i := range capacity {
+		if v.resources[i] > capacity[i] {
+			return true
+		}
+	}
+	return false
 }
 
+// A PreemptionCandidate is a resource granted from a pool along with the
+// priority it was requested with.
+type PreemptionCandidate struct {
+	Resource Resource
+	Priority int
+}
+
+// A PreemptFunc is called by a pool that cannot grant a request. It receives the
+// priority of the request and the outstanding resources granted with a lower
+// priority, lowest priority and then oldest first, and may call Return() on any of them to make
+// room for the request. The pool is not locked while it is called.
+type PreemptFunc func(priority int, candidates []PreemptionCandidate)
+
 func NewResourceVectorPool(resources []int) *resourceVectorPool {
-	return &resourceVectorPool{&sync.Mutex{}, resources}
+	return NewPreemptibleResourceVectorPool(resources, nil)
 }
 
+// NewPreemptibleResourceVectorPool returns a pool that calls preempt when a
+// request cannot be granted, then retries the request once.
+func NewPreemptibleResourceVectorPool(resources []int, preempt PreemptFunc) *resourceVectorPool {
+	capacity := make([]int, len(resources))
+	copy(capacity, resources)
+	floor := make([]int, len(resources))
+	return &resourceVectorPool{&sync.Mutex{}, resources, capacity, map[*resourceVector]outstandingGrant{}, 0, preempt, floor, map[*resourceVector]int{}, 0,
+		poolStatsRecorder{deniedBy: make([]int, len(resources))}}
+}
+
+// NewOvercommittedResourceVectorPool returns a pool that grants requests as long as
+// the resources left in each dimension stay at or above floor, which is typically
+// negative to model oversubscription. Available() reports the negative balance of
+// an oversubscribed pool. Dimensions missing from floor have a floor of zero.
+func NewOvercommittedResourceVectorPool(resources []int, floor []int) *resourceVectorPool {
+	r := NewResourceVectorPool(resources)
+	copy(r.floor, floor)
+	return r
+}
+
+// Request requests the resource with a priority of zero.
 func (r *resourceVectorPool) Request(res Resource) Resource {
+	return r.RequestWithPriority(res, 0)
+}
+
+// RequestWithPriority requests the resource on behalf of a task with the given
+// priority. If the request cannot be granted and the pool has a PreemptFunc, it
+// is called with the outstanding resources of lower priority.
+func (r *resourceVectorPool) RequestWithPriority(res Resource, priority int) Resource {
 	v, ok := res.(*resourceVector)
 	if !ok || len(v.resources) != len(r.resources) {
 		return nil
 	}
+	r.mut.Lock()
+	if granted := r.grant(v.resources, priority); granted != nil || r.preempt == nil {
+		r.stats.recordRequest(r, v.resources, granted != nil)
+		r.mut.Unlock()
+		return granted
+	}
+	lower := []*resourceVector{}
+	for c, g := range r.outstanding {
+		if g.priority < priority {
+			lower = append(lower, c)
+		}
+	}
+	sort.Slice(lower, func(i, j int) bool {
+		gi, gj := r.outstanding[lower[i]], r.outstanding[lower[j]]
+		return gi.priority < gj.priority || (gi.priority == gj.priority && gi.seq < gj.seq)
+	})
+	candidates := make([]PreemptionCandidate, len(lower))
+	for i, c := range lower {
+		candidates[i] = PreemptionCandidate{c, r.outstanding[c].priority}
+	}
+	if len(candidates) == 0 {
+		r.stats.recordRequest(r, v.resources, false)
+		r.mut.Unlock()
+		return nil
+	}
+	r.mut.Unlock()
+	r.preempt(priority, candidates)
+
 	r.mut.Lock()
 	defer r.mut.Unlock()
+	granted := r.grant(v.resources, priority)
+	r.stats.recordRequest(r, v.resources, granted != nil)
+	return granted
+}
+
+// grant removes the requested resources from the pool and returns them as an
+// outstanding resource, or nil if they are not available. The caller must hold
+// the lock.
+func (r *resourceVectorPool) grant(requested []int, priority int) Resource {
+	v := r.take(requested)
+	if v == nil {
+		return nil
+	}
+	r.track(v, priority)
+	return v
+}
+
+// take removes the requested resources from the pool and returns them without
+// tracking them as outstanding, or nil if they are not available. The caller must
+// hold the lock.
+func (r *resourceVectorPool) take(requested []int) *resourceVector {
 	for i := range r.resources {
-		if v.resources[i] > r.resources[i] {
+		if requested[i] > r.resources[i]-r.floor[i] {
 			return nil
 		}
 	}
 	for i := range r.resources {
-		r.resources[i] -= v.resources[i]
+		r.resources[i] -= requested[i]
 	}
-	resources := make([]int, len(v.resources))
-	copy(resources, v.resources)
+	resources := make([]int, len(requested))
+	copy(resources, requested)
 	return &resourceVector{r, resources}
 }
 
+// track records a granted resource as outstanding. The caller must hold the lock.
+func (r *resourceVectorPool) track(v *resourceVector, priority int) {
+	r.grants++
+	r.outstanding[v] = outstandingGrant{priority, r.grants}
+}
+
+// RequestAll grants every request with a priority of zero, or none of them. If any
+// request cannot be granted, the resources taken for the others are put back and
+// it returns false, leaving the pool as it was. Each granted resource is returned
+// independently. Unlike Request it never calls the PreemptFunc.
+func (r *resourceVectorPool) RequestAll(reqs ...Resource) ([]Resource, bool) {
+	requested := make([]*resourceVector, len(reqs))
+	for i, res := range reqs {
+		v, ok := res.(*resourceVector)
+		if !ok || len(v.resources) != len(r.resources) {
+			return nil, false
+		}
+		requested[i] = v
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	taken := make([]*resourceVector, 0, len(requested))
+	for _, v := range requested {
+		t := r.take(v.resources)
+		if t == nil {
+			for _, t := range taken {
+				for i := range r.resources {
+					r.resources[i] += t.resources[i]
+				}
+			}
+			return nil, false
+		}
+		taken = append(taken, t)
+	}
+	granted := make([]Resource, len(taken))
+	for i, t := range taken {
+		r.track(t, 0)
+		granted[i] = t
+	}
+	return granted, true
+}
+
+// A Reservation holds resources taken from a pool for a task that is not yet
+// ready to run. The reserved resources are unavailable to other requests until
+// the reservation is cancelled, or until the resource it is committed to is returned.
+type Reservation interface {
+	// Commit grants the reserved resources as a Resource, to be returned to the
+	// pool like any other. It returns nil if the reservation has already been
+	// committed or cancelled.
+	Commit() Resource
+
+	// Cancel returns the reserved resources to the pool. It does nothing if the
+	// reservation has already been committed or cancelled.
+	Cancel()
+}
+
+type resourceReservation struct {
+	v *resourceVector
+}
+
+func (r *resourceReservation) Commit() Resource {
+	if r.v == nil {
+		return nil
+	}
+	v, pool := r.v, r.v.pool
+	r.v = nil
+	if pool == nil {
+		return nil
+	}
+	pool.mut.Lock()
+	defer pool.mut.Unlock()
+	if ms, ok := pool.expiries[v]; ok {
+		if ms <= pool.nowMs {
+			// expired, and being reclaimed by Advance
+			return nil
+		}
+		delete(pool.expiries, v)
+	}
+	pool.track(v, 0)
+	return v
+}
+
+// pending returns true if the reservation has been neither committed, cancelled nor
+// reclaimed on expiry.
+func (r *resourceReservation) pending() bool {
+	v := r.v
+	return v != nil && v.pool != nil
+}
+
+func (r *resourceReservation) Cancel() {
+	if r.v == nil {
+		return
+	}
+	r.v.Return()
+	r.v = nil
+}
+
+// Reserve reserves the requested resources, returning nil if they are not available.
+// Reserved resources are not outstanding, so they are never offered to the PreemptFunc
+// until committed.
+func (r *resourceVectorPool) Reserve(res Resource) Reservation {
+	v, ok := res.(*resourceVector)
+	if !ok || len(v.resources) != len(r.resources) {
+		return nil
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	reserved := r.take(v.resources)
+	if reserved == nil {
+		return nil
+	}
+	return &resourceReservation{reserved}
+}
+
+// ReserveWithExpiry reserves the requested resources like Reserve, but the reservation
+// is cancelled once the pool's clock reaches expiresAtMs milliseconds if it has not
+// been committed by then. Committing an expired reservation returns nil. The expiry
+// counts toward NextExpiry, so a simulation run WithExpiringPool advances to it.
+func (r *resourceVectorPool) ReserveWithExpiry(res Resource, expiresAtMs int) Reservation {
+	reservation := r.Reserve(res)
+	if reservation == nil {
+		return nil
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.expiries[reservation.(*resourceReservation).v] = expiresAtMs
+	return reservation
+}
+
+func (r *resourceVectorPool) Available() []int {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	available := make([]int, len(r.resources))
+	copy(available, r.resources)
+	return available
+}
+
+// PoolStats returns a snapshot of the outcomes of the requests made with Request or
+// RequestWithPriority. A request retried after preemption is counted once.
+func (r *resourceVectorPool) PoolStats() PoolStats {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.stats.snapshot()
+}
+
+func (r *resourceVectorPool) Capacity() []int {
+	capacity := make([]int, len(r.capacity))
+	copy(capacity, r.capacity)
+	return capacity
+}
+
+// RequestPartial grants as much of the requested resource as is available, but
+// no less than min in any dimension. It returns nil if min cannot be satisfied.
+// Returning the granted resource replenishes only what was granted.
+func (r *resourceVectorPool) RequestPartial(res Resource, min Resource) Resource {
+	v, ok := res.(*resourceVector)
+	if !ok || len(v.resources) != len(r.resources) {
+		return nil
+	}
+	m, ok := min.(*resourceVector)
+	if !ok || len(m.resources) != len(r.resources) {
+		return nil
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	for i := range r.resources {
+		if m.resources[i] > r.resources[i]-r.floor[i] || m.resources[i] > v.resources[i] {
+			return nil
+		}
+	}
+	resources := make([]int, len(v.resources))
+	for i := range r.resources {
+		resources[i] = v.resources[i]
+		if resources[i] > r.resources[i]-r.floor[i] {
+			resources[i] = r.resources[i] - r.floor[i]
+		}
+		r.resources[i] -= resources[i]
+	}
+	v = &resourceVector{r, resources}
+	r.track(v, 0)
+	return v
+}
+
+// release returns up to res of the resources held by v to the pool ahead of Return,
+// which then returns only what is left. It returns false if v has already been
+// returned or res has the wrong length.
+func (r *resourceVector) release(res []int) bool {
+	pool := r.pool
+	if pool == nil || len(res) != len(r.resources) {
+		return false
+	}
+	pool.mut.Lock()
+	defer pool.mut.Unlock()
+	for i := range r.resources {
+		amount := res[i]
+		if amount > r.resources[i] {
+			amount = r.resources[i]
+		}
+		if amount < 0 {
+			amount = 0
+		}
+		r.resources[i] -= amount
+		pool.resources[i] += amount
+	}
+	return true
+}
+
+// A releaser is a ScheduledTask holding a resource that can be partially released
+// before the task is closed.
+type releaser interface {
+	release(res []int) bool
+}
+
+// releasePartial releases up to res of the resources held by st, if st holds a
+// resource granted from a pool created with NewResourceVectorPool. It returns false
+// otherwise.
+func releasePartial(st ScheduledTask, res []int) bool {
+	if r, ok := st.(releaser); ok {
+		return r.release(res)
+	}
+	return false
+}
+
+// A returner is a ScheduledTask holding a resource that can be returned in full
+// before the task is closed.
+type returner interface {
+	returnResource() bool
+}
+
+// returnEarly returns the resource held by st ahead of Close, if st holds one that
+// has not been returned. It returns false otherwise.
+func returnEarly(st ScheduledTask) bool {
+	if r, ok := st.(returner); ok {
+		return r.returnResource()
+	}
+	return false
+}
+
 func (r *resourceVectorPool) add(v *resourceVector) bool {
 	if len(r.resources) != len(v.resources) {
 		return false
@@ -77,5 +527,433 @@ func (r *resourceVectorPool) add(v *resourceVector) bool {
 	for i := range r.resources {
 		r.resources[i] += v.resources[i]
 	}
+	delete(r.outstanding, v)
+	delete(r.expiries, v)
+	return true
+}
+
+// An ExpiringPool is a ResourcePool whose grants can be leased for a time to live,
+// after which they are reclaimed by the pool even if they are never returned. Its
+// clock is virtual and only moves with calls to Advance().
+type ExpiringPool interface {
+	ResourcePool
+
+	// RequestWithTTL requests the resource like Request, but the resource granted
+	// is reclaimed once the pool's clock reaches ttlMs milliseconds past its
+	// current time. Returning a reclaimed resource does nothing.
+	RequestWithTTL(r Resource, ttlMs int) Resource
+
+	// Advance moves the pool's clock forward to nowMs milliseconds, reclaiming
+	// the grants that have expired. Moving the clock backwards has no effect.
+	Advance(nowMs int)
+
+	// NextExpiry returns the time at which the earliest outstanding grant expires.
+	// ok is false if no grant has a time to live.
+	NextExpiry() (ms int, ok bool)
+}
+
+func (r *resourceVectorPool) RequestWithTTL(res Resource, ttlMs int) Resource {
+	granted := r.Request(res)
+	if granted == nil {
+		return nil
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if v := granted.(*resourceVector); v.pool != nil {
+		r.expiries[v] = r.nowMs + ttlMs
+	}
+	return granted
+}
+
+func (r *resourceVectorPool) Advance(nowMs int) {
+	r.mut.Lock()
+	if nowMs > r.nowMs {
+		r.nowMs = nowMs
+	}
+	expired := []*resourceVector{}
+	for v, ms := range r.expiries {
+		if ms <= r.nowMs {
+			expired = append(expired, v)
+		}
+	}
+	r.mut.Unlock()
+	for _, v := range expired {
+		v.Return()
+	}
+}
+
+func (r *resourceVectorPool) NextExpiry() (ms int, ok bool) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	for _, expiry := range r.expiries {
+		if !ok || expiry < ms {
+			ms, ok = expiry, true
+		}
+	}
+	return
+}
+
+// A LeasedPool is a pool created with NewResourceVectorPool whose Request grants
+// every resource with a time to live, so schedulers such as ResourceManagedScheduler
+// lease resources from it rather than holding them until they are returned.
+type LeasedPool struct {
+	*resourceVectorPool
+	ttlMs int
+}
+
+// NewLeasedPool returns a LeasedPool granting resources from pool for ttlMs milliseconds.
+func NewLeasedPool(pool *resourceVectorPool, ttlMs int) *LeasedPool {
+	return &LeasedPool{pool, ttlMs}
+}
+
+func (l *LeasedPool) Request(res Resource) Resource {
+	return l.RequestWithTTL(res, l.ttlMs)
+}
+
+// A CompositePool is a ResourcePool spread over several pools created with
+// NewResourceVectorPool, such as the resources of each zone. A request is granted
+// from the first pool that can grant it, and the granted resource is returned to
+// the pool it was granted from.
+type CompositePool struct {
+	pools []*resourceVectorPool
+}
+
+// NewCompositePool returns a CompositePool that requests from pools in the given order.
+func NewCompositePool(pools ...*resourceVectorPool) *CompositePool {
+	return &CompositePool{pools}
+}
+
+func (c *CompositePool) Request(res Resource) Resource {
+	for _, pool := range c.pools {
+		if granted := pool.Request(res); granted != nil {
+			return granted
+		}
+	}
+	return nil
+}
+
+// An AffinityPool is a pool created with NewResourceVectorPool that prefers to grant
+// returned resources to a request with the same key as the one they were granted to,
+// as for modeling cache warmth. Requests are tagged with a key by NewAffinityRequest,
+// and untagged requests are granted as by the pool. When a resource granted to a
+// tagged request is returned, its units are held for the key until the key next
+// requests, or until the pool's clock passes windowMs milliseconds, whichever comes
+// first. Only the key's next request may be granted the held units.
+type AffinityPool struct {
+	*resourceVectorPool
+	mut      sync.Mutex
+	windowMs int
+	held     map[string][]Reservation
+}
+
+// NewAffinityPool returns an AffinityPool holding returned resources for their key
+// for windowMs milliseconds. If windowMs is not positive they are held until the
+// key next requests.
+func NewAffinityPool(pool *resourceVectorPool, windowMs int) *AffinityPool {
+	return &AffinityPool{pool, sync.Mutex{}, windowMs, make(map[string][]Reservation)}
+}
+
+// affinityRequest is a request tagged with the key of an AffinityPool.
+type affinityRequest struct {
+	Resource
+	key string
+}
+
+// NewAffinityRequest tags the request res with key, for an AffinityPool.
+func NewAffinityRequest(key string, res Resource) Resource {
+	return &affinityRequest{res, key}
+}
+
+// affinityResource is a resource granted by an AffinityPool to a tagged request,
+// held for its key once returned.
+type affinityResource struct {
+	*resourceVector
+	pool *AffinityPool
+	key  string
+}
+
+func (r *affinityResource) Return() bool {
+	r.pool.mut.Lock()
+	defer r.pool.mut.Unlock()
+	if !r.resourceVector.Return() {
+		return false
+	}
+	var held Reservation
+	freed := NewResourceVectorRequest(r.resources)
+	if r.pool.windowMs > 0 {
+		pool := r.pool.resourceVectorPool
+		pool.mut.Lock()
+		expiresAtMs := pool.nowMs + r.pool.windowMs
+		pool.mut.Unlock()
+		held = pool.ReserveWithExpiry(freed, expiresAtMs)
+	} else {
+		held = r.pool.Reserve(freed)
+	}
+	if held != nil {
+		r.pool.held[r.key] = append(r.pool.held[r.key], held)
+	}
 	return true
 }
+
+// Request grants a tagged request the units held for its key ahead of any other
+// request, ending the key's holds whether or not it is granted.
+func (a *AffinityPool) Request(res Resource) Resource {
+	req, ok := res.(*affinityRequest)
+	if !ok {
+		return a.resourceVectorPool.Request(res)
+	}
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	for _, held := range a.held[req.key] {
+		held.Cancel()
+	}
+	delete(a.held, req.key)
+	granted := a.resourceVectorPool.Request(req.Resource)
+	if granted == nil {
+		return nil
+	}
+	return &affinityResource{granted.(*resourceVector), a, req.key}
+}
+
+// Advance advances the pool's clock like the pool it wraps, and forgets the holds
+// that expire, so keys that never request again are not kept.
+func (a *AffinityPool) Advance(nowMs int) {
+	a.resourceVectorPool.Advance(nowMs)
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	for key, holds := range a.held {
+		kept := holds[:0]
+		for _, held := range holds {
+			if held.(*resourceReservation).pending() {
+				kept = append(kept, held)
+			}
+		}
+		for i := len(kept); i < len(holds); i++ {
+			holds[i] = nil
+		}
+		if len(kept) == 0 {
+			delete(a.held, key)
+		} else {
+			a.held[key] = kept
+		}
+	}
+}
+
+// resourceBundle is a resource made of a resource from each pool of a poolSet.
+// A nil resource is neither requested nor returned.
+type resourceBundle struct {
+	resources []Resource
+}
+
+// Return returns each resource of the bundle, and returns true if any was returned.
+func (b *resourceBundle) Return() bool {
+	returned := false
+	for _, r := range b.resources {
+		if r != nil && r.Return() {
+			returned = true
+		}
+	}
+	return returned
+}
+
+// poolSet is a ResourcePool granting a resourceBundle only if each of its pools grants
+// the resource requested from it. A bundle is acquired in two phases, visiting the
+// pools in the same order for every request: the resources of pools created with
+// NewResourceVectorPool are first reserved, and other pools are requested from, and
+// only once every pool has succeeded are the reservations committed. If any pool
+// fails, the reservations are cancelled and the resources granted are returned, so
+// no part of a bundle is ever held unless all of it is. Since a failed bundle takes
+// nothing from any pool, bundles contending for the same pools can't each hold a
+// part that another waits on.
+type poolSet struct {
+	pools []ResourcePool
+}
+
+func (p *poolSet) Request(res Resource) Resource {
+	b, ok := res.(*resourceBundle)
+	if !ok || len(b.resources) != len(p.pools) {
+		return nil
+	}
+	granted := &resourceBundle{make([]Resource, len(p.pools))}
+	reservations := make([]Reservation, len(p.pools))
+	rollback := func() {
+		for _, r := range reservations {
+			if r != nil {
+				r.Cancel()
+			}
+		}
+		granted.Return()
+	}
+	for i, pool := range p.pools {
+		if b.resources[i] == nil {
+			continue
+		}
+		if vp, ok := pool.(*resourceVectorPool); ok {
+			if reservations[i] = vp.Reserve(b.resources[i]); reservations[i] == nil {
+				rollback()
+				return nil
+			}
+		} else if granted.resources[i] = pool.Request(b.resources[i]); granted.resources[i] == nil {
+			rollback()
+			return nil
+		}
+	}
+	for i, r := range reservations {
+		if r != nil {
+			granted.resources[i] = r.Commit()
+		}
+	}
+	return granted
+}
+
+// An InfinitePool is a ResourcePool that grants every request, for isolating
+// scheduling logic from resource constraints such as in tests.
+type InfinitePool struct{}
+
+func NewInfinitePool() *InfinitePool {
+	return &InfinitePool{}
+}
+
+// Request returns a resource whose Return gives nothing back to the pool.
+func (p *InfinitePool) Request(res Resource) Resource {
+	return &infiniteResource{}
+}
+
+type infiniteResource struct {
+	returned bool
+}
+
+func (r *infiniteResource) Return() bool {
+	if r.returned {
+		return false
+	}
+	r.returned = true
+	return true
+}
+
+type resourceMap struct {
+	pool      *resourceMapPool
+	resources map[string]int
+}
+
+func (r *resourceMap) Return() bool {
+	if r.pool == nil {
+		return false
+	}
+	r.pool.add(r)
+	r.pool = nil
+	return true
+}
+
+// NewResourceMapRequest returns a Resource for requesting named resources from a
+// pool created with NewResourceMapPool. Names absent from the request are not requested.
+func NewResourceMapRequest(res map[string]int) Resource {
+	return &resourceMap{pool: nil, resources: res}
+}
+
+type resourceMapPool struct {
+	mut       *sync.Mutex
+	resources map[string]int
+}
+
+// NewResourceMapPool returns a pool of resources keyed by name. Names absent
+// from the pool are treated as having nothing available.
+func NewResourceMapPool(resources map[string]int) *resourceMapPool {
+	return &resourceMapPool{&sync.Mutex{}, resources}
+}
+
+func (r *resourceMapPool) Request(res Resource) Resource {
+	m, ok := res.(*resourceMap)
+	if !ok {
+		return nil
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	for k, v := range m.resources {
+		if v > r.resources[k] {
+			return nil
+		}
+	}
+	resources := make(map[string]int, len(m.resources))
+	for k, v := range m.resources {
+		r.resources[k] -= v
+		resources[k] = v
+	}
+	return &resourceMap{r, resources}
+}
+
+func (r *resourceMapPool) add(m *resourceMap) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	for k, v := range m.resources {
+		r.resources[k] += v
+	}
+}
+
+// floatResourceEpsilon is the tolerance used when comparing fractional resources,
+// so requests that exactly exhaust a pool are not denied by rounding error.
+const floatResourceEpsilon = 1e-9
+
+type resourceFloatVector struct {
+	pool      *resourceFloatVectorPool
+	resources []float64
+}
+
+func (r *resourceFloatVector) Return() bool {
+	if r.pool == nil {
+		return false
+	}
+	r.pool.add(r)
+	r.pool = nil
+	return true
+}
+
+// NewResourceFloatVectorRequest returns a Resource for requesting fractional
+// resources from a pool created with NewResourceFloatVectorPool.
+func NewResourceFloatVectorRequest(res []float64) Resource {
+	return &resourceFloatVector{pool: nil, resources: res}
+}
+
+type resourceFloatVectorPool struct {
+	mut       *sync.Mutex
+	resources []float64
+}
+
+// NewResourceFloatVectorPool returns a pool of fractional resources, such as
+// shares of a CPU, with the same semantics as NewResourceVectorPool.
+func NewResourceFloatVectorPool(resources []float64) *resourceFloatVectorPool {
+	return &resourceFloatVectorPool{&sync.Mutex{}, resources}
+}
+
+func (r *resourceFloatVectorPool) Request(res Resource) Resource {
+	v, ok := res.(*resourceFloatVector)
+	if !ok || len(v.resources) != len(r.resources) {
+		return nil
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	for i := range r.resources {
+		if v.resources[i] > r.resources[i]+floatResourceEpsilon {
+			return nil
+		}
+	}
+	// a request within the tolerance of what is left takes all of it, and the grant
+	// holds what was taken so returning it restores the pool exactly
+	resources := make([]float64, len(v.resources))
+	for i := range r.resources {
+		resources[i] = v.resources[i]
+		if resources[i] > r.resources[i] {
+			resources[i] = r.resources[i]
+		}
+		r.resources[i] -= resources[i]
+	}
+	return &resourceFloatVector{r, resources}
+}
+
+func (r *resourceFloatVectorPool) add(v *resourceFloatVector) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	for i := range r.resources {
+		r.resources[i] += v.resources[i]
+	}
+}
@@ -1,6 +1,8 @@
 package schedule
 
 import (
+	"math"
+	"reflect"
 	"testing"
 )
 
@@ -79,3 +81,826 @@ func TestResourceVectorReturn(t *testing.T) {
 		t.Error("unexpected pool resource values")
 	}
 }
+
+func TestResourceMapPoolRequest(t *testing.T) {
+	pool := NewResourceMapPool(map[string]int{"cpu": 1, "memory": 2})
+	requesting := NewResourceMapRequest(map[string]int{})
+	returned := pool.Request(requesting)
+	if returned == nil {
+		t.Error("expected valid resource request")
+	}
+	if !(pool.resources["cpu"] == 1 && pool.resources["memory"] == 2) {
+		t.Error("unexpected pool resource values")
+	}
+
+	requesting = NewResourceMapRequest(map[string]int{"cpu": 2})
+	returned = pool.Request(requesting)
+	if returned != nil {
+		t.Error("expected invalid resource request")
+	}
+	if !(pool.resources["cpu"] == 1 && pool.resources["memory"] == 2) {
+		t.Error("unexpected pool resource values")
+	}
+
+	// keys missing from the request are not requested
+	requesting = NewResourceMapRequest(map[string]int{"cpu": 1})
+	returned = pool.Request(requesting)
+	if returned == nil {
+		t.Error("expected valid resource request")
+	}
+	if !(pool.resources["cpu"] == 0 && pool.resources["memory"] == 2) {
+		t.Error("unexpected pool resource values")
+	}
+
+	// keys missing from the pool have nothing available
+	requesting = NewResourceMapRequest(map[string]int{"memory": 1, "disk": 1})
+	returned = pool.Request(requesting)
+	if returned != nil {
+		t.Error("expected invalid resource request")
+	}
+	if !(pool.resources["cpu"] == 0 && pool.resources["memory"] == 2) {
+		t.Error("unexpected pool resource values")
+	}
+
+	// requests from other pool types are rejected
+	returned = pool.Request(&resourceVector{resources: []int{0}})
+	if returned != nil {
+		t.Error("expected invalid resource request")
+	}
+}
+
+func TestResourceMapReturn(t *testing.T) {
+	pool := NewResourceMapPool(map[string]int{"cpu": 1, "memory": 2})
+	requesting := NewResourceMapRequest(map[string]int{"memory": 2})
+	returned := pool.Request(requesting)
+	if !(pool.resources["cpu"] == 1 && pool.resources["memory"] == 0) {
+		t.Error("unexpected pool resource values")
+	}
+	m := returned.(*resourceMap)
+	if m.pool == nil {
+		t.Error("expected pool present")
+	}
+	if !(len(m.resources) == 1 && m.resources["memory"] == 2) {
+		t.Error("unexpected map resources")
+	}
+
+	// return the first time should replenish the pool of the resources
+	res := m.Return()
+	if !res {
+		t.Error("expected successful return")
+	}
+	if m.pool != nil {
+		t.Error("expected pool not present")
+	}
+	if !(pool.resources["cpu"] == 1 && pool.resources["memory"] == 2) {
+		t.Error("unexpected pool resource values")
+	}
+
+	// return a second time should be idempotent
+	res = m.Return()
+	if res {
+		t.Error("expected unsuccessful return")
+	}
+	if !(pool.resources["cpu"] == 1 && pool.resources["memory"] == 2) {
+		t.Error("unexpected pool resource values")
+	}
+}
+
+func TestResourceVectorPoolRequestPartial(t *testing.T) {
+	pool := NewResourceVectorPool([]int{5})
+	returned := pool.RequestPartial(NewResourceVectorRequest([]int{8}), NewResourceVectorRequest([]int{2}))
+	if returned == nil {
+		t.Fatal("expected valid resource request")
+	}
+	if vec := returned.(*resourceVector); vec.resources[0] != 5 {
+		t.Errorf("expected 5 granted, received %d", vec.resources[0])
+	}
+	if pool.resources[0] != 0 {
+		t.Error("unexpected pool resource values")
+	}
+
+	// the minimum can't be satisfied
+	if pool.RequestPartial(NewResourceVectorRequest([]int{8}), NewResourceVectorRequest([]int{1})) != nil {
+		t.Error("expected invalid resource request")
+	}
+
+	// returning replenishes what was granted rather than what was requested
+	returned.Return()
+	if pool.resources[0] != 5 {
+		t.Errorf("expected pool replenished to 5, received %d", pool.resources[0])
+	}
+
+	// requests that are fully available are granted in full
+	pool = NewResourceVectorPool([]int{5, 1})
+	returned = pool.RequestPartial(NewResourceVectorRequest([]int{3, 2}), NewResourceVectorRequest([]int{3, 0}))
+	if vec := returned.(*resourceVector); !(vec.resources[0] == 3 && vec.resources[1] == 1) {
+		t.Error("unexpected vector resources")
+	}
+	if !(pool.resources[0] == 2 && pool.resources[1] == 0) {
+		t.Error("unexpected pool resource values")
+	}
+
+	// mismatched lengths and minimums exceeding the request are rejected
+	if pool.RequestPartial(NewResourceVectorRequest([]int{1}), NewResourceVectorRequest([]int{1})) != nil {
+		t.Error("expected invalid resource request")
+	}
+	if pool.RequestPartial(NewResourceVectorRequest([]int{1, 0}), NewResourceVectorRequest([]int{2, 0})) != nil {
+		t.Error("expected invalid resource request")
+	}
+}
+
+func TestResourceVectorGranted(t *testing.T) {
+	// a fully satisfied request is granted what was requested
+	pool := NewResourceVectorPool([]int{4, 2})
+	res, ok := pool.Request(NewResourceVectorRequest([]int{3, 2})).(GrantedResource)
+	if !ok {
+		t.Fatal("expected a GrantedResource")
+	}
+	granted := res.Granted()
+	if !reflect.DeepEqual(granted, []int{3, 2}) {
+		t.Errorf("expected [3 2] granted, received %v", granted)
+	}
+
+	// a partial grant reports the reduced amount
+	pool = NewResourceVectorPool([]int{5})
+	partial := pool.RequestPartial(NewResourceVectorRequest([]int{8}), NewResourceVectorRequest([]int{2})).(*resourceVector)
+	if granted = partial.Granted(); !reflect.DeepEqual(granted, []int{5}) {
+		t.Errorf("expected [5] granted, received %v", granted)
+	}
+
+	// the granted amounts are a copy
+	granted[0] = 0
+	if partial.resources[0] != 5 {
+		t.Errorf("expected granted resources unchanged, received %d", partial.resources[0])
+	}
+}
+
+func TestEqualResources(t *testing.T) {
+	pool := NewResourceVectorPool([]int{3, 3})
+	granted := pool.Request(NewResourceVectorRequest([]int{1, 2}))
+	for _, c := range []struct {
+		a, b     Resource
+		expected bool
+	}{
+		{NewResourceVectorRequest([]int{1, 2}), NewResourceVectorRequest([]int{1, 2}), true},
+		{NewResourceVectorRequest([]int{}), NewResourceVectorRequest([]int{}), true},
+		{granted, NewResourceVectorRequest([]int{1, 2}), true},
+		{NewResourceVectorRequest([]int{1, 2}), NewResourceVectorRequest([]int{1, 2, 0}), false},
+		{NewResourceVectorRequest([]int{1, 2}), NewResourceVectorRequest([]int{2, 1}), false},
+		{NewResourceVectorRequest([]int{1}), NewResourceMapRequest(map[string]int{"cpu": 1}), false},
+		{granted, granted, true},
+	} {
+		if equal := EqualResources(c.a, c.b); equal != c.expected {
+			t.Errorf("expected EqualResources(%v, %v) to be %t", c.a, c.b, c.expected)
+		}
+		if equal := EqualResources(c.b, c.a); equal != c.expected {
+			t.Errorf("expected EqualResources(%v, %v) to be %t", c.b, c.a, c.expected)
+		}
+	}
+}
+
+func TestResourceVectorClone(t *testing.T) {
+	pool := NewResourceVectorPool([]int{3, 3})
+	granted := pool.Request(NewResourceVectorRequest([]int{1, 2}))
+	clone := granted.(CloneableResource).Clone()
+	if !EqualResources(clone, granted) {
+		t.Errorf("expected the clone to equal the granted resource, received %v", clone)
+	}
+
+	// the clone is not attached to the pool
+	if clone.Return() {
+		t.Error("expected the clone not to be returned")
+	}
+	if available := pool.Available(); !reflect.DeepEqual(available, []int{2, 1}) {
+		t.Errorf("expected [2 1] available, received %v", available)
+	}
+
+	// the clone is independent of the resource, and can be requested again
+	granted.(*resourceVector).release([]int{0, 1})
+	if !reflect.DeepEqual(clone.(*resourceVector).resources, []int{1, 2}) {
+		t.Errorf("expected the clone to hold [1 2], received %v", clone.(*resourceVector).resources)
+	}
+	if pool.Request(clone) == nil {
+		t.Error("expected the clone to be granted")
+	}
+}
+
+func TestResourceVectorPoolPreemption(t *testing.T) {
+	var received []PreemptionCandidate
+	preemptLowest := func(priority int, candidates []PreemptionCandidate) {
+		received = candidates
+		candidates[0].Resource.Return()
+	}
+	pool := NewPreemptibleResourceVectorPool([]int{2}, preemptLowest)
+	low := pool.RequestWithPriority(NewResourceVectorRequest([]int{1}), 1)
+	mid := pool.RequestWithPriority(NewResourceVectorRequest([]int{1}), 5)
+	if low == nil || mid == nil {
+		t.Fatal("expected valid resource requests")
+	}
+
+	// a request of equal priority can't preempt anything
+	if pool.RequestWithPriority(NewResourceVectorRequest([]int{1}), 1) != nil {
+		t.Error("expected invalid resource request")
+	}
+	if received != nil {
+		t.Error("expected no call to preempt without lower priority candidates")
+	}
+
+	// a high priority request preempts the lowest priority resource
+	high := pool.RequestWithPriority(NewResourceVectorRequest([]int{1}), 10)
+	if high == nil {
+		t.Fatal("expected valid resource request")
+	}
+	if len(received) != 2 || received[0].Priority != 1 || received[1].Priority != 5 {
+		t.Errorf("unexpected preemption candidates %v", received)
+	}
+	if received[0].Resource != low {
+		t.Error("expected lowest priority resource first")
+	}
+	if pool.resources[0] != 0 {
+		t.Errorf("expected empty pool, received %d", pool.resources[0])
+	}
+
+	// the preempted resource is marked returned, so closing its task later does
+	// not replenish the pool twice
+	if low.Return() {
+		t.Error("expected preempted resource already returned")
+	}
+	if pool.resources[0] != 0 {
+		t.Errorf("expected empty pool, received %d", pool.resources[0])
+	}
+	high.Return()
+	mid.Return()
+	if pool.resources[0] != 2 {
+		t.Errorf("expected full pool, received %d", pool.resources[0])
+	}
+	if len(pool.outstanding) != 0 {
+		t.Errorf("expected no outstanding resources, received %d", len(pool.outstanding))
+	}
+
+	// a hook that declines to preempt leaves the request ungranted
+	pool = NewPreemptibleResourceVectorPool([]int{1}, func(int, []PreemptionCandidate) {})
+	pool.RequestWithPriority(NewResourceVectorRequest([]int{1}), 0)
+	if pool.RequestWithPriority(NewResourceVectorRequest([]int{1}), 1) != nil {
+		t.Error("expected invalid resource request")
+	}
+}
+
+func TestResourceVectorPoolAvailable(t *testing.T) {
+	var pool ResourceVectorPool = NewResourceVectorPool([]int{3, 2})
+	expectAvailable := func(expected ...int) {
+		available := pool.Available()
+		if len(available) != len(expected) {
+			t.Fatalf("expected %d resources, received %d", len(expected), len(available))
+		}
+		for i := range expected {
+			if available[i] != expected[i] {
+				t.Errorf("expected %v available, received %v", expected, available)
+				return
+			}
+		}
+	}
+	expectAvailable(3, 2)
+	first := pool.Request(NewResourceVectorRequest([]int{1, 2}))
+	expectAvailable(2, 0)
+	second := pool.Request(NewResourceVectorRequest([]int{2, 0}))
+	expectAvailable(0, 0)
+	first.Return()
+	expectAvailable(1, 2)
+	second.Return()
+	expectAvailable(3, 2)
+
+	// the returned slice is a copy
+	pool.Available()[0] = 100
+	expectAvailable(3, 2)
+}
+
+func TestResourceVectorPoolRequestAll(t *testing.T) {
+	pool := NewResourceVectorPool([]int{4, 2})
+	request := func() Resource { return NewResourceVectorRequest([]int{2, 1}) }
+
+	// the pool can satisfy only two of the three requests
+	if granted, ok := pool.RequestAll(request(), request(), request()); ok || granted != nil {
+		t.Errorf("expected no resources to be granted, received %v", granted)
+	}
+	if available := pool.Available(); !reflect.DeepEqual(available, []int{4, 2}) {
+		t.Errorf("expected nothing to be deducted, received %v available", available)
+	}
+	if len(pool.outstanding) != 0 {
+		t.Errorf("expected no outstanding resources, received %d", len(pool.outstanding))
+	}
+
+	granted, ok := pool.RequestAll(request(), request())
+	if !ok || len(granted) != 2 {
+		t.Fatalf("expected both resources to be granted, received %v", granted)
+	}
+	if available := pool.Available(); !reflect.DeepEqual(available, []int{0, 0}) {
+		t.Errorf("expected the pool to be exhausted, received %v available", available)
+	}
+
+	// each resource is returned independently
+	if !granted[0].Return() {
+		t.Error("expected the first resource to be returned")
+	}
+	if available := pool.Available(); !reflect.DeepEqual(available, []int{2, 1}) {
+		t.Errorf("expected [2 1] available, received %v", available)
+	}
+	if !granted[1].Return() {
+		t.Error("expected the second resource to be returned")
+	}
+	if granted[0].Return() {
+		t.Error("expected a returned resource not to be returned again")
+	}
+	if available := pool.Available(); !reflect.DeepEqual(available, []int{4, 2}) {
+		t.Errorf("expected [4 2] available, received %v", available)
+	}
+
+	if _, ok := pool.RequestAll(request(), NewResourceVectorRequest([]int{1})); ok {
+		t.Error("expected a request of the wrong length to fail")
+	}
+}
+
+func TestResourceVectorPoolStats(t *testing.T) {
+	pool := NewResourceVectorPool([]int{4, 2, 1})
+	expectStats := func(expected PoolStats) {
+		if stats := pool.PoolStats(); !reflect.DeepEqual(stats, expected) {
+			t.Errorf("expected stats %+v, received %+v", expected, stats)
+		}
+	}
+	expectStats(PoolStats{DeniedBy: []int{0, 0, 0}})
+
+	first := pool.Request(NewResourceVectorRequest([]int{2, 1, 0}))
+	pool.Request(NewResourceVectorRequest([]int{5, 0, 0}))
+	pool.Request(NewResourceVectorRequest([]int{3, 2, 0}))
+	pool.Request(NewResourceVectorRequest([]int{1, 1, 1}))
+	pool.Request(NewResourceVectorRequest([]int{1, 0, 1}))
+	expectStats(PoolStats{Granted: 2, Denied: 3, DeniedBy: []int{2, 1, 1}})
+
+	// returned resources are available to later requests
+	first.Return()
+	pool.Request(NewResourceVectorRequest([]int{3, 1, 0}))
+	expectStats(PoolStats{Granted: 3, Denied: 3, DeniedBy: []int{2, 1, 1}})
+
+	// requests retried after preemption are counted once
+	preempted := NewPreemptibleResourceVectorPool([]int{1}, func(priority int, candidates []PreemptionCandidate) {
+		candidates[0].Resource.Return()
+	})
+	preempted.RequestWithPriority(NewResourceVectorRequest([]int{1}), 0)
+	preempted.RequestWithPriority(NewResourceVectorRequest([]int{1}), 0)
+	preempted.RequestWithPriority(NewResourceVectorRequest([]int{1}), 1)
+	if stats := preempted.PoolStats(); !reflect.DeepEqual(stats, PoolStats{2, 1, []int{1}}) {
+		t.Errorf("expected 2 granted and 1 denied, received %+v", stats)
+	}
+
+	// the snapshot is a copy
+	pool.PoolStats().DeniedBy[0] = 100
+	expectStats(PoolStats{Granted: 3, Denied: 3, DeniedBy: []int{2, 1, 1}})
+}
+
+func TestResourceVectorPoolCapacity(t *testing.T) {
+	var pool ResourceVectorPool = NewResourceVectorPool([]int{4, 2})
+	utilization := func() (percent []int) {
+		available, capacity := pool.Available(), pool.Capacity()
+		for i := range capacity {
+			percent = append(percent, 100*(capacity[i]-available[i])/capacity[i])
+		}
+		return
+	}
+	expectUtilization := func(expected int) {
+		for i, u := range utilization() {
+			if u != expected {
+				t.Errorf("expected %d%% utilization of resource %d, received %d%%", expected, i, u)
+			}
+		}
+	}
+	expectUtilization(0)
+	first := pool.Request(NewResourceVectorRequest([]int{3, 1}))
+	second := pool.Request(NewResourceVectorRequest([]int{1, 1}))
+	expectUtilization(100)
+	if capacity := pool.Capacity(); capacity[0] != 4 || capacity[1] != 2 {
+		t.Errorf("expected capacity unchanged by grants, received %v", capacity)
+	}
+	first.Return()
+	second.Return()
+	expectUtilization(0)
+
+	// the returned slice is a copy
+	pool.Capacity()[0] = 100
+	if pool.Capacity()[0] != 4 {
+		t.Error("expected capacity unchanged")
+	}
+}
+
+func TestResourceVectorPoolReserve(t *testing.T) {
+	pool := NewResourceVectorPool([]int{3, 2})
+	expectAvailable := func(expected ...int) {
+		if available := pool.Available(); available[0] != expected[0] || available[1] != expected[1] {
+			t.Errorf("expected %v available, received %v", expected, available)
+		}
+	}
+
+	// reserved resources block other requests
+	reservation := pool.Reserve(NewResourceVectorRequest([]int{2, 2}))
+	if reservation == nil {
+		t.Fatal("expected reservation")
+	}
+	expectAvailable(1, 0)
+	if pool.Request(NewResourceVectorRequest([]int{1, 1})) != nil {
+		t.Error("expected request blocked by reservation")
+	}
+	if pool.Reserve(NewResourceVectorRequest([]int{2, 0})) != nil {
+		t.Error("expected reservation blocked by reservation")
+	}
+
+	// cancelling frees them, once
+	reservation.Cancel()
+	expectAvailable(3, 2)
+	reservation.Cancel()
+	expectAvailable(3, 2)
+	if reservation.Commit() != nil {
+		t.Error("expected cancelled reservation not to commit")
+	}
+	granted := pool.Request(NewResourceVectorRequest([]int{1, 1}))
+	if granted == nil {
+		t.Fatal("expected request granted after cancelling")
+	}
+	granted.Return()
+
+	// committing grants the reserved resources without taking any more
+	reservation = pool.Reserve(NewResourceVectorRequest([]int{1, 2}))
+	committed := reservation.Commit()
+	if committed == nil {
+		t.Fatal("expected committed resource")
+	}
+	expectAvailable(2, 0)
+	if reservation.Commit() != nil {
+		t.Error("expected reservation to commit once")
+	}
+	reservation.Cancel()
+	expectAvailable(2, 0)
+	if !committed.Return() {
+		t.Error("expected committed resource returned")
+	}
+	expectAvailable(3, 2)
+
+	// reservations the pool can't satisfy fail
+	if pool.Reserve(NewResourceVectorRequest([]int{4, 0})) != nil {
+		t.Error("expected reservation exceeding the pool to fail")
+	}
+	if pool.Reserve(NewResourceVectorRequest([]int{1})) != nil {
+		t.Error("expected reservation of the wrong length to fail")
+	}
+	expectAvailable(3, 2)
+}
+
+func TestResourceVectorPoolOvercommit(t *testing.T) {
+	pool := NewOvercommittedResourceVectorPool([]int{2}, []int{-1})
+	unit := func() Resource { return NewResourceVectorRequest([]int{1}) }
+
+	// grants down to the floor and no further
+	granted := []Resource{}
+	for i := 0; i < 3; i++ {
+		res := pool.Request(unit())
+		if res == nil {
+			t.Fatalf("expected request %d granted", i+1)
+		}
+		granted = append(granted, res)
+	}
+	if pool.Request(unit()) != nil {
+		t.Error("expected request below the floor denied")
+	}
+	if available := pool.Available(); available[0] != -1 {
+		t.Errorf("expected -1 available, received %d", available[0])
+	}
+
+	// returns replenish normally
+	granted[0].Return()
+	if pool.Request(unit()) == nil {
+		t.Error("expected request granted after a return")
+	}
+	granted[1].Return()
+	granted[2].Return()
+	if available := pool.Available(); available[0] != 1 {
+		t.Errorf("expected 1 available, received %d", available[0])
+	}
+
+	// the floor counts toward what the pool could ever grant
+	if exceedsCapacity(pool, NewResourceVectorRequest([]int{3})) {
+		t.Error("expected request within the floor not to exceed capacity")
+	}
+	if !exceedsCapacity(pool, NewResourceVectorRequest([]int{4})) {
+		t.Error("expected request below the floor to exceed capacity")
+	}
+
+	// partial requests are granted down to the floor
+	partial := NewOvercommittedResourceVectorPool([]int{2}, []int{-1}).RequestPartial(NewResourceVectorRequest([]int{5}), unit())
+	if v := partial.(*resourceVector); v.resources[0] != 3 {
+		t.Errorf("expected 3 granted, received %d", v.resources[0])
+	}
+}
+
+func TestResourceVectorRelease(t *testing.T) {
+	pool := NewResourceVectorPool([]int{4, 2})
+	res := pool.Request(NewResourceVectorRequest([]int{3, 2})).(*resourceVector)
+	if !res.release([]int{1, 5}) {
+		t.Error("expected release")
+	}
+	if pool.resources[0] != 2 || pool.resources[1] != 2 {
+		t.Errorf("expected [2 2] available after release, received %v", pool.resources)
+	}
+	if res.release([]int{1}) {
+		t.Error("expected release of the wrong length to fail")
+	}
+
+	// returning returns only what is left
+	res.Return()
+	if pool.resources[0] != 4 || pool.resources[1] != 2 {
+		t.Errorf("expected [4 2] available after return, received %v", pool.resources)
+	}
+	if res.release([]int{1, 0}) {
+		t.Error("expected release after return to fail")
+	}
+}
+
+func TestResourceVectorPoolRequestWithTTL(t *testing.T) {
+	pool := NewResourceVectorPool([]int{3})
+	leased := pool.RequestWithTTL(NewResourceVectorRequest([]int{2}), 100)
+	held := pool.Request(NewResourceVectorRequest([]int{1}))
+	if ms, ok := pool.NextExpiry(); !ok || ms != 100 {
+		t.Errorf("expected expiry at 100ms, received %d", ms)
+	}
+
+	// grants are reclaimed once their time to live has passed
+	pool.Advance(99)
+	if pool.resources[0] != 0 {
+		t.Errorf("expected no resources available before expiry, received %d", pool.resources[0])
+	}
+	pool.Advance(100)
+	if pool.resources[0] != 2 {
+		t.Errorf("expected 2 resources reclaimed, received %d", pool.resources[0])
+	}
+	if _, ok := pool.NextExpiry(); ok {
+		t.Error("expected no outstanding expiry")
+	}
+
+	// returning a reclaimed resource does nothing
+	if leased.Return() || pool.resources[0] != 2 {
+		t.Errorf("expected return of reclaimed resource ignored, received %d available", pool.resources[0])
+	}
+
+	// grants without a time to live are never reclaimed, and returned grants never expire
+	pool.Advance(1000)
+	if pool.resources[0] != 2 {
+		t.Errorf("expected 2 resources available, received %d", pool.resources[0])
+	}
+	held.Return()
+	leased = pool.RequestWithTTL(NewResourceVectorRequest([]int{1}), 10)
+	leased.Return()
+	if _, ok := pool.NextExpiry(); ok {
+		t.Error("expected no outstanding expiry after return")
+	}
+
+	// a leased pool grants every request with a time to live
+	leasedPool := NewLeasedPool(NewResourceVectorPool([]int{1}), 50)
+	leasedPool.Request(NewResourceVectorRequest([]int{1}))
+	if ms, ok := leasedPool.NextExpiry(); !ok || ms != 50 {
+		t.Errorf("expected expiry at 50ms, received %d", ms)
+	}
+}
+
+func TestResourceVectorPoolReserveWithExpiry(t *testing.T) {
+	pool := NewResourceVectorPool([]int{3})
+	pool.Advance(10)
+	expired := pool.ReserveWithExpiry(NewResourceVectorRequest([]int{2}), 100)
+	committed := pool.ReserveWithExpiry(NewResourceVectorRequest([]int{1}), 100)
+	if ms, ok := pool.NextExpiry(); !ok || ms != 100 {
+		t.Errorf("expected expiry at 100ms, received %d", ms)
+	}
+	if pool.Request(NewResourceVectorRequest([]int{1})) != nil {
+		t.Error("expected reserved resources unavailable")
+	}
+
+	// a reservation committed before its deadline no longer expires
+	granted := committed.Commit()
+	if granted == nil {
+		t.Fatal("expected reservation committed")
+	}
+	pool.Advance(99)
+	if pool.resources[0] != 0 {
+		t.Errorf("expected no resources available before expiry, received %d", pool.resources[0])
+	}
+
+	// an uncommitted reservation is cancelled at its deadline, and its resources
+	// are grantable again
+	pool.Advance(100)
+	if pool.resources[0] != 2 {
+		t.Errorf("expected 2 resources reclaimed, received %d", pool.resources[0])
+	}
+	if _, ok := pool.NextExpiry(); ok {
+		t.Error("expected no outstanding expiry")
+	}
+	if expired.Commit() != nil {
+		t.Error("expected commit of expired reservation to return nil")
+	}
+	expired.Cancel()
+	if pool.resources[0] != 2 {
+		t.Errorf("expected cancel of expired reservation ignored, received %d available", pool.resources[0])
+	}
+	if pool.Request(NewResourceVectorRequest([]int{2})) == nil {
+		t.Error("expected expired reservation's resources granted")
+	}
+
+	// the committed resource is returned like any other
+	pool.Advance(1000)
+	if !granted.Return() || pool.resources[0] != 1 {
+		t.Errorf("expected committed resource returned, received %d available", pool.resources[0])
+	}
+
+	// a cancelled reservation no longer expires
+	pool.ReserveWithExpiry(NewResourceVectorRequest([]int{1}), 2000).Cancel()
+	if _, ok := pool.NextExpiry(); ok {
+		t.Error("expected no outstanding expiry after cancel")
+	}
+}
+
+func TestAffinityPool(t *testing.T) {
+	pool := NewAffinityPool(NewResourceVectorPool([]int{2}), 100)
+	request := func(key string) Resource {
+		return pool.Request(NewAffinityRequest(key, NewResourceVectorRequest([]int{1})))
+	}
+	warm := request("a")
+	if warm == nil || request("b") == nil {
+		t.Fatal("expected both requests granted")
+	}
+
+	// a returned resource is held for its key, ahead of any other request
+	if !warm.Return() {
+		t.Fatal("expected resource returned")
+	}
+	if request("b") != nil || pool.Request(NewResourceVectorRequest([]int{1})) != nil {
+		t.Error("expected the held resource denied to other requests")
+	}
+	if warm = request("a"); warm == nil {
+		t.Fatal("expected the held resource granted to its key")
+	}
+
+	// the hold ends once the window passes on the pool's clock
+	warm.Return()
+	pool.Advance(99)
+	if request("b") != nil {
+		t.Error("expected the held resource denied before the window passes")
+	}
+	pool.Advance(100)
+	if request("b") == nil {
+		t.Error("expected the resource granted once the window passes")
+	}
+
+	// expired holds are forgotten along with keys left holding none
+	pool = NewAffinityPool(NewResourceVectorPool([]int{3}), 100)
+	request("a").Return()
+	pool.Advance(50)
+	request("b").Return()
+	if len(pool.held) != 2 {
+		t.Errorf("expected holds for 2 keys, received %d", len(pool.held))
+	}
+	pool.Advance(120)
+	if _, ok := pool.held["a"]; ok || len(pool.held["b"]) != 1 {
+		t.Errorf("expected only the hold for b kept, received %v", pool.held)
+	}
+	pool.Advance(150)
+	if len(pool.held) != 0 {
+		t.Errorf("expected no holds kept, received %v", pool.held)
+	}
+
+	// a key's holds end with its next request, even if it is denied
+	pool = NewAffinityPool(NewResourceVectorPool([]int{2}), 0)
+	request("a").Return()
+	if pool.Request(NewAffinityRequest("a", NewResourceVectorRequest([]int{3}))) != nil {
+		t.Error("expected the request exceeding the pool denied")
+	}
+	if request("b") == nil {
+		t.Error("expected the resource granted once its hold ends")
+	}
+
+	// a resource managed scheduler runs a waiting task of the same key first
+	calc := func(t Task) Resource {
+		key := "even"
+		if t.(testTask).field%2 == 1 {
+			key = "odd"
+		}
+		return NewAffinityRequest(key, NewResourceVectorRequest([]int{1}))
+	}
+	pool = NewAffinityPool(NewResourceVectorPool([]int{1}), 100)
+	scheduler := NewResourceManagedSchedulerWithQueue(NewFifoScheduler(), pool, calc, 2)
+	scheduler.Put(testTask{0}, testTask{1}, testTask{2})
+	running := scheduler.Next()
+	expectTaskEquals(t, running.Task(), testTask{0})
+	expectNilTask(t, scheduler.Next())
+	running.Close()
+	running = scheduler.Next()
+	expectTaskEquals(t, running.Task(), testTask{2})
+	running.Close()
+	expectNilTask(t, scheduler.Next())
+	pool.Advance(100)
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
+}
+
+func TestCompositePoolRequest(t *testing.T) {
+	first, second := NewResourceVectorPool([]int{1, 1}), NewResourceVectorPool([]int{2, 2})
+	var pool ResourcePool = NewCompositePool(first, second)
+
+	// the first pool is tried first
+	fromFirst := pool.Request(NewResourceVectorRequest([]int{1, 1}))
+	if fromFirst == nil || first.resources[0] != 0 || second.resources[0] != 2 {
+		t.Fatalf("expected request granted from the first pool, received %v and %v", first.resources, second.resources)
+	}
+
+	// the second satisfies requests once the first is exhausted
+	fromSecond := pool.Request(NewResourceVectorRequest([]int{1, 1}))
+	if fromSecond == nil || second.resources[0] != 1 {
+		t.Fatalf("expected request granted from the second pool, received %v", second.resources)
+	}
+	if pool.Request(NewResourceVectorRequest([]int{2, 0})) != nil {
+		t.Error("expected request no single pool can grant denied")
+	}
+
+	// resources are returned to the pool they came from
+	fromSecond.Return()
+	if first.resources[0] != 0 || second.resources[0] != 2 {
+		t.Errorf("expected resource returned to the second pool, received %v and %v", first.resources, second.resources)
+	}
+	fromFirst.Return()
+	if first.resources[0] != 1 || second.resources[0] != 2 {
+		t.Errorf("expected resource returned to the first pool, received %v and %v", first.resources, second.resources)
+	}
+
+	if NewCompositePool().Request(NewResourceVectorRequest([]int{1})) != nil {
+		t.Error("expected an empty composite pool to deny requests")
+	}
+}
+
+func TestResourceFloatVectorPoolRequest(t *testing.T) {
+	pool := NewResourceFloatVectorPool([]float64{1.0})
+	granted := []Resource{}
+	for {
+		returned := pool.Request(NewResourceFloatVectorRequest([]float64{0.3}))
+		if returned == nil {
+			break
+		}
+		granted = append(granted, returned)
+	}
+	if len(granted) != 3 {
+		t.Errorf("expected 3 requests granted, received %d", len(granted))
+	}
+	if math.Abs(pool.resources[0]-0.1) > floatResourceEpsilon {
+		t.Errorf("expected 0.1 remaining, received %v", pool.resources[0])
+	}
+	for _, r := range granted {
+		if !r.Return() {
+			t.Error("expected resource returned")
+		}
+		if r.Return() {
+			t.Error("expected resource returned only once")
+		}
+	}
+	if math.Abs(pool.resources[0]-1.0) > floatResourceEpsilon {
+		t.Errorf("expected pool replenished to 1.0, received %v", pool.resources[0])
+	}
+
+	// requests summing to the pool exactly are all granted despite rounding error
+	pool = NewResourceFloatVectorPool([]float64{1.0})
+	for i := 0; i < 10; i++ {
+		if pool.Request(NewResourceFloatVectorRequest([]float64{0.1})) == nil {
+			t.Fatalf("expected request %d granted", i+1)
+		}
+	}
+	if pool.resources[0] < 0 {
+		t.Errorf("expected no negative resources, received %v", pool.resources[0])
+	}
+	if pool.Request(NewResourceFloatVectorRequest([]float64{0.1})) != nil {
+		t.Error("expected invalid resource request")
+	}
+
+	// a request for the last unit plus the tolerance takes only what is left, so
+	// returning it restores the pool to exactly its capacity
+	pool = NewResourceFloatVectorPool([]float64{1.0})
+	last := pool.Request(NewResourceFloatVectorRequest([]float64{1.0 + floatResourceEpsilon}))
+	if last == nil {
+		t.Fatal("expected request within the tolerance granted")
+	}
+	if pool.resources[0] != 0 {
+		t.Errorf("expected pool exhausted, received %v", pool.resources[0])
+	}
+	last.Return()
+	if pool.resources[0] != 1.0 {
+		t.Errorf("expected pool restored to exactly 1.0, received %v", pool.resources[0])
+	}
+
+	// mismatched lengths and other resource types are rejected
+	if pool.Request(NewResourceFloatVectorRequest([]float64{0, 0})) != nil {
+		t.Error("expected invalid resource request")
+	}
+	if pool.Request(NewResourceVectorRequest([]int{0})) != nil {
+		t.Error("expected invalid resource request")
+	}
+}
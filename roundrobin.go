@@ -0,0 +1,121 @@
+package schedule
+
+// A FlatPartitioner maps a task to the key of its partition and a factory for
+// the partition's scheduler.
+type FlatPartitioner func(t Task) (key string, factory SchedulerFactory)
+
+// A FlatRoundRobinScheduler partitions tasks by key like a PartitionedScheduler
+// but has no priorities: Next() round robins over every partition, so each key
+// with pending tasks is served once in every round. A partition is discarded once
+// its scheduler is empty.
+type FlatRoundRobinScheduler struct {
+	partitioner FlatPartitioner
+	pos         int
+	partitionSet
+	schedulerStatsRecorder
+}
+
+func NewFlatRoundRobinScheduler(p FlatPartitioner) *FlatRoundRobinScheduler {
+	key := func(t Task) string {
+		key, _ := p(t)
+		return key
+	}
+	return &FlatRoundRobinScheduler{p, 0, newPartitionSet(key), schedulerStatsRecorder{}}
+}
+
+func (f *FlatRoundRobinScheduler) Put(tasks ...Task) {
+	before := f.Size()
+	for _, t := range tasks {
+		key, fact := f.partitioner(t)
+		part := f.partition(key)
+		if part == nil {
+			part = f.add(key, 0, fact())
+		}
+		part.scheduler.Put(t)
+	}
+	f.prune()
+	f.recordPut(f.Size()-before, f.Size())
+}
+
+func (f *FlatRoundRobinScheduler) Next() ScheduledTask {
+	for i := 0; i < len(f.partitions); i++ {
+		idx := (f.pos + i) % len(f.partitions)
+		if next := f.partitions[idx].scheduler.Next(); next != nil {
+			f.pos = (idx + 1) % len(f.partitions)
+			f.prune()
+			return f.recordNext(next)
+		}
+	}
+	return nil
+}
+
+func (f *FlatRoundRobinScheduler) NextN(n int) []ScheduledTask {
+	return nextN(f, n)
+}
+
+func (f *FlatRoundRobinScheduler) PeekN(n int) []Task {
+	return peekN(f, n)
+}
+
+func (f *FlatRoundRobinScheduler) CopyInto(dst Scheduler) {
+	copyInto(f, dst)
+}
+
+// Drain drains each partition and interleaves their tasks, round robinning from the
+// current position as Next would. The partitions are drained rather than emitted
+// from, so resources are not requested for their tasks.
+func (f *FlatRoundRobinScheduler) Drain() []Task {
+	tasks := f.interleave(Scheduler.Drain)
+	f.Clear()
+	return tasks
+}
+
+// Each visits the tasks of each partition, round robinning from the current position
+// as Next would.
+func (f *FlatRoundRobinScheduler) Each(fn func(Task) bool) {
+	visit(f.interleave(collect), fn)
+}
+
+// interleave takes the tasks of each partition using take and interleaves them,
+// starting from the current position.
+func (f *FlatRoundRobinScheduler) interleave(take func(Scheduler) []Task) []Task {
+	taken := make([][]Task, len(f.partitions))
+	for i := range f.partitions {
+		taken[i] = take(f.partitions[(f.pos+i)%len(f.partitions)].scheduler)
+	}
+	tasks := []Task{}
+	for remaining := true; remaining; {
+		remaining = false
+		for i := range taken {
+			if len(taken[i]) > 0 {
+				tasks = append(tasks, taken[i][0])
+				taken[i] = taken[i][1:]
+				remaining = true
+			}
+		}
+	}
+	return tasks
+}
+
+func (f *FlatRoundRobinScheduler) Remove(id string) Task {
+	if t := f.remove(id); t != nil {
+		f.prune()
+		return f.recordRemove(t)
+	}
+	return nil
+}
+
+// prune discards partitions whose schedulers are empty, keeping the round robin
+// position on the partition that would have been served next.
+func (f *FlatRoundRobinScheduler) prune() {
+	f.pos, _ = f.pruneAt(nonEmpty, f.pos)
+}
+
+func (f *FlatRoundRobinScheduler) Stats() SchedulerStats {
+	return f.stats(f.Size())
+}
+
+func (f *FlatRoundRobinScheduler) Clear() {
+	f.clear()
+	f.pos = 0
+}
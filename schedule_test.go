@@ -2,6 +2,9 @@ package schedule
 
 import (
 	"fmt"
+	"math"
+	"reflect"
+	"strconv"
 	"testing"
 )
 
@@ -25,6 +28,9 @@ func expectContains(t *testing.T, scheduler Scheduler, task Task, contains bool)
 	if scheduler.Contains(task) != contains {
 		t.Errorf("expected contains %v, received %v", contains, scheduler.Contains(task))
 	}
+	if scheduler.ContainsId(task.Id()) != contains {
+		t.Errorf("expected contains id %v, received %v", contains, scheduler.ContainsId(task.Id()))
+	}
 }
 
 func expectNilTask(t *testing.T, task Task) {
@@ -84,6 +90,12 @@ func testCommonContains(t *testing.T, scheduler Scheduler) {
 
 	expectContains(t, scheduler, testTask{field: 2}, false)
 	expectContains(t, scheduler, testTask{field: 3}, false)
+
+	// ids are looked up without a task
+	scheduler.Put(testTask{4})
+	if !scheduler.ContainsId(testTask{4}.Id()) || scheduler.ContainsId(testTask{5}.Id()) {
+		t.Error("expected ContainsId to agree with Contains")
+	}
 }
 
 func testCommonRemove(t *testing.T, scheduler Scheduler) {
@@ -101,12 +113,164 @@ func testCommonRemove(t *testing.T, scheduler Scheduler) {
 	expectSizeEquals(t, scheduler, 0)
 }
 
+func testCommonClear(t *testing.T, scheduler Scheduler) {
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3})
+	scheduler.Next().Close()
+	scheduler.Clear()
+	expectSizeEquals(t, scheduler, 0)
+	expectContains(t, scheduler, testTask{2}, false)
+	expectNilTask(t, scheduler.Next())
+
+	// the scheduler is reusable after clearing
+	scheduler.Put(testTask{1}, testTask{2})
+	expectSizeEquals(t, scheduler, 2)
+	expectNotNilTask(t, scheduler.Next())
+	expectNotNilTask(t, scheduler.Next())
+	expectNilTask(t, scheduler.Next())
+}
+
+func testCommonNextN(t *testing.T, scheduler Scheduler) {
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3})
+	tasks := scheduler.NextN(2)
+	if len(tasks) != 2 {
+		t.Errorf("expected 2 tasks, received %d", len(tasks))
+	}
+	for _, task := range tasks {
+		task.Close()
+	}
+	expectSizeEquals(t, scheduler, 1)
+
+	// returns fewer than n once the scheduler runs dry
+	if tasks = scheduler.NextN(5); len(tasks) != 1 {
+		t.Errorf("expected 1 task, received %d", len(tasks))
+	}
+	if tasks = scheduler.NextN(1); len(tasks) != 0 {
+		t.Errorf("expected no tasks, received %d", len(tasks))
+	}
+	expectSizeEquals(t, scheduler, 0)
+}
+
+// testCommonDrain drains scheduler and compares the order against calling Next on
+// other, which must be an identical copy of scheduler.
+func testCommonDrain(t *testing.T, scheduler, other Scheduler) {
+	expectTasksLen := func(tasks []Task, n int) {
+		if len(tasks) != n {
+			t.Errorf("expected %d drained tasks, received %d", n, len(tasks))
+		}
+	}
+	expectTasksLen(scheduler.Drain(), 0)
+	for _, s := range []Scheduler{scheduler, other} {
+		for i := 1; i <= 10; i++ {
+			s.Put(testTask{i})
+		}
+		s.Remove(testTask{4}.Id())
+	}
+	size := scheduler.Size()
+	drained := scheduler.Drain()
+	expectTasksLen(drained, size)
+	expectSizeEquals(t, scheduler, 0)
+	expectNilTask(t, scheduler.Next())
+	for _, task := range drained {
+		next := other.Next()
+		if next == nil {
+			t.Fatal("expected not nil task")
+		}
+		expectTaskEquals(t, task, next.Task())
+		next.Close()
+	}
+	expectNilTask(t, other.Next())
+
+	// the scheduler is reusable after draining
+	scheduler.Put(testTask{1})
+	expectSizeEquals(t, scheduler, 1)
+	expectTasksLen(scheduler.Drain(), 1)
+}
+
+func testCommonEach(t *testing.T, scheduler Scheduler) {
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3})
+	visited := []Task{}
+	scheduler.Each(func(task Task) bool {
+		visited = append(visited, task)
+		return true
+	})
+	if len(visited) != 3 {
+		t.Errorf("expected 3 visited tasks, received %d", len(visited))
+	}
+	expectSizeEquals(t, scheduler, 3)
+
+	// stops early once the callback returns false
+	count := 0
+	scheduler.Each(func(Task) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Errorf("expected 2 visited tasks, received %d", count)
+	}
+	expectSizeEquals(t, scheduler, 3)
+
+	// PeekN returns the tasks Each visits first
+	peeked := scheduler.PeekN(2)
+	if len(peeked) != 2 {
+		t.Fatalf("expected 2 peeked tasks, received %d", len(peeked))
+	}
+	for i := range peeked {
+		expectTaskEquals(t, peeked[i], visited[i])
+	}
+	if peeked = scheduler.PeekN(5); len(peeked) != 3 {
+		t.Errorf("expected 3 peeked tasks, received %d", len(peeked))
+	}
+	if peeked = scheduler.PeekN(0); len(peeked) != 0 {
+		t.Errorf("expected no peeked tasks, received %d", len(peeked))
+	}
+	expectSizeEquals(t, scheduler, 3)
+
+	// CopyInto puts the tasks in the order Each visits them
+	copied := NewFifoScheduler()
+	scheduler.CopyInto(copied)
+	expectSizeEquals(t, scheduler, 3)
+	for i, task := range collect(copied) {
+		expectTaskEquals(t, task, visited[i])
+	}
+}
+
+func testCommonStats(t *testing.T, scheduler Scheduler) {
+	expectStats := func(expected SchedulerStats) {
+		if stats := scheduler.Stats(); stats != expected {
+			t.Errorf("expected stats %+v, received %+v", expected, stats)
+		}
+	}
+	expectStats(SchedulerStats{})
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3}, testTask{1})
+	expectStats(SchedulerStats{Puts: 3, Size: 3, PeakSize: 3})
+	expectNilTask(t, scheduler.Remove("missing"))
+	expectNotNilTask(t, scheduler.Remove(testTask{2}.Id()))
+	next := scheduler.Next()
+	expectNotNilTask(t, next)
+	next.Close()
+	expectStats(SchedulerStats{Puts: 3, Nexts: 1, Removes: 1, Size: 1, PeakSize: 3})
+
+	// counters survive clearing the scheduler
+	scheduler.Clear()
+	scheduler.Put(testTask{4})
+	expectStats(SchedulerStats{Puts: 4, Nexts: 1, Removes: 1, Size: 1, PeakSize: 3})
+
+	// draining doesn't count as returning tasks from Next
+	scheduler.Drain()
+	expectStats(SchedulerStats{Puts: 4, Nexts: 1, Removes: 1, Size: 0, PeakSize: 3})
+}
+
 func TestFifoScheduler(t *testing.T) {
 	// common
 	testCommonDupTask(t, NewFifoScheduler())
 	testCommonSize(t, NewFifoScheduler())
 	testCommonContains(t, NewFifoScheduler())
 	testCommonRemove(t, NewFifoScheduler())
+	testCommonClear(t, NewFifoScheduler())
+	testCommonNextN(t, NewFifoScheduler())
+	testCommonEach(t, NewFifoScheduler())
+	testCommonDrain(t, NewFifoScheduler(), NewFifoScheduler())
+	testCommonStats(t, NewFifoScheduler())
 
 	// returns items in the order they were inserted
 	scheduler := NewFifoScheduler()
@@ -114,6 +278,475 @@ func TestFifoScheduler(t *testing.T) {
 	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
 	expectTaskEquals(t, scheduler.Next().Task(), testTask{2})
 	expectNilTask(t, scheduler.Next())
+
+	// heavy churn of puts and removes neither grows the backing array nor retains removed tasks
+	scheduler = NewFifoScheduler()
+	scheduler.Put(testTask{-1})
+	for i := 0; i < 10000; i++ {
+		scheduler.Put(testTask{i}, testTask{i + 10000})
+		scheduler.Remove(testTask{i + 10000}.Id())
+		scheduler.Remove(testTask{i}.Id())
+	}
+	if c := cap(scheduler.elements); c > 2*int(scheduler.maxUnusedSliceSpace) {
+		t.Errorf("expected bounded backing array, received capacity %d", c)
+	}
+	for _, e := range scheduler.elements[len(scheduler.elements):cap(scheduler.elements)] {
+		if e != nil {
+			t.Errorf("expected vacated slot to be cleared, received %v", e)
+		}
+	}
+	expectSizeEquals(t, scheduler, 1)
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{-1})
+
+	// a higher reallocation threshold allocates less under the same churn
+	if n := NewFifoScheduler(WithReallocThreshold(0)).maxUnusedSliceSpace; n != 1 {
+		t.Errorf("expected a zero threshold treated as 1, received %d", n)
+	}
+	low, high := fifoChurnAllocs(1), fifoChurnAllocs(255)
+	if high >= low {
+		t.Errorf("expected fewer allocations at a higher threshold, received %.1f at 1 and %.1f at 255", low, high)
+	}
+
+	// estimated wait sums the cost of the tasks ahead
+	cost := func(t Task) int { return 10 * t.(testTask).field }
+	scheduler = NewFifoScheduler()
+	scheduler.Put(testTask{3}, testTask{1}, testTask{2})
+	for _, c := range []struct{ field, expected int }{{3, 0}, {1, 30}, {2, 40}, {4, -1}} {
+		if wait := scheduler.EstimatedWaitMs(testTask{c.field}.Id(), cost); wait != c.expected {
+			t.Errorf("expected task %d to wait %dms, received %d", c.field, c.expected, wait)
+		}
+	}
+	expectSizeEquals(t, scheduler, 3)
+}
+
+// fifoChurn puts and removes tasks from a FifoScheduler holding a single task.
+func fifoChurn(scheduler *FifoScheduler, n int) {
+	for i := 0; i < n; i++ {
+		scheduler.Put(testTask{i % 100})
+		scheduler.Remove(testTask{i % 100}.Id())
+	}
+}
+
+// fifoChurnAllocs returns the average allocations of a round of churn on a
+// FifoScheduler with the given reallocation threshold.
+func fifoChurnAllocs(threshold uint8) float64 {
+	scheduler := NewFifoScheduler(WithReallocThreshold(threshold))
+	scheduler.Put(testTask{-1})
+	return testing.AllocsPerRun(10, func() { fifoChurn(scheduler, 1000) })
+}
+
+func BenchmarkFifoSchedulerChurn(b *testing.B) {
+	for _, threshold := range []uint8{1, 16, 255} {
+		b.Run(fmt.Sprintf("threshold=%d", threshold), func(b *testing.B) {
+			scheduler := NewFifoScheduler(WithReallocThreshold(threshold))
+			scheduler.Put(testTask{-1})
+			b.ReportAllocs()
+			b.ResetTimer()
+			fifoChurn(scheduler, b.N)
+		})
+	}
+}
+
+func TestBoundedFifoScheduler(t *testing.T) {
+	// common
+	testCommonDupTask(t, NewBoundedFifoScheduler(3))
+	testCommonSize(t, NewBoundedFifoScheduler(3))
+	testCommonContains(t, NewBoundedFifoScheduler(3))
+	testCommonRemove(t, NewBoundedFifoScheduler(3))
+	testCommonClear(t, NewBoundedFifoScheduler(3))
+	testCommonNextN(t, NewBoundedFifoScheduler(3))
+	testCommonEach(t, NewBoundedFifoScheduler(3))
+	testCommonDrain(t, NewBoundedFifoScheduler(3), NewBoundedFifoScheduler(3))
+	testCommonStats(t, NewBoundedFifoScheduler(3))
+
+	// tasks beyond capacity are dropped
+	scheduler := NewBoundedFifoScheduler(3)
+	if n := scheduler.PutN(testTask{1}, testTask{1}, testTask{2}); n != 2 {
+		t.Errorf("expected 2 tasks admitted, received %d", n)
+	}
+	if n := scheduler.PutN(testTask{3}, testTask{4}, testTask{5}); n != 1 {
+		t.Errorf("expected 1 task admitted, received %d", n)
+	}
+	scheduler.Put(testTask{6})
+	expectSizeEquals(t, scheduler, 3)
+	expectContains(t, scheduler, testTask{4}, false)
+	expectContains(t, scheduler, testTask{6}, false)
+
+	// room is made as tasks leave the scheduler
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
+	if n := scheduler.PutN(testTask{4}, testTask{5}); n != 1 {
+		t.Errorf("expected 1 task admitted, received %d", n)
+	}
+	expectSizeEquals(t, scheduler, 3)
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{2})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{3})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{4})
+	expectNilTask(t, scheduler.Next())
+
+	// a capacity below 1 is treated as 1 rather than unbounded
+	for _, capacity := range []int{0, -1} {
+		scheduler = NewBoundedFifoScheduler(capacity)
+		if n := scheduler.PutN(testTask{1}, testTask{2}); n != 1 {
+			t.Errorf("expected 1 task admitted with capacity %d, received %d", capacity, n)
+		}
+		expectSizeEquals(t, scheduler, 1)
+		expectContains(t, scheduler, testTask{2}, false)
+	}
+}
+
+// versionedTask is a task whose id ignores its version, so versions of the same
+// task are duplicates of one another.
+type versionedTask struct {
+	field   int
+	version int
+}
+
+func (v versionedTask) Id() string {
+	return fmt.Sprintf("%d", v.field)
+}
+
+func TestFifoSchedulerDuplicatePolicy(t *testing.T) {
+	// common
+	testCommonDupTask(t, NewFifoSchedulerWithDuplicatePolicy(ReplaceDuplicate))
+	testCommonSize(t, NewFifoSchedulerWithDuplicatePolicy(ReplaceDuplicate))
+	testCommonContains(t, NewFifoSchedulerWithDuplicatePolicy(ReplaceDuplicate))
+	testCommonRemove(t, NewFifoSchedulerWithDuplicatePolicy(ReplaceDuplicate))
+	testCommonClear(t, NewFifoSchedulerWithDuplicatePolicy(ReplaceDuplicate))
+	testCommonNextN(t, NewFifoSchedulerWithDuplicatePolicy(ReplaceDuplicate))
+	testCommonEach(t, NewFifoSchedulerWithDuplicatePolicy(ReplaceDuplicate))
+	testCommonDrain(t, NewFifoSchedulerWithDuplicatePolicy(ReplaceDuplicate), NewFifoSchedulerWithDuplicatePolicy(ReplaceDuplicate))
+	testCommonStats(t, NewFifoSchedulerWithDuplicatePolicy(ReplaceDuplicate))
+
+	expectVersion := func(st ScheduledTask, expected versionedTask) {
+		if st == nil || st.Task() != expected {
+			t.Errorf("expected task %v, received %v", expected, st)
+		}
+	}
+
+	// ignoring duplicates keeps the task first put
+	scheduler := NewFifoSchedulerWithDuplicatePolicy(IgnoreDuplicate)
+	scheduler.Put(versionedTask{1, 1}, versionedTask{2, 1})
+	scheduler.Put(versionedTask{1, 2})
+	expectVersion(scheduler.Next(), versionedTask{1, 1})
+
+	// replacing a duplicate updates the task returned without changing its position
+	scheduler = NewFifoSchedulerWithDuplicatePolicy(ReplaceDuplicate)
+	scheduler.Put(versionedTask{1, 1}, versionedTask{2, 1}, versionedTask{3, 1})
+	if n := scheduler.PutN(versionedTask{2, 2}, versionedTask{4, 1}); n != 1 {
+		t.Errorf("expected 1 task admitted, received %d", n)
+	}
+	expectSizeEquals(t, scheduler, 4)
+	expectVersion(scheduler.Next(), versionedTask{1, 1})
+	expectVersion(scheduler.Next(), versionedTask{2, 2})
+	expectVersion(scheduler.Next(), versionedTask{3, 1})
+	expectVersion(scheduler.Next(), versionedTask{4, 1})
+	expectNilTask(t, scheduler.Next())
+
+	// a full scheduler still replaces the tasks it holds
+	bounded := NewBoundedFifoScheduler(1)
+	bounded.duplicates = ReplaceDuplicate
+	bounded.Put(versionedTask{1, 1})
+	bounded.Put(versionedTask{1, 2}, versionedTask{2, 1})
+	expectSizeEquals(t, bounded, 1)
+	expectVersion(bounded.Next(), versionedTask{1, 2})
+}
+
+func TestLifoScheduler(t *testing.T) {
+	// common
+	testCommonDupTask(t, NewLifoScheduler())
+	testCommonSize(t, NewLifoScheduler())
+	testCommonContains(t, NewLifoScheduler())
+	testCommonRemove(t, NewLifoScheduler())
+	testCommonClear(t, NewLifoScheduler())
+	testCommonNextN(t, NewLifoScheduler())
+	testCommonEach(t, NewLifoScheduler())
+	testCommonDrain(t, NewLifoScheduler(), NewLifoScheduler())
+	testCommonStats(t, NewLifoScheduler())
+
+	// returns items in the reverse order they were inserted
+	scheduler := NewLifoScheduler()
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{3})
+	scheduler.Put(testTask{4})
+	expectTaskEquals(t, scheduler.Remove(testTask{2}.Id()), testTask{2})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{4})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
+	expectNilTask(t, scheduler.Next())
+
+	// vacated slots do not retain removed tasks
+	scheduler = NewLifoScheduler()
+	for i := 0; i < 100; i++ {
+		scheduler.Put(testTask{i})
+	}
+	for i := 0; i < 100; i++ {
+		scheduler.Next()
+	}
+	for _, e := range scheduler.elements[:cap(scheduler.elements)] {
+		if e != nil {
+			t.Errorf("expected vacated slot to be cleared, received %v", e)
+		}
+	}
+}
+
+func TestShortestJobScheduler(t *testing.T) {
+	cost := func(t Task) int {
+		if st, ok := t.(*SimTask); ok {
+			return st.RuntimeMs
+		}
+		return t.(testTask).field
+	}
+
+	// common
+	testCommonDupTask(t, NewShortestJobScheduler(cost))
+	testCommonSize(t, NewShortestJobScheduler(cost))
+	testCommonContains(t, NewShortestJobScheduler(cost))
+	testCommonRemove(t, NewShortestJobScheduler(cost))
+	testCommonClear(t, NewShortestJobScheduler(cost))
+	testCommonNextN(t, NewShortestJobScheduler(cost))
+	testCommonEach(t, NewShortestJobScheduler(cost))
+	testCommonDrain(t, NewShortestJobScheduler(cost), NewShortestJobScheduler(cost))
+	testCommonStats(t, NewShortestJobScheduler(cost))
+
+	// returns tasks shortest first, breaking ties in insertion order
+	scheduler := NewShortestJobScheduler(cost)
+	scheduler.Put(
+		&SimTask{Identifier: 1, UserId: 1, RuntimeMs: 30},
+		&SimTask{Identifier: 2, UserId: 1, RuntimeMs: 10},
+		&SimTask{Identifier: 3, UserId: 2, RuntimeMs: 20},
+		&SimTask{Identifier: 4, UserId: 2, RuntimeMs: 10},
+		&SimTask{Identifier: 5, UserId: 1, RuntimeMs: 5},
+	)
+	if removed := scheduler.Remove("3"); removed == nil || removed.(*SimTask).Identifier != 3 {
+		t.Errorf("expected task 3 removed, received %v", removed)
+	}
+	for _, id := range []int{5, 2, 4, 1} {
+		next := scheduler.Next()
+		if next == nil || next.Task().(*SimTask).Identifier != id {
+			t.Errorf("expected task %d, received %v", id, next)
+		}
+	}
+	expectNilTask(t, scheduler.Next())
+}
+
+func TestEarliestDeadlineScheduler(t *testing.T) {
+	deadlines := map[int]int{1: 300, 2: 100, 3: 200, 4: 100, 5: 50}
+	deadline := func(t Task) int {
+		return deadlines[t.(testTask).field]
+	}
+
+	// common
+	testCommonDupTask(t, NewEarliestDeadlineScheduler(deadline))
+	testCommonSize(t, NewEarliestDeadlineScheduler(deadline))
+	testCommonContains(t, NewEarliestDeadlineScheduler(deadline))
+	testCommonRemove(t, NewEarliestDeadlineScheduler(deadline))
+	testCommonClear(t, NewEarliestDeadlineScheduler(deadline))
+	testCommonNextN(t, NewEarliestDeadlineScheduler(deadline))
+	testCommonEach(t, NewEarliestDeadlineScheduler(deadline))
+	testCommonDrain(t, NewEarliestDeadlineScheduler(deadline), NewEarliestDeadlineScheduler(deadline))
+	testCommonStats(t, NewEarliestDeadlineScheduler(deadline))
+
+	// returns tasks in deadline order regardless of insertion order, breaking ties in insertion order
+	scheduler := NewEarliestDeadlineScheduler(deadline)
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3}, testTask{4}, testTask{5})
+	if d, ok := scheduler.Deadline(testTask{3}.Id()); !ok || d != 200 {
+		t.Errorf("expected deadline 200, received %d", d)
+	}
+	if _, ok := scheduler.Deadline(testTask{6}.Id()); ok {
+		t.Error("expected no deadline for absent task")
+	}
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{5})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{2})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{4})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{3})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
+	expectNilTask(t, scheduler.Next())
+}
+
+func TestDelayScheduler(t *testing.T) {
+	releases := map[int]int{1: 500, 2: 100, 3: 100, 4: 300}
+	release := func(t Task) int {
+		return releases[t.(testTask).field]
+	}
+	immediate := func(t Task) int { return 0 }
+
+	// common
+	testCommonDupTask(t, NewDelayScheduler(immediate))
+	testCommonSize(t, NewDelayScheduler(immediate))
+	testCommonContains(t, NewDelayScheduler(immediate))
+	testCommonRemove(t, NewDelayScheduler(immediate))
+	testCommonClear(t, NewDelayScheduler(immediate))
+	testCommonNextN(t, NewDelayScheduler(immediate))
+	testCommonEach(t, NewDelayScheduler(immediate))
+	testCommonDrain(t, NewDelayScheduler(immediate), NewDelayScheduler(immediate))
+	testCommonStats(t, NewDelayScheduler(immediate))
+
+	// a task is invisible to Next() until its release time
+	scheduler := NewDelayScheduler(release)
+	scheduler.Put(testTask{1})
+	expectNilTask(t, scheduler.Next())
+	scheduler.Advance(499)
+	expectNilTask(t, scheduler.Next())
+	expectSizeEquals(t, scheduler, 1)
+	scheduler.Advance(500)
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
+	expectNilTask(t, scheduler.Next())
+
+	// released tasks are returned in release order, breaking ties in insertion order
+	scheduler = NewDelayScheduler(release)
+	scheduler.Put(testTask{1}, testTask{4}, testTask{3}, testTask{2})
+	scheduler.Advance(300)
+	if batch := scheduler.NextN(4); len(batch) != 3 {
+		t.Errorf("expected 3 released tasks, received %d", len(batch))
+	} else {
+		expectTaskEquals(t, batch[0].Task(), testTask{3})
+		expectTaskEquals(t, batch[1].Task(), testTask{2})
+		expectTaskEquals(t, batch[2].Task(), testTask{4})
+	}
+
+	// the clock doesn't move backwards
+	scheduler.Advance(100)
+	if scheduler.Now() != 300 {
+		t.Errorf("expected clock at 300ms, received %d", scheduler.Now())
+	}
+
+	// draining includes tasks not yet released
+	expectTaskEquals(t, scheduler.Drain()[0], testTask{1})
+	expectSizeEquals(t, scheduler, 0)
+}
+
+func TestComparatorScheduler(t *testing.T) {
+	byId := func(a, b Task) bool { return a.Id() < b.Id() }
+	byCostDescending := func(a, b Task) bool { return a.(testTask).field%3 > b.(testTask).field%3 }
+
+	// common
+	testCommonDupTask(t, NewComparatorScheduler(byId))
+	testCommonSize(t, NewComparatorScheduler(byId))
+	testCommonContains(t, NewComparatorScheduler(byId))
+	testCommonRemove(t, NewComparatorScheduler(byId))
+	testCommonClear(t, NewComparatorScheduler(byId))
+	testCommonNextN(t, NewComparatorScheduler(byId))
+	testCommonEach(t, NewComparatorScheduler(byId))
+	testCommonDrain(t, NewComparatorScheduler(byId), NewComparatorScheduler(byId))
+	testCommonStats(t, NewComparatorScheduler(byCostDescending))
+
+	// ids are compared as strings
+	scheduler := NewComparatorScheduler(byId)
+	scheduler.Put(testTask{3}, testTask{20}, testTask{100}, testTask{1})
+	for _, field := range []int{1, 100, 20, 3} {
+		expectTaskEquals(t, scheduler.Next().Task(), testTask{field})
+	}
+	expectNilTask(t, scheduler.Next())
+
+	// returns the most costly first, breaking ties in insertion order
+	scheduler = NewComparatorScheduler(byCostDescending)
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3}, testTask{4}, testTask{5})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{2})
+	expectTaskEquals(t, scheduler.Remove(testTask{5}.Id()), testTask{5})
+	scheduler.Put(testTask{8}, testTask{6})
+	visited := collect(scheduler)
+	for i, field := range []int{8, 1, 4, 3, 6} {
+		expectTaskEquals(t, visited[i], testTask{field})
+		expectTaskEquals(t, scheduler.Next().Task(), testTask{field})
+	}
+	expectSizeEquals(t, scheduler, 0)
+}
+
+func TestPriorityScheduler(t *testing.T) {
+	priority := func(t Task) int {
+		return t.(testTask).field % 3
+	}
+
+	// common
+	testCommonDupTask(t, NewPriorityScheduler(priority))
+	testCommonSize(t, NewPriorityScheduler(priority))
+	testCommonContains(t, NewPriorityScheduler(priority))
+	testCommonRemove(t, NewPriorityScheduler(priority))
+	testCommonClear(t, NewPriorityScheduler(priority))
+	testCommonNextN(t, NewPriorityScheduler(priority))
+	testCommonEach(t, NewPriorityScheduler(priority))
+	testCommonDrain(t, NewPriorityScheduler(priority), NewPriorityScheduler(priority))
+	testCommonStats(t, NewPriorityScheduler(priority))
+
+	// returns highest priority first, breaking ties in insertion order
+	scheduler := NewPriorityScheduler(priority)
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3}, testTask{4}, testTask{5})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{2})
+	expectTaskEquals(t, scheduler.Remove(testTask{5}.Id()), testTask{5})
+	scheduler.Put(testTask{8}, testTask{6}, testTask{7})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{8})
+	expectTaskEquals(t, scheduler.Remove(testTask{4}.Id()), testTask{4})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
+	scheduler.Put(testTask{11})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{11})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{7})
+	expectTaskEquals(t, scheduler.Remove(testTask{3}.Id()), testTask{3})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{6})
+	expectNilTask(t, scheduler.Next())
+	expectSizeEquals(t, scheduler, 0)
+
+	// removing from the middle of a large heap keeps the remaining order intact
+	scheduler = NewPriorityScheduler(func(t Task) int { return t.(testTask).field })
+	for i := 0; i < 50; i++ {
+		scheduler.Put(testTask{(i * 7) % 50})
+	}
+	for i := 0; i < 50; i += 3 {
+		expectTaskEquals(t, scheduler.Remove(testTask{i}.Id()), testTask{i})
+	}
+	for i := 49; i >= 0; i-- {
+		if i%3 != 0 {
+			expectTaskEquals(t, scheduler.Next().Task(), testTask{i})
+		}
+	}
+	expectNilTask(t, scheduler.Next())
+
+	// the lowest int is the lowest priority rather than overflowing when negated
+	extremes := map[int]int{1: math.MinInt, 2: 0, 3: math.MaxInt, 4: math.MinInt + 1}
+	scheduler = NewPriorityScheduler(func(t Task) int { return extremes[t.(testTask).field] })
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3}, testTask{4})
+	for _, field := range []int{3, 2, 4, 1} {
+		expectTaskEquals(t, scheduler.Next().Task(), testTask{field})
+	}
+	expectNilTask(t, scheduler.Next())
+}
+
+func TestRandomScheduler(t *testing.T) {
+	// common
+	testCommonDupTask(t, NewRandomScheduler(1))
+	testCommonSize(t, NewRandomScheduler(1))
+	testCommonContains(t, NewRandomScheduler(1))
+	testCommonRemove(t, NewRandomScheduler(1))
+	testCommonClear(t, NewRandomScheduler(1))
+	testCommonNextN(t, NewRandomScheduler(1))
+	testCommonEach(t, NewRandomScheduler(1))
+	testCommonDrain(t, NewRandomScheduler(1), NewRandomScheduler(1))
+	testCommonStats(t, NewRandomScheduler(1))
+
+	// schedulers with the same seed return tasks in the same order
+	drain := func(seed int64) (ids []string) {
+		scheduler := NewRandomScheduler(seed)
+		for i := 0; i < 100; i++ {
+			scheduler.Put(testTask{i})
+		}
+		scheduler.Remove(testTask{50}.Id())
+		for next := scheduler.Next(); next != nil; next = scheduler.Next() {
+			ids = append(ids, next.Id())
+		}
+		return
+	}
+	one, two, other := drain(42), drain(42), drain(43)
+	if len(one) != 99 || len(two) != 99 {
+		t.Errorf("expected 99 tasks, received %d and %d", len(one), len(two))
+	}
+	same := true
+	for i := range one {
+		if one[i] != two[i] {
+			t.Errorf("expected equal order at %d, received %s and %s", i, one[i], two[i])
+		}
+		same = same && one[i] == other[i]
+	}
+	if same {
+		t.Error("expected different seeds to produce different orders")
+	}
 }
 
 func TestPartitionedScheduler(t *testing.T) {
@@ -144,12 +777,22 @@ func TestPartitionedScheduler(t *testing.T) {
 	testCommonSize(t, NewPartitionedScheduler(noPriPartitioner))
 	testCommonContains(t, NewPartitionedScheduler(noPriPartitioner))
 	testCommonRemove(t, NewPartitionedScheduler(noPriPartitioner))
+	testCommonClear(t, NewPartitionedScheduler(noPriPartitioner))
+	testCommonNextN(t, NewPartitionedScheduler(noPriPartitioner))
+	testCommonEach(t, NewPartitionedScheduler(noPriPartitioner))
+	testCommonDrain(t, NewPartitionedScheduler(noPriPartitioner), NewPartitionedScheduler(noPriPartitioner))
+	testCommonStats(t, NewPartitionedScheduler(noPriPartitioner))
 
 	// test common priority partitioner
 	testCommonDupTask(t, NewPartitionedScheduler(priPartitioner))
 	testCommonSize(t, NewPartitionedScheduler(priPartitioner))
 	testCommonContains(t, NewPartitionedScheduler(priPartitioner))
 	testCommonRemove(t, NewPartitionedScheduler(priPartitioner))
+	testCommonClear(t, NewPartitionedScheduler(priPartitioner))
+	testCommonNextN(t, NewPartitionedScheduler(priPartitioner))
+	testCommonEach(t, NewPartitionedScheduler(priPartitioner))
+	testCommonDrain(t, NewPartitionedScheduler(priPartitioner), NewPartitionedScheduler(priPartitioner))
+	testCommonStats(t, NewPartitionedScheduler(priPartitioner))
 
 	// round robin over partitions
 	noPriScheduler := NewPartitionedScheduler(noPriPartitioner)
@@ -180,37 +823,2650 @@ func TestPartitionedScheduler(t *testing.T) {
 	expectTaskEquals(t, priScheduler.Next().Task(), testTask{4})
 	expectTaskEquals(t, priScheduler.Next().Task(), testTask{2})
 	expectTaskEquals(t, priScheduler.Next().Task(), testTask{5})
-}
 
-func TestResourceManagedScheduler(t *testing.T) {
-	var calc ResourceCalculator = func(t Task) Resource {
-		return &resourceVector{resources: []int{1}}
+	// Each visits tasks round robin within each priority from the current position
+	eachScheduler := NewPartitionedScheduler(func(t Task) (string, uint, SchedulerFactory) {
+		field := t.(testTask).field
+		if field%4 < 2 {
+			return fmt.Sprint(field % 4), 2, schedulerFactory
+		}
+		return fmt.Sprint(field % 4), 1, schedulerFactory
+	})
+	for i := 1; i <= 8; i++ {
+		eachScheduler.Put(testTask{i})
+	}
+	expectTaskEquals(t, eachScheduler.Next().Task(), testTask{1})
+	visited := collect(eachScheduler)
+	expected := []int{4, 5, 8, 2, 3, 6, 7}
+	if len(visited) != len(expected) {
+		t.Fatalf("expected %d visited tasks, received %d", len(expected), len(visited))
+	}
+	for i, field := range expected {
+		expectTaskEquals(t, visited[i], testTask{field})
+		expectTaskEquals(t, eachScheduler.Next().Task(), testTask{field})
 	}
-	testCommonDupTask(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc))
-	testCommonSize(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc))
-	testCommonContains(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc))
-	testCommonRemove(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc))
 
-	// Next() returns nil if no resources exist to schedule the task
-	scheduler := NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc)
-	scheduler.Put(testTask{1})
-	scheduler.Put(testTask{2})
-	scheduler.Put(testTask{3})
-	nextOne := scheduler.Next()
-	nextTwo := scheduler.Next()
-	expectTaskEquals(t, nextOne.Task(), testTask{1})
-	expectTaskEquals(t, nextTwo.Task(), testTask{2})
-	nextThree := scheduler.Next()
-	expectNilTask(t, nextThree)
-	expectSizeEquals(t, scheduler, 1)
-	nextOne.Close()
-	expectTaskEquals(t, scheduler.Next().Task(), testTask{3})
+	// priorities inserted in a scrambled order are drained strictly highest first
+	levels := map[int]uint{0: 5, 1: 1, 2: 9}
+	var scrambledPartitioner Partitioner = func(t Task) (string, uint, SchedulerFactory) {
+		field := t.(testTask).field
+		return fmt.Sprintf("key_%d", field%2), levels[(field/2)%3], schedulerFactory
+	}
+	scrambledScheduler := NewPartitionedScheduler(scrambledPartitioner)
+	for _, field := range []int{2, 5, 10, 0, 4, 7, 3, 1, 11, 6, 9, 8} {
+		scrambledScheduler.Put(testTask{field})
+	}
+	for i := 1; i < len(scrambledScheduler.prioritizedPartitions); i++ {
+		if scrambledScheduler.prioritizedPartitions[i-1].priority <= scrambledScheduler.prioritizedPartitions[i].priority {
+			t.Error("expected priorities in strictly descending order")
+		}
+	}
+	lastPriority := uint(9)
+	for i := 0; i < 12; i++ {
+		next := scrambledScheduler.Next()
+		expectNotNilTask(t, next)
+		_, pri, _ := scrambledPartitioner(next.Task())
+		if pri > lastPriority {
+			t.Errorf("expected priority at most %d, received %d", lastPriority, pri)
+		}
+		lastPriority = pri
+	}
+	expectNilTask(t, scrambledScheduler.Next())
 
-	// checks if the waiting element has a task
-	scheduler = NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc)
-	expectContains(t, scheduler, testTask{1}, false)
-	scheduler.waiting = testTask{1}
-	expectContains(t, scheduler, testTask{1}, true)
-	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
-	expectContains(t, scheduler, testTask{1}, false)
+	// putting into an existing partition does not disturb the round robin position
+	noPriScheduler = NewPartitionedScheduler(noPriPartitioner)
+	noPriScheduler.Put(testTask{1}, testTask{2}, testTask{3})
+	expectTaskEquals(t, noPriScheduler.Next().Task(), testTask{1})
+	noPriScheduler.Put(testTask{5})
+	expectTaskEquals(t, noPriScheduler.Next().Task(), testTask{2})
+	expectTaskEquals(t, noPriScheduler.Next().Task(), testTask{3})
+	expectTaskEquals(t, noPriScheduler.Next().Task(), testTask{5})
+
+	// drained partitions and priority levels are discarded
+	var distinctPartitioner Partitioner = func(t Task) (string, uint, SchedulerFactory) {
+		field := t.(testTask).field
+		return fmt.Sprintf("key_%d", field), uint(field % 4), schedulerFactory
+	}
+	distinctScheduler := NewPartitionedScheduler(distinctPartitioner)
+	for i := 0; i < 2000; i++ {
+		distinctScheduler.Put(testTask{i})
+	}
+	if len(distinctScheduler.prioritizedPartitions) != 4 {
+		t.Errorf("expected 4 priority levels, received %d", len(distinctScheduler.prioritizedPartitions))
+	}
+	for i := 0; i < 2000; i += 2 {
+		expectTaskEquals(t, distinctScheduler.Remove(testTask{i}.Id()), testTask{i})
+	}
+	if len(distinctScheduler.prioritizedPartitions) != 2 {
+		t.Errorf("expected 2 priority levels, received %d", len(distinctScheduler.prioritizedPartitions))
+	}
+	for _, pi := range distinctScheduler.prioritizedPartitions {
+		if len(pi.partitions) != 500 {
+			t.Errorf("expected 500 partitions, received %d", len(pi.partitions))
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		expectNotNilTask(t, distinctScheduler.Next())
+	}
+	expectNilTask(t, distinctScheduler.Next())
+	if len(distinctScheduler.prioritizedPartitions) != 0 {
+		t.Errorf("expected no priority levels, received %d", len(distinctScheduler.prioritizedPartitions))
+	}
+
+	// discarding a partition keeps the round robin position
+	noPriScheduler = NewPartitionedScheduler(func(t Task) (string, uint, SchedulerFactory) {
+		return fmt.Sprintf("key_%d", t.(testTask).field%3), 0, schedulerFactory
+	})
+	noPriScheduler.Put(testTask{0}, testTask{1}, testTask{2}, testTask{3}, testTask{5})
+	expectTaskEquals(t, noPriScheduler.Next().Task(), testTask{0})
+	expectTaskEquals(t, noPriScheduler.Next().Task(), testTask{1})
+	expectTaskEquals(t, noPriScheduler.Next().Task(), testTask{2})
+	expectTaskEquals(t, noPriScheduler.Next().Task(), testTask{3})
+	expectTaskEquals(t, noPriScheduler.Next().Task(), testTask{5})
+	expectSizeEquals(t, noPriScheduler, 0)
+
+	// mixing factories for one key is reported by PutErr
+	mixedScheduler := NewPartitionedScheduler(func(t Task) (string, uint, SchedulerFactory) {
+		field := t.(testTask).field
+		key := fmt.Sprintf("key_%d", field%10)
+		if field >= 100 {
+			return key, 0, func() Scheduler { return NewLifoScheduler() }
+		}
+		return key, 0, schedulerFactory
+	})
+	if err := mixedScheduler.PutErr(testTask{1}, testTask{11}, testTask{2}); err != nil {
+		t.Errorf("expected no error, received %v", err)
+	}
+	if err := mixedScheduler.PutErr(testTask{21}, testTask{101}, testTask{31}); err == nil {
+		t.Error("expected error mixing factories for one key")
+	}
+	expectSizeEquals(t, mixedScheduler, 4)
+	expectContains(t, mixedScheduler, testTask{101}, false)
+	expectContains(t, mixedScheduler, testTask{31}, false)
+
+	// and for a new key at a priority whose partitions use another factory
+	if err := mixedScheduler.PutErr(testTask{103}); err == nil {
+		t.Error("expected error mixing factories within one priority")
+	}
+
+	// Put ignores the factory of a task for an existing partition
+	mixedScheduler.Put(testTask{101})
+	expectSizeEquals(t, mixedScheduler, 5)
+
+	// test common aging
+	testCommonDupTask(t, NewPartitionedSchedulerWithAging(priPartitioner, 0.5))
+	testCommonSize(t, NewPartitionedSchedulerWithAging(priPartitioner, 0.5))
+	testCommonContains(t, NewPartitionedSchedulerWithAging(priPartitioner, 0.5))
+	testCommonRemove(t, NewPartitionedSchedulerWithAging(priPartitioner, 0.5))
+	testCommonClear(t, NewPartitionedSchedulerWithAging(priPartitioner, 0.5))
+	testCommonNextN(t, NewPartitionedSchedulerWithAging(priPartitioner, 0.5))
+	testCommonEach(t, NewPartitionedSchedulerWithAging(priPartitioner, 0.5))
+	testCommonDrain(t, NewPartitionedSchedulerWithAging(priPartitioner, 0.5), NewPartitionedSchedulerWithAging(priPartitioner, 0.5))
+	testCommonStats(t, NewPartitionedSchedulerWithAging(priPartitioner, 0.5))
+
+	// a busy high priority partition starves the low priority one without aging
+	var busyPartitioner Partitioner = func(t Task) (string, uint, SchedulerFactory) {
+		if t.(testTask).field < 1000 {
+			return "high", 2, schedulerFactory
+		}
+		return "low", 1, schedulerFactory
+	}
+	starved := NewPartitionedScheduler(busyPartitioner)
+	starved.Put(testTask{1000})
+	for i := 0; i < 100; i++ {
+		starved.Put(testTask{i})
+		expectTaskEquals(t, starved.Next().Task(), testTask{i})
+	}
+
+	// with aging, the low priority partition overtakes the high priority one
+	// once it has waited long enough to make up the difference in priority
+	aged := NewPartitionedSchedulerWithAging(busyPartitioner, 0.25)
+	aged.Put(testTask{1000})
+	for i := 0; i < 5; i++ {
+		aged.Put(testTask{i})
+		expectTaskEquals(t, aged.Next().Task(), testTask{i})
+	}
+	aged.Put(testTask{5})
+	expectTaskEquals(t, aged.Next().Task(), testTask{1000})
+	expectTaskEquals(t, aged.Next().Task(), testTask{5})
+
+	// Each and Drain order tasks as aged Next would
+	aged = NewPartitionedSchedulerWithAging(busyPartitioner, 0.25)
+	aged.Put(testTask{1000}, testTask{1001})
+	for i := 0; i < 12; i++ {
+		aged.Put(testTask{i})
+	}
+	visited = collect(aged)
+	drained := aged.Drain()
+	expected = []int{0, 1, 2, 3, 4, 1000, 5, 6, 7, 8, 9, 1001, 10, 11}
+	if len(visited) != len(expected) || len(drained) != len(expected) {
+		t.Fatalf("expected %d tasks, received %d visited and %d drained", len(expected), len(visited), len(drained))
+	}
+	for i, field := range expected {
+		expectTaskEquals(t, visited[i], testTask{field})
+		expectTaskEquals(t, drained[i], testTask{field})
+	}
+
+	// test common tie breaker
+	testCommonDupTask(t, NewPartitionedSchedulerWithTieBreaker(priPartitioner, LargestPartitionFirst))
+	testCommonSize(t, NewPartitionedSchedulerWithTieBreaker(priPartitioner, LargestPartitionFirst))
+	testCommonContains(t, NewPartitionedSchedulerWithTieBreaker(priPartitioner, LargestPartitionFirst))
+	testCommonRemove(t, NewPartitionedSchedulerWithTieBreaker(priPartitioner, LargestPartitionFirst))
+	testCommonClear(t, NewPartitionedSchedulerWithTieBreaker(priPartitioner, LargestPartitionFirst))
+	testCommonNextN(t, NewPartitionedSchedulerWithTieBreaker(priPartitioner, LargestPartitionFirst))
+	testCommonEach(t, NewPartitionedSchedulerWithTieBreaker(priPartitioner, LargestPartitionFirst))
+	testCommonDrain(t, NewPartitionedSchedulerWithTieBreaker(priPartitioner, LargestPartitionFirst), NewPartitionedSchedulerWithTieBreaker(priPartitioner, LargestPartitionFirst))
+	testCommonStats(t, NewPartitionedSchedulerWithTieBreaker(priPartitioner, LargestPartitionFirst))
+
+	// the largest backlog is served first, round robinning over partitions of equal size
+	sized := NewPartitionedSchedulerWithTieBreaker(noPriPartitioner, LargestPartitionFirst)
+	sized.Put(testTask{1}, testTask{2}, testTask{4}, testTask{6})
+	expected = []int{2, 4, 1, 6}
+	visited = collect(sized)
+	for i, field := range expected {
+		expectTaskEquals(t, visited[i], testTask{field})
+		expectTaskEquals(t, sized.Next().Task(), testTask{field})
+	}
+	expectNilTask(t, sized.Next())
+
+	// priority still comes before the tie breaker
+	sized = NewPartitionedSchedulerWithTieBreaker(priPartitioner, LargestPartitionFirst)
+	sized.Put(testTask{1}, testTask{2}, testTask{5}, testTask{8}, testTask{3})
+	for _, field := range []int{3, 1, 2, 5, 8} {
+		expectTaskEquals(t, sized.Next().Task(), testTask{field})
+	}
+
+	// estimated wait follows the order of Next across priorities and partitions
+	cost := func(t Task) int { return t.(testTask).field }
+	priScheduler = NewPartitionedScheduler(priPartitioner)
+	priScheduler.Put(testTask{2}, testTask{5}, testTask{4}, testTask{3}, testTask{7})
+	for _, c := range []struct{ field, expected int }{{3, 0}, {4, 3}, {7, 7}, {2, 14}, {5, 16}, {6, -1}} {
+		if wait := priScheduler.EstimatedWaitMs(testTask{c.field}.Id(), cost); wait != c.expected {
+			t.Errorf("expected task %d to wait %dms, received %d", c.field, c.expected, wait)
+		}
+	}
+
+	// test common affinity
+	testCommonDupTask(t, NewPartitionedSchedulerWithAffinity(priPartitioner))
+	testCommonSize(t, NewPartitionedSchedulerWithAffinity(priPartitioner))
+	testCommonContains(t, NewPartitionedSchedulerWithAffinity(priPartitioner))
+	testCommonRemove(t, NewPartitionedSchedulerWithAffinity(priPartitioner))
+	testCommonClear(t, NewPartitionedSchedulerWithAffinity(priPartitioner))
+	testCommonNextN(t, NewPartitionedSchedulerWithAffinity(priPartitioner))
+	testCommonEach(t, NewPartitionedSchedulerWithAffinity(priPartitioner))
+	testCommonDrain(t, NewPartitionedSchedulerWithAffinity(priPartitioner), NewPartitionedSchedulerWithAffinity(priPartitioner))
+	testCommonStats(t, NewPartitionedSchedulerWithAffinity(priPartitioner))
+
+	// a key seen at two priorities has a partition at each without affinity
+	var shiftingPartitioner Partitioner = func(t Task) (string, uint, SchedulerFactory) {
+		field := t.(testTask).field
+		return fmt.Sprintf("key_%d", field%2), uint(field / 10), schedulerFactory
+	}
+	countPartitions := func(s *PartitionedScheduler) (n int) {
+		for _, pi := range s.prioritizedPartitions {
+			n += len(pi.partitions)
+		}
+		return
+	}
+	shifting := NewPartitionedScheduler(shiftingPartitioner)
+	shifting.Put(testTask{1}, testTask{11}, testTask{2})
+	if n := countPartitions(shifting); n != 3 {
+		t.Errorf("expected 3 partitions, received %d", n)
+	}
+
+	// with affinity a single partition holds both, served at the higher priority
+	affine := NewPartitionedSchedulerWithAffinity(shiftingPartitioner)
+	affine.Put(testTask{1}, testTask{11}, testTask{2})
+	if n := countPartitions(affine); n != 2 {
+		t.Errorf("expected 2 partitions, received %d", n)
+	}
+	if pi := affine.prioritizedPartitions[0]; pi.priority != 1 || len(pi.partitions) != 1 || pi.partitions[0].value.Size() != 2 {
+		t.Error("expected one partition holding both tasks at priority 1")
+	}
+	for _, field := range []int{1, 11, 2} {
+		expectTaskEquals(t, affine.Next().Task(), testTask{field})
+	}
+	expectNilTask(t, affine.Next())
+
+	// and falls back to a lower priority once its higher priority tasks are gone
+	affine.Put(testTask{12}, testTask{2}, testTask{3})
+	expectTaskEquals(t, affine.Remove(testTask{12}.Id()), testTask{12})
+	if len(affine.prioritizedPartitions) != 1 || countPartitions(affine) != 2 {
+		t.Error("expected both partitions at priority 0")
+	}
+	for _, field := range []int{3, 2} {
+		expectTaskEquals(t, affine.Next().Task(), testTask{field})
+	}
+	expectNilTask(t, affine.Next())
+
+	// partition sizes are reported by key, summed across priorities
+	sizes := NewPartitionedScheduler(shiftingPartitioner)
+	if n := len(sizes.PartitionSizes()); n != 0 {
+		t.Errorf("expected no partitions, received %d", n)
+	}
+	sizes.Put(testTask{1}, testTask{3}, testTask{11}, testTask{2}, testTask{4}, testTask{6}, testTask{22})
+	expectTaskEquals(t, sizes.Remove(testTask{4}.Id()), testTask{4})
+	actual := sizes.PartitionSizes()
+	if len(actual) != 2 || actual["key_1"] != 3 || actual["key_0"] != 3 {
+		t.Errorf("expected 3 tasks in each of key_0 and key_1, received %v", actual)
+	}
+	drainedSizes := sizes.Drain()
+	if len(drainedSizes) != 6 || len(sizes.PartitionSizes()) != 0 {
+		t.Errorf("expected no partitions after draining, received %v", sizes.PartitionSizes())
+	}
+
+	// test common partition cap
+	testCommonDupTask(t, NewPartitionedSchedulerWithPartitionCap(noPriPartitioner, 10))
+	testCommonSize(t, NewPartitionedSchedulerWithPartitionCap(noPriPartitioner, 10))
+	testCommonContains(t, NewPartitionedSchedulerWithPartitionCap(noPriPartitioner, 10))
+	testCommonRemove(t, NewPartitionedSchedulerWithPartitionCap(noPriPartitioner, 10))
+	testCommonClear(t, NewPartitionedSchedulerWithPartitionCap(noPriPartitioner, 10))
+	testCommonNextN(t, NewPartitionedSchedulerWithPartitionCap(noPriPartitioner, 10))
+	testCommonEach(t, NewPartitionedSchedulerWithPartitionCap(noPriPartitioner, 10))
+	testCommonDrain(t, NewPartitionedSchedulerWithPartitionCap(noPriPartitioner, 10), NewPartitionedSchedulerWithPartitionCap(noPriPartitioner, 10))
+	testCommonStats(t, NewPartitionedSchedulerWithPartitionCap(noPriPartitioner, 10))
+
+	// a noisy key overflows its cap without affecting the others
+	capped := NewPartitionedSchedulerWithPartitionCap(noPriPartitioner, 2)
+	capped.Put(testTask{2}, testTask{4}, testTask{6}, testTask{1}, testTask{8}, testTask{3})
+	expectSizeEquals(t, capped, 4)
+	expectContains(t, capped, testTask{6}, false)
+	expectContains(t, capped, testTask{8}, false)
+	expectContains(t, capped, testTask{3}, true)
+	if dropped := capped.Dropped(); len(dropped) != 1 || dropped["even"] != 2 {
+		t.Errorf("expected 2 dropped even tasks, received %v", dropped)
+	}
+	if stats := capped.Stats(); stats.Puts != 4 {
+		t.Errorf("expected 4 puts, received %d", stats.Puts)
+	}
+
+	// room frees up as the partition is served
+	expectTaskEquals(t, capped.Next().Task(), testTask{2})
+	capped.Put(testTask{6})
+	expectContains(t, capped, testTask{6}, true)
+	capped.Drain()
+	if dropped := capped.Dropped(); dropped["even"] != 2 {
+		t.Errorf("expected dropped tasks counted after draining, received %v", dropped)
+	}
+	capped.Clear()
+	if dropped := capped.Dropped(); len(dropped) != 0 {
+		t.Errorf("expected dropped tasks forgotten after clearing, received %v", dropped)
+	}
+
+	// removing mid-cycle keeps the round robin on the partition that would have been served next
+	var remPartitioner Partitioner = func(t Task) (string, uint, SchedulerFactory) {
+		return fmt.Sprintf("rem_%d", t.(testTask).field%3), 1, schedulerFactory
+	}
+	cycling := NewPartitionedScheduler(remPartitioner)
+	cycling.Put(testTask{0}, testTask{1}, testTask{2}, testTask{3}, testTask{4}, testTask{5})
+	expectTaskEquals(t, cycling.Next().Task(), testTask{0})
+	expectTaskEquals(t, cycling.Remove(testTask{1}.Id()), testTask{1})
+	for _, field := range []int{4, 2, 3, 5} {
+		expectTaskEquals(t, cycling.Next().Task(), testTask{field})
+	}
+	expectNilTask(t, cycling.Next())
+
+	// emptying the partition due next moves on to the one after it
+	cycling.Put(testTask{0}, testTask{1}, testTask{2}, testTask{3}, testTask{5})
+	expectTaskEquals(t, cycling.Next().Task(), testTask{0})
+	expectTaskEquals(t, cycling.Remove(testTask{1}.Id()), testTask{1})
+	for _, field := range []int{2, 3, 5} {
+		expectTaskEquals(t, cycling.Next().Task(), testTask{field})
+	}
+	expectNilTask(t, cycling.Next())
+
+	// emptying a partition already served this cycle doesn't skip the others
+	cycling.Put(testTask{0}, testTask{1}, testTask{2}, testTask{3}, testTask{4}, testTask{5}, testTask{8})
+	expectTaskEquals(t, cycling.Next().Task(), testTask{0})
+	expectTaskEquals(t, cycling.Next().Task(), testTask{1})
+	expectTaskEquals(t, cycling.Remove(testTask{3}.Id()), testTask{3})
+	for _, field := range []int{2, 4, 5, 8} {
+		expectTaskEquals(t, cycling.Next().Task(), testTask{field})
+	}
+	expectNilTask(t, cycling.Next())
+
+	// a partition starved by higher priority partitions waits longer with each task returned
+	starving := NewPartitionedScheduler(priPartitioner)
+	starving.Put(testTask{2}, testTask{3}, testTask{6}, testTask{9})
+	if report := starving.StarvationReport(); len(report) != 2 || report["rem_0"] != 0 || report["rem_2"] != 0 {
+		t.Errorf("expected no starvation before any task is returned, received %v", report)
+	}
+	for i := 1; i <= 3; i++ {
+		expectTaskEquals(t, starving.Next().Task(), testTask{3 * i})
+		starving.Put(testTask{3 * (i + 3)})
+		if report := starving.StarvationReport(); report["rem_2"] != i || report["rem_0"] != 0 {
+			t.Errorf("expected rem_2 starved for %d tasks, received %v", i, report)
+		}
+	}
+
+	// serving the starved partition resets its count
+	starving.Remove(testTask{9}.Id())
+	starving.Remove(testTask{12}.Id())
+	starving.Remove(testTask{15}.Id())
+	starving.Remove(testTask{18}.Id())
+	expectTaskEquals(t, starving.Next().Task(), testTask{2})
+	if report := starving.StarvationReport(); len(report) != 0 {
+		t.Errorf("expected no partitions left to report, received %v", report)
+	}
+	starving.Put(testTask{5}, testTask{8}, testTask{21})
+	expectTaskEquals(t, starving.Next().Task(), testTask{21})
+	if report := starving.StarvationReport(); report["rem_2"] != 1 {
+		t.Errorf("expected rem_2 starved for 1 task, received %v", report)
+	}
+	expectTaskEquals(t, starving.Next().Task(), testTask{5})
+	if report := starving.StarvationReport(); report["rem_2"] != 0 {
+		t.Errorf("expected rem_2 served, received %v", report)
+	}
+
+	// a level that empties and refills serves the first refilled partition first,
+	// wherever the round robin position was when it emptied
+	threeWays := func(t Task) (string, uint, SchedulerFactory) {
+		return strconv.Itoa(t.(testTask).field % 3), 1, schedulerFactory
+	}
+	refilled := NewPartitionedScheduler(threeWays)
+	refilled.Put(testTask{0}, testTask{1}, testTask{2}, testTask{3})
+	for _, field := range []int{0, 1} {
+		expectTaskEquals(t, refilled.Next().Task(), testTask{field})
+	}
+	expectTaskEquals(t, refilled.Remove(testTask{2}.Id()), testTask{2})
+	expectTaskEquals(t, refilled.Next().Task(), testTask{3})
+	expectNilTask(t, refilled.Next())
+	refilled.Put(testTask{4}, testTask{6}, testTask{7})
+	for _, field := range []int{4, 6, 7} {
+		expectTaskEquals(t, refilled.Next().Task(), testTask{field})
+	}
+}
+
+func TestPartitionedSchedulerSoftPriority(t *testing.T) {
+	// tasks from 100 are high priority, partitioned by parity, and the rest low priority
+	partitioner := func(t Task) (string, uint, SchedulerFactory) {
+		field := t.(testTask).field
+		if field >= 100 {
+			return "high_" + strconv.Itoa(field%2), 2, func() Scheduler { return NewFifoScheduler() }
+		}
+		return "low", 1, func() Scheduler { return NewFifoScheduler() }
+	}
+
+	// common
+	testCommonDupTask(t, NewPartitionedSchedulerWithSoftPriority(partitioner))
+	testCommonSize(t, NewPartitionedSchedulerWithSoftPriority(partitioner))
+	testCommonContains(t, NewPartitionedSchedulerWithSoftPriority(partitioner))
+	testCommonRemove(t, NewPartitionedSchedulerWithSoftPriority(partitioner))
+	testCommonClear(t, NewPartitionedSchedulerWithSoftPriority(partitioner))
+	testCommonNextN(t, NewPartitionedSchedulerWithSoftPriority(partitioner))
+	testCommonEach(t, NewPartitionedSchedulerWithSoftPriority(partitioner))
+	testCommonDrain(t, NewPartitionedSchedulerWithSoftPriority(partitioner), NewPartitionedSchedulerWithSoftPriority(partitioner))
+	testCommonStats(t, NewPartitionedSchedulerWithSoftPriority(partitioner))
+
+	// the high priority gets two turns for each turn of the low priority, round
+	// robinning over its partitions, until it runs out of tasks
+	scheduler := NewPartitionedSchedulerWithSoftPriority(partitioner)
+	for i := 0; i < 7; i++ {
+		scheduler.Put(testTask{100 + i})
+	}
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3}, testTask{4}, testTask{5})
+	expected := []int{100, 101, 1, 102, 103, 2, 104, 105, 3, 106, 4, 5}
+	peeked := scheduler.PeekN(len(expected))
+	for i, field := range expected {
+		expectTaskEquals(t, peeked[i], testTask{field})
+		expectTaskEquals(t, scheduler.Next().Task(), testTask{field})
+	}
+	expectNilTask(t, scheduler.Next())
+
+	// a priority that empties mid turn is refilled at the start of its next turns
+	scheduler.Put(testTask{1}, testTask{100})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{100})
+	scheduler.Put(testTask{101}, testTask{103}, testTask{2})
+	for _, field := range []int{101, 1, 103, 2} {
+		expectTaskEquals(t, scheduler.Next().Task(), testTask{field})
+	}
+
+	// clearing starts the cycle again from the highest priority
+	scheduler.Put(testTask{1}, testTask{100}, testTask{102})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{100})
+	scheduler.Clear()
+	scheduler.Put(testTask{1}, testTask{100}, testTask{102})
+	for _, field := range []int{100, 102, 1} {
+		expectTaskEquals(t, scheduler.Next().Task(), testTask{field})
+	}
+}
+
+func TestPartitionedSchedulerMinService(t *testing.T) {
+	// tasks from 100 are high priority and the rest low priority
+	partitioner := func(t Task) (string, uint, SchedulerFactory) {
+		if t.(testTask).field >= 100 {
+			return "high", 2, func() Scheduler { return NewFifoScheduler() }
+		}
+		return "low", 1, func() Scheduler { return NewFifoScheduler() }
+	}
+
+	// common
+	testCommonDupTask(t, NewPartitionedSchedulerWithMinService(partitioner, 5))
+	testCommonSize(t, NewPartitionedSchedulerWithMinService(partitioner, 5))
+	testCommonContains(t, NewPartitionedSchedulerWithMinService(partitioner, 5))
+	testCommonRemove(t, NewPartitionedSchedulerWithMinService(partitioner, 5))
+	testCommonClear(t, NewPartitionedSchedulerWithMinService(partitioner, 5))
+	testCommonNextN(t, NewPartitionedSchedulerWithMinService(partitioner, 5))
+	testCommonEach(t, NewPartitionedSchedulerWithMinService(partitioner, 5))
+	testCommonDrain(t, NewPartitionedSchedulerWithMinService(partitioner, 5), NewPartitionedSchedulerWithMinService(partitioner, 5))
+	testCommonStats(t, NewPartitionedSchedulerWithMinService(partitioner, 5))
+
+	// under constant high priority load, the low priority is served once in every five
+	scheduler := NewPartitionedSchedulerWithMinService(partitioner, 5)
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3}, testTask{4})
+	high, low := 101, 1
+	for i := 1; i <= 20; i++ {
+		scheduler.Put(testTask{100 + i})
+		if i%5 == 0 {
+			expectTaskEquals(t, scheduler.Next().Task(), testTask{low})
+			low++
+		} else {
+			expectTaskEquals(t, scheduler.Next().Task(), testTask{high})
+			high++
+		}
+	}
+
+	// an overdue partition without tasks does not hold up the others
+	scheduler = NewPartitionedSchedulerWithMinService(partitioner, 2)
+	scheduler.Put(testTask{1}, testTask{100}, testTask{101}, testTask{102})
+	expected := []int{100, 1, 101, 102}
+	peeked := scheduler.PeekN(len(expected))
+	for i, field := range expected {
+		expectTaskEquals(t, peeked[i], testTask{field})
+		expectTaskEquals(t, scheduler.Next().Task(), testTask{field})
+	}
+	expectNilTask(t, scheduler.Next())
+}
+
+func TestPartitionedSchedulerPartitions(t *testing.T) {
+	// partitioned by remainder, with a priority of the remainder
+	partitioner := func(t Task) (string, uint, SchedulerFactory) {
+		rem := t.(testTask).field % 4
+		return "rem_" + strconv.Itoa(rem), uint(rem / 2), func() Scheduler { return NewFifoScheduler() }
+	}
+	scheduler := NewPartitionedScheduler(partitioner)
+	if views := scheduler.Partitions(); len(views) != 0 {
+		t.Errorf("expected no partitions, received %d", len(views))
+	}
+	scheduler.Put(testTask{1}, testTask{5}, testTask{0})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
+	scheduler.Put(testTask{2}, testTask{3}, testTask{7}, testTask{11})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{2})
+
+	type view struct {
+		key      string
+		priority uint
+		size     int
+	}
+	expectViews := func(expected ...view) {
+		views := scheduler.Partitions()
+		if len(views) != len(expected) {
+			t.Fatalf("expected %d partitions, received %d", len(expected), len(views))
+		}
+		for i, v := range views {
+			if actual := (view{v.Key(), v.Priority(), v.Size()}); actual != expected[i] {
+				t.Errorf("expected partition %d to be %+v, received %+v", i, expected[i], actual)
+			}
+		}
+	}
+	// the emptied rem_2 is discarded, and rem_0 is next in round robin order at priority 0
+	expectViews(view{"rem_3", 1, 3}, view{"rem_0", 0, 1}, view{"rem_1", 0, 1})
+
+	// views are snapshots that don't disturb the scheduler
+	views := scheduler.Partitions()
+	scheduler.Put(testTask{6})
+	if views[0].Size() != 3 {
+		t.Errorf("expected the snapshot unchanged, received a size of %d", views[0].Size())
+	}
+	expectViews(view{"rem_3", 1, 3}, view{"rem_2", 1, 1}, view{"rem_0", 0, 1}, view{"rem_1", 0, 1})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{3})
+}
+
+// containsByScan reports whether any partition of the scheduler caches the id, as
+// ContainsId did before the scheduler kept an index.
+func containsByScan(p *PartitionedScheduler, id string) bool {
+	for _, pi := range p.prioritizedPartitions {
+		for _, part := range pi.partitions {
+			if _, ok := part.cache[id]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestPartitionedSchedulerIndex(t *testing.T) {
+	partitioner := func(t Task) (string, uint, SchedulerFactory) {
+		field := t.(testTask).field
+		return strconv.Itoa(field % 5), uint(field % 3), func() Scheduler { return NewFifoScheduler() }
+	}
+	for _, scheduler := range []*PartitionedScheduler{
+		NewPartitionedScheduler(partitioner),
+		NewPartitionedSchedulerWithAffinity(partitioner),
+	} {
+		for i := 0; i < 500; i++ {
+			switch field := (i * 7) % 40; i % 4 {
+			case 0, 1:
+				scheduler.Put(testTask{field})
+			case 2:
+				scheduler.Remove(testTask{field}.Id())
+			case 3:
+				scheduler.Next()
+			}
+			if i%97 == 0 {
+				scheduler.Each(func(Task) bool { return true })
+			}
+			for field := 0; field < 40; field++ {
+				id := testTask{field}.Id()
+				if scheduler.ContainsId(id) != containsByScan(scheduler, id) {
+					t.Fatalf("round %d: index and partitions disagree on whether task %d is scheduled", i, field)
+				}
+			}
+			if len(scheduler.index) != scheduler.Size() {
+				t.Fatalf("round %d: expected an index of %d tasks, received %d", i, scheduler.Size(), len(scheduler.index))
+			}
+		}
+		scheduler.Drain()
+		if len(scheduler.index) != 0 {
+			t.Errorf("expected an empty index after Drain, received %d tasks", len(scheduler.index))
+		}
+	}
+}
+
+func BenchmarkPartitionedSchedulerContains(b *testing.B) {
+	for _, partitions := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("partitions=%d", partitions), func(b *testing.B) {
+			scheduler := NewPartitionedScheduler(func(t Task) (string, uint, SchedulerFactory) {
+				return t.Id(), 1, func() Scheduler { return NewFifoScheduler() }
+			})
+			for i := 0; i < partitions; i++ {
+				scheduler.Put(testTask{i})
+			}
+			missing := testTask{-1}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				scheduler.Contains(missing)
+			}
+		})
+	}
+}
+
+func TestResourceManagedScheduler(t *testing.T) {
+	var calc ResourceCalculator = func(t Task) Resource {
+		return &resourceVector{resources: []int{1}}
+	}
+	testCommonDupTask(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc))
+	testCommonSize(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc))
+	testCommonContains(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc))
+	testCommonRemove(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc))
+	testCommonClear(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc))
+	testCommonNextN(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc))
+	testCommonEach(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc))
+	testCommonDrain(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc), NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc))
+	testCommonStats(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc))
+
+	// Next() returns nil if no resources exist to schedule the task
+	scheduler := NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc)
+	scheduler.Put(testTask{1})
+	scheduler.Put(testTask{2})
+	scheduler.Put(testTask{3})
+	nextOne := scheduler.Next()
+	nextTwo := scheduler.Next()
+	expectTaskEquals(t, nextOne.Task(), testTask{1})
+	expectTaskEquals(t, nextTwo.Task(), testTask{2})
+	nextThree := scheduler.Next()
+	expectNilTask(t, nextThree)
+	expectSizeEquals(t, scheduler, 1)
+	nextOne.Close()
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{3})
+
+	// NextN() returns as many tasks as resources allow
+	scheduler = NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc)
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3}, testTask{4})
+	batch := scheduler.NextN(3)
+	if len(batch) != 2 {
+		t.Fatalf("expected 2 tasks, received %d", len(batch))
+	}
+	expectTaskEquals(t, batch[0].Task(), testTask{1})
+	expectTaskEquals(t, batch[1].Task(), testTask{2})
+	if len(scheduler.NextN(3)) != 0 {
+		t.Error("expected no tasks without resources")
+	}
+	batch[0].Close()
+	if batch = scheduler.NextN(3); len(batch) != 1 {
+		t.Errorf("expected 1 task, received %d", len(batch))
+	}
+	expectTaskEquals(t, batch[0].Task(), testTask{3})
+
+	// checks if the waiting element has a task
+	scheduler = NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc)
+	expectContains(t, scheduler, testTask{1}, false)
+	scheduler.waiting = []ScheduledTask{&defaultScheduledTask{t: testTask{1}}}
+	expectContains(t, scheduler, testTask{1}, true)
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
+	expectContains(t, scheduler, testTask{1}, false)
+
+	// clearing drops the waiting task but leaves outstanding resources to be returned on Close()
+	pool := NewResourceVectorPool([]int{1})
+	scheduler = NewResourceManagedScheduler(NewFifoScheduler(), pool, calc)
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3})
+	running := scheduler.Next()
+	expectNilTask(t, scheduler.Next())
+	scheduler.Clear()
+	expectSizeEquals(t, scheduler, 0)
+	expectContains(t, scheduler, testTask{2}, false)
+	running.Close()
+	if pool.resources[0] != 1 {
+		t.Errorf("expected pool replenished to 1, received %d", pool.resources[0])
+	}
+	scheduler.Put(testTask{4})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{4})
+
+	// closing a scheduled task closes the task emitted by the underlying scheduler
+	underlying := &closeTrackingScheduler{NewFifoScheduler(), map[string]int{}}
+	scheduler = NewResourceManagedScheduler(underlying, NewResourceVectorPool([]int{1}), calc)
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3})
+	first := scheduler.Next()
+	expectNilTask(t, scheduler.Next())
+	if underlying.closed["1"] != 0 {
+		t.Error("expected task not closed before Close()")
+	}
+	first.Close()
+	if underlying.closed["1"] != 1 {
+		t.Errorf("expected task closed once, received %d", underlying.closed["1"])
+	}
+	second := scheduler.Next()
+	expectTaskEquals(t, second.Task(), testTask{2})
+
+	// removing the waiting task no longer holds it, but does not close it as it never ran
+	expectNilTask(t, scheduler.Next())
+	expectTaskEquals(t, scheduler.Remove(testTask{3}.Id()), testTask{3})
+	second.Close()
+	if underlying.closed["2"] != 1 {
+		t.Errorf("expected waiting task closed once, received %d", underlying.closed["2"])
+	}
+	if underlying.closed["3"] != 0 {
+		t.Errorf("expected removed waiting task not closed, received %d closes", underlying.closed["3"])
+	}
+	expectContains(t, scheduler, testTask{3}, false)
+	expectSizeEquals(t, scheduler, 0)
+
+	// so the dependents of a removed, drained or cleared waiting task stay blocked
+	for _, drop := range []func(*ResourceManagedScheduler){
+		func(r *ResourceManagedScheduler) { r.Remove(testTask{2}.Id()) },
+		func(r *ResourceManagedScheduler) { r.Drain() },
+		func(r *ResourceManagedScheduler) { r.Clear() },
+	} {
+		dependencies := map[string][]string{testTask{4}.Id(): {testTask{2}.Id()}}
+		scheduler = NewResourceManagedScheduler(NewDependencyScheduler(NewFifoScheduler(), dependencies), NewResourceVectorPool([]int{1}), calc)
+		scheduler.Put(testTask{1}, testTask{2})
+		running = scheduler.Next()
+		expectNilTask(t, scheduler.Next())
+		drop(scheduler)
+		scheduler.Put(testTask{4})
+		running.Close()
+		expectNilTask(t, scheduler.Next())
+	}
+
+	// draining includes the waiting task without requesting or leaking resources
+	underlying = &closeTrackingScheduler{NewFifoScheduler(), map[string]int{}}
+	pool = NewResourceVectorPool([]int{1})
+	scheduler = NewResourceManagedScheduler(underlying, pool, calc)
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3})
+	running = scheduler.Next()
+	expectNilTask(t, scheduler.Next())
+	drained := scheduler.Drain()
+	if len(drained) != 2 {
+		t.Fatalf("expected 2 drained tasks, received %d", len(drained))
+	}
+	expectTaskEquals(t, drained[0], testTask{2})
+	expectTaskEquals(t, drained[1], testTask{3})
+	if underlying.closed["2"] != 0 {
+		t.Errorf("expected drained waiting task not closed, received %d closes", underlying.closed["2"])
+	}
+	if pool.resources[0] != 0 {
+		t.Errorf("expected pool unchanged at 0, received %d", pool.resources[0])
+	}
+	running.Close()
+	if pool.resources[0] != 1 {
+		t.Errorf("expected pool replenished to 1, received %d", pool.resources[0])
+	}
+
+	// partitions managing their own resources are drained without them
+	exhausted := NewResourceVectorPool([]int{1})
+	exhausted.Request(NewResourceVectorRequest([]int{1}))
+	partitioned := NewPartitionedScheduler(func(t Task) (string, uint, SchedulerFactory) {
+		return fmt.Sprint(t.(testTask).field % 2), 1, func() Scheduler {
+			return NewResourceManagedScheduler(NewFifoScheduler(), exhausted, calc)
+		}
+	})
+	partitioned.Put(testTask{1}, testTask{2}, testTask{3})
+	expectNilTask(t, partitioned.Next())
+	drained = partitioned.Drain()
+	if len(drained) != 3 {
+		t.Fatalf("expected 3 drained tasks, received %d", len(drained))
+	}
+	expectTaskEquals(t, drained[0], testTask{1})
+	expectTaskEquals(t, drained[1], testTask{2})
+	expectTaskEquals(t, drained[2], testTask{3})
+	expectSizeEquals(t, partitioned, 0)
+}
+
+// countingResource counts the calls to Return of the resource it wraps.
+type countingResource struct {
+	Resource
+	returns int
+}
+
+func (c *countingResource) Return() bool {
+	c.returns++
+	return c.Resource.Return()
+}
+
+func TestResourceManagedSchedulerInfinitePool(t *testing.T) {
+	calc := func(_ Task) Resource { return NewResourceVectorRequest([]int{1}) }
+
+	// common
+	testCommonDupTask(t, NewResourceManagedScheduler(NewFifoScheduler(), NewInfinitePool(), calc))
+	testCommonSize(t, NewResourceManagedScheduler(NewFifoScheduler(), NewInfinitePool(), calc))
+	testCommonContains(t, NewResourceManagedScheduler(NewFifoScheduler(), NewInfinitePool(), calc))
+	testCommonRemove(t, NewResourceManagedScheduler(NewFifoScheduler(), NewInfinitePool(), calc))
+	testCommonClear(t, NewResourceManagedScheduler(NewFifoScheduler(), NewInfinitePool(), calc))
+	testCommonNextN(t, NewResourceManagedScheduler(NewFifoScheduler(), NewInfinitePool(), calc))
+	testCommonEach(t, NewResourceManagedScheduler(NewFifoScheduler(), NewInfinitePool(), calc))
+	testCommonDrain(t, NewResourceManagedScheduler(NewFifoScheduler(), NewInfinitePool(), calc), NewResourceManagedScheduler(NewFifoScheduler(), NewInfinitePool(), calc))
+	testCommonStats(t, NewResourceManagedScheduler(NewFifoScheduler(), NewInfinitePool(), calc))
+
+	// tasks come out in the order of the underlying scheduler without ever waiting
+	priority := func(t Task) int { return t.(testTask).field % 4 }
+	underlying := NewPriorityScheduler(priority)
+	scheduler := NewResourceManagedScheduler(NewPriorityScheduler(priority), NewInfinitePool(), calc)
+	for i := 0; i < 20; i++ {
+		underlying.Put(testTask{i})
+		scheduler.Put(testTask{i})
+	}
+	for next := underlying.Next(); next != nil; next = underlying.Next() {
+		expectTaskEquals(t, scheduler.Next().Task(), next.Task())
+	}
+	expectNilTask(t, scheduler.Next())
+	if scheduler.Waiting() != 0 {
+		t.Errorf("expected no waiting tasks, received %d", scheduler.Waiting())
+	}
+
+	// returning a granted resource succeeds once
+	granted := NewInfinitePool().Request(nil)
+	if !granted.Return() || granted.Return() {
+		t.Error("expected resource returned once")
+	}
+}
+
+func TestResourceTaskCloseTwice(t *testing.T) {
+	pool := NewResourceVectorPool([]int{2})
+	granted := &countingResource{pool.Request(NewResourceVectorRequest([]int{1})), 0}
+	task := newResourceTask(&defaultScheduledTask{t: testTask{1}}, granted)
+	task.Close()
+	if pool.resources[0] != 2 {
+		t.Errorf("expected pool replenished to 2, received %d", pool.resources[0])
+	}
+	task.Close()
+	if granted.returns != 1 {
+		t.Errorf("expected resource returned once, received %d", granted.returns)
+	}
+	if pool.resources[0] != 2 {
+		t.Errorf("expected pool balance unchanged by second close, received %d", pool.resources[0])
+	}
+}
+
+func TestScheduledTaskOnComplete(t *testing.T) {
+	expectCompletions := func(st ScheduledTask, f func(), expected int, completions *int) {
+		f()
+		if *completions != expected {
+			t.Errorf("expected task %s completed %d times, received %d", st.Id(), expected, *completions)
+		}
+	}
+	calc := func(_ Task) Resource { return NewResourceVectorRequest([]int{1}) }
+	pool := NewResourceVectorPool([]int{1})
+	schedulers := []Scheduler{
+		NewFifoScheduler(),
+		NewResourceManagedScheduler(NewFifoScheduler(), pool, calc),
+		NewQuotaScheduler(NewResourceManagedScheduler(NewFifoScheduler(), pool, calc), func(Task) string { return "" }, 1),
+	}
+	for _, scheduler := range schedulers {
+		scheduler.Put(testTask{1})
+		st := scheduler.Next()
+		completions := 0
+		st.(CompletionNotifier).OnComplete(func() { completions++ })
+
+		// callbacks run on the first close only
+		expectCompletions(st, func() {}, 0, &completions)
+		expectCompletions(st, st.Close, 1, &completions)
+		expectCompletions(st, st.Close, 1, &completions)
+
+		// callbacks registered after closing run immediately
+		expectCompletions(st, func() { st.(CompletionNotifier).OnComplete(func() { completions++ }) }, 2, &completions)
+	}
+
+	// the resource is back in the pool by the time callbacks run
+	scheduler := NewResourceManagedScheduler(NewFifoScheduler(), pool, calc)
+	scheduler.Put(testTask{1})
+	st := scheduler.Next()
+	st.(CompletionNotifier).OnComplete(func() {
+		if pool.resources[0] != 1 {
+			t.Errorf("expected resource returned before completion, received %d available", pool.resources[0])
+		}
+	})
+	st.Close()
+}
+
+func TestResourceManagedSchedulerRemoveScheduled(t *testing.T) {
+	calc := func(_ Task) Resource { return NewResourceVectorRequest([]int{1}) }
+	underlying := &closeTrackingScheduler{NewFifoScheduler(), map[string]int{}}
+	pool := NewResourceVectorPool([]int{1})
+	scheduler := NewResourceManagedScheduler(underlying, pool, calc)
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3})
+	scheduler.Next()
+	expectNilTask(t, scheduler.Next())
+
+	// removing an outstanding task and closing it replenishes the pool
+	removed := scheduler.RemoveScheduled(testTask{1}.Id())
+	expectNotNilTask(t, removed)
+	expectTaskEquals(t, removed.Task(), testTask{1})
+	if pool.resources[0] != 0 {
+		t.Errorf("expected pool unchanged until close, received %d", pool.resources[0])
+	}
+	if scheduler.Cancel(testTask{1}.Id()) {
+		t.Error("expected removed task no longer running")
+	}
+	removed.Close()
+	if pool.resources[0] != 1 {
+		t.Errorf("expected pool replenished to 1, received %d", pool.resources[0])
+	}
+	if underlying.closed["1"] != 1 {
+		t.Errorf("expected task closed once, received %d", underlying.closed["1"])
+	}
+
+	// tasks waiting on resources are returned unclosed
+	removed = scheduler.RemoveScheduled(testTask{2}.Id())
+	expectTaskEquals(t, removed.Task(), testTask{2})
+	if underlying.closed["2"] != 0 {
+		t.Errorf("expected waiting task not closed, received %d", underlying.closed["2"])
+	}
+	removed.Close()
+
+	// as are queued tasks
+	expectTaskEquals(t, scheduler.RemoveScheduled(testTask{3}.Id()).Task(), testTask{3})
+	expectSizeEquals(t, scheduler, 0)
+	expectNilTask(t, scheduler.RemoveScheduled(testTask{3}.Id()))
+	if stats := scheduler.Stats(); stats.Removes != 2 {
+		t.Errorf("expected 2 removes, received %d", stats.Removes)
+	}
+}
+
+func TestResourceManagedSchedulerRequeue(t *testing.T) {
+	calc := func(_ Task) Resource { return NewResourceVectorRequest([]int{1}) }
+	underlying := &closeTrackingScheduler{NewFifoScheduler(), map[string]int{}}
+	pool := NewResourceVectorPool([]int{1})
+	var scheduler Requeuer = NewResourceManagedScheduler(underlying, pool, calc)
+	rms := scheduler.(*ResourceManagedScheduler)
+	rms.Put(testTask{1}, testTask{2})
+
+	// a task whose resource was already returned reappears in Next
+	first := rms.Next()
+	rms.Cancel(testTask{1}.Id())
+	completed := false
+	first.(CompletionNotifier).OnComplete(func() { completed = true })
+	scheduler.Requeue(first)
+	if pool.resources[0] != 1 {
+		t.Errorf("expected the resource returned once, received %d available", pool.resources[0])
+	}
+	if underlying.closed["1"] != 0 {
+		t.Errorf("expected the wrapped task not closed, received %d closes", underlying.closed["1"])
+	}
+	if completed {
+		t.Error("expected a requeued task not to complete")
+	}
+	expectSizeEquals(t, rms, 2)
+	expectTaskEquals(t, rms.Next().Task(), testTask{2})
+
+	// requeueing a running task returns its resource, letting the waiting task run
+	expectNilTask(t, rms.Next())
+	rms.Requeue(rms.running[testTask{2}.Id()])
+	if pool.resources[0] != 1 {
+		t.Errorf("expected the resource returned, received %d available", pool.resources[0])
+	}
+	if rms.Cancel(testTask{2}.Id()) {
+		t.Error("expected a requeued task no longer running")
+	}
+	for _, field := range []int{1, 2} {
+		next := rms.Next()
+		expectTaskEquals(t, next.Task(), testTask{field})
+		next.Close()
+	}
+	if stats := rms.Stats(); stats.Puts != 4 || stats.Nexts != 4 {
+		t.Errorf("expected 4 puts and 4 nexts, received %+v", stats)
+	}
+
+	// a requeued task does not complete in the underlying scheduler, so its
+	// dependents stay blocked until it runs again and is closed
+	dependencies := map[string][]string{testTask{2}.Id(): {testTask{1}.Id()}}
+	rms = NewResourceManagedScheduler(NewDependencyScheduler(NewFifoScheduler(), dependencies), NewResourceVectorPool([]int{2}), calc)
+	rms.Put(testTask{1}, testTask{2})
+	rms.Requeue(rms.Next())
+	requeued := rms.Next()
+	expectTaskEquals(t, requeued.Task(), testTask{1})
+	expectNilTask(t, rms.Next())
+	requeued.Close()
+	expectTaskEquals(t, rms.Next().Task(), testTask{2})
+}
+
+func TestResourceManagedSchedulerCancel(t *testing.T) {
+	calc := func(_ Task) Resource { return NewResourceVectorRequest([]int{1}) }
+	underlying := &closeTrackingScheduler{NewFifoScheduler(), map[string]int{}}
+	pool := NewResourceVectorPool([]int{1})
+	scheduler := NewResourceManagedScheduler(underlying, pool, calc)
+	scheduler.Put(testTask{1}, testTask{2})
+	running := scheduler.Next()
+	expectNilTask(t, scheduler.Next())
+
+	// only running tasks can be cancelled
+	if scheduler.Cancel(testTask{2}.Id()) {
+		t.Error("expected waiting task not cancelled")
+	}
+
+	// cancelling replenishes the pool before the task is closed
+	if !scheduler.Cancel(testTask{1}.Id()) {
+		t.Error("expected running task cancelled")
+	}
+	if pool.resources[0] != 1 {
+		t.Errorf("expected pool replenished to 1, received %d", pool.resources[0])
+	}
+	if !running.(*resourceTask).Cancelled() {
+		t.Error("expected task marked cancelled")
+	}
+	if scheduler.Cancel(testTask{1}.Id()) {
+		t.Error("expected task cancelled once")
+	}
+	next := scheduler.Next()
+	expectTaskEquals(t, next.Task(), testTask{2})
+
+	// closing the cancelled task returns nothing more but still closes the task it wraps
+	running.Close()
+	if pool.resources[0] != 0 {
+		t.Errorf("expected pool unchanged by close, received %d", pool.resources[0])
+	}
+	if underlying.closed["1"] != 1 {
+		t.Errorf("expected task closed once, received %d", underlying.closed["1"])
+	}
+
+	// closed tasks are no longer running
+	next.Close()
+	if scheduler.Cancel(testTask{2}.Id()) {
+		t.Error("expected closed task not cancelled")
+	}
+	if pool.resources[0] != 1 {
+		t.Errorf("expected pool replenished to 1, received %d", pool.resources[0])
+	}
+}
+
+func TestAdaptiveResourceManagedScheduler(t *testing.T) {
+	// tasks want 2 units but make do with 1 when fewer than 2 are available
+	calc := func(_ Task, available []int) Resource {
+		if available[0] < 2 {
+			return NewResourceVectorRequest([]int{1})
+		}
+		return NewResourceVectorRequest([]int{2})
+	}
+	fixed := func(_ Task) Resource { return NewResourceVectorRequest([]int{2}) }
+
+	// common
+	testCommonDupTask(t, NewAdaptiveResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{10}), calc))
+	testCommonSize(t, NewAdaptiveResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{10}), calc))
+	testCommonContains(t, NewAdaptiveResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{10}), calc))
+	testCommonRemove(t, NewAdaptiveResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{10}), calc))
+	testCommonClear(t, NewAdaptiveResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{10}), calc))
+	testCommonNextN(t, NewAdaptiveResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{10}), calc))
+	testCommonEach(t, NewAdaptiveResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{10}), calc))
+	testCommonDrain(t, NewAdaptiveResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{10}), calc), NewAdaptiveResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{10}), calc))
+	testCommonStats(t, NewAdaptiveResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{10}), calc))
+
+	// with a fixed request the second task waits on the first
+	scheduler := NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{3}), fixed)
+	scheduler.Put(testTask{1}, testTask{2})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
+	expectNilTask(t, scheduler.Next())
+
+	// adapting to the single unit left schedules it
+	pool := NewResourceVectorPool([]int{3})
+	scheduler = NewAdaptiveResourceManagedScheduler(NewFifoScheduler(), pool, calc)
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3})
+	first := scheduler.Next()
+	expectTaskEquals(t, first.Task(), testTask{1})
+	second := scheduler.Next()
+	expectTaskEquals(t, second.Task(), testTask{2})
+	expectNilTask(t, scheduler.Next())
+	if pool.resources[0] != 0 {
+		t.Errorf("expected pool exhausted, received %d available", pool.resources[0])
+	}
+
+	// a waiting task's request is recalculated as resources free up
+	first.Close()
+	third := scheduler.Next()
+	expectTaskEquals(t, third.Task(), testTask{3})
+	if pool.resources[0] != 0 {
+		t.Errorf("expected third task granted 2 units, received %d available", pool.resources[0])
+	}
+	second.Close()
+	third.Close()
+	if pool.resources[0] != 3 {
+		t.Errorf("expected all resources returned, received %d available", pool.resources[0])
+	}
+}
+
+func TestMultiPoolResourceManagedScheduler(t *testing.T) {
+	unit := func(_ Task) Resource { return NewResourceVectorRequest([]int{1}) }
+	newScheduler := func(cpu, conns ResourcePool) *ResourceManagedScheduler {
+		return NewMultiPoolResourceManagedScheduler(NewFifoScheduler(), []ResourcePool{cpu, conns}, []ResourceCalculator{unit, unit})
+	}
+
+	// common
+	testCommonDupTask(t, newScheduler(NewResourceVectorPool([]int{10}), NewResourceVectorPool([]int{10})))
+	testCommonSize(t, newScheduler(NewResourceVectorPool([]int{10}), NewResourceVectorPool([]int{10})))
+	testCommonContains(t, newScheduler(NewResourceVectorPool([]int{10}), NewResourceVectorPool([]int{10})))
+	testCommonRemove(t, newScheduler(NewResourceVectorPool([]int{10}), NewResourceVectorPool([]int{10})))
+	testCommonClear(t, newScheduler(NewResourceVectorPool([]int{10}), NewResourceVectorPool([]int{10})))
+	testCommonNextN(t, newScheduler(NewResourceVectorPool([]int{10}), NewResourceVectorPool([]int{10})))
+	testCommonEach(t, newScheduler(NewResourceVectorPool([]int{10}), NewResourceVectorPool([]int{10})))
+	testCommonDrain(t, newScheduler(NewResourceVectorPool([]int{10}), NewResourceVectorPool([]int{10})), newScheduler(NewResourceVectorPool([]int{10}), NewResourceVectorPool([]int{10})))
+	testCommonStats(t, newScheduler(NewResourceVectorPool([]int{10}), NewResourceVectorPool([]int{10})))
+
+	// a task needing a unit from each pool is blocked until both are available
+	cpu, conns := NewResourceVectorPool([]int{2}), NewResourceVectorPool([]int{1})
+	scheduler := newScheduler(cpu, conns)
+	scheduler.Put(testTask{1}, testTask{2})
+	first := scheduler.Next()
+	expectTaskEquals(t, first.Task(), testTask{1})
+	expectNilTask(t, scheduler.Next())
+
+	// the unit granted from the first pool is returned when the second can't grant one
+	if cpu.resources[0] != 1 || conns.resources[0] != 0 {
+		t.Errorf("expected [1] and [0] available, received %v and %v", cpu.resources, conns.resources)
+	}
+
+	// closing returns the resources of every pool
+	first.Close()
+	if cpu.resources[0] != 2 || conns.resources[0] != 1 {
+		t.Errorf("expected [2] and [1] available, received %v and %v", cpu.resources, conns.resources)
+	}
+	second := scheduler.Next()
+	expectTaskEquals(t, second.Task(), testTask{2})
+	second.Close()
+
+	// a nil request takes nothing from its pool
+	cpu, conns = NewResourceVectorPool([]int{1}), NewResourceVectorPool([]int{1})
+	scheduler = NewMultiPoolResourceManagedScheduler(NewFifoScheduler(), []ResourcePool{cpu, conns},
+		[]ResourceCalculator{unit, func(_ Task) Resource { return nil }})
+	scheduler.Put(testTask{1})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
+	if conns.resources[0] != 1 {
+		t.Errorf("expected [1] available, received %v", conns.resources)
+	}
+
+	// tasks requesting more than the capacity of any pool are rejected
+	scheduler = newScheduler(NewResourceVectorPool([]int{1}), NewResourceVectorPool([]int{0}))
+	scheduler.Put(testTask{1})
+	expectNilTask(t, scheduler.Next())
+	if rejected := scheduler.Rejected(); len(rejected) != 1 {
+		t.Errorf("expected 1 rejected task, received %d", len(rejected))
+	}
+}
+
+func TestMultiPoolResourceManagedSchedulerDeadlock(t *testing.T) {
+	unit := func(_ Task) Resource { return NewResourceVectorRequest([]int{1}) }
+	// x needs the cpu and then a connection, and y a connection and then the cpu. A
+	// connection held elsewhere while x starts is returned before y starts.
+	run := func(x, y Scheduler, conns ResourcePool) (completed int) {
+		x.Put(testTask{1}, testTask{2})
+		y.Put(testTask{3}, testTask{4})
+		held := conns.Request(unit(nil))
+		expectNilTask(t, x.Next())
+		held.Return()
+		if next := y.Next(); next != nil {
+			next.Close()
+			completed++
+		}
+		for round := 0; round < 10; round++ {
+			running := []ScheduledTask{}
+			for _, s := range []Scheduler{x, y} {
+				if next := s.Next(); next != nil {
+					running = append(running, next)
+				}
+			}
+			for _, st := range running {
+				st.Close()
+				completed++
+			}
+		}
+		return
+	}
+
+	// nesting schedulers acquires the pools one at a time, so x holds the cpu while
+	// waiting on a connection, and y then holds the connection while waiting on the
+	// cpu, starving both
+	cpu, conns := NewResourceVectorPool([]int{1}), NewResourceVectorPool([]int{1})
+	x := NewResourceManagedScheduler(NewResourceManagedScheduler(NewFifoScheduler(), cpu, unit), conns, unit)
+	y := NewResourceManagedScheduler(NewResourceManagedScheduler(NewFifoScheduler(), conns, unit), cpu, unit)
+	if completed := run(x, y, conns); completed != 0 {
+		t.Errorf("expected nested schedulers to deadlock, received %d completed", completed)
+	}
+
+	// acquiring both pools at once, in either order, never holds one while waiting
+	cpu, conns = NewResourceVectorPool([]int{1}), NewResourceVectorPool([]int{1})
+	x = NewMultiPoolResourceManagedScheduler(NewFifoScheduler(), []ResourcePool{cpu, conns}, []ResourceCalculator{unit, unit})
+	y = NewMultiPoolResourceManagedScheduler(NewFifoScheduler(), []ResourcePool{conns, cpu}, []ResourceCalculator{unit, unit})
+	if completed := run(x, y, conns); completed != 4 {
+		t.Errorf("expected all 4 tasks completed, received %d", completed)
+	}
+	if cpu.resources[0] != 1 || conns.resources[0] != 1 {
+		t.Errorf("expected [1] and [1] available, received %v and %v", cpu.resources, conns.resources)
+	}
+}
+
+func TestResourceManagedSchedulerRejected(t *testing.T) {
+	var calc ResourceCalculator = func(t Task) Resource {
+		return NewResourceVectorRequest([]int{t.(testTask).field})
+	}
+
+	// a task requesting more than the pool's capacity is rejected rather than waited on
+	scheduler := NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc)
+	scheduler.Put(testTask{5}, testTask{1})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
+	rejected := scheduler.Rejected()
+	if len(rejected) != 1 {
+		t.Fatalf("expected 1 rejected task, received %d", len(rejected))
+	}
+	expectTaskEquals(t, rejected[0], testTask{5})
+	expectContains(t, scheduler, testTask{5}, false)
+	expectSizeEquals(t, scheduler, 0)
+
+	// a rejected task never ran, so it does not complete in the underlying scheduler
+	dependencies := map[string][]string{testTask{1}.Id(): {testTask{5}.Id()}}
+	dependent := NewResourceManagedScheduler(NewDependencyScheduler(NewFifoScheduler(), dependencies), NewResourceVectorPool([]int{2}), calc)
+	dependent.Put(testTask{5}, testTask{1})
+	expectNilTask(t, dependent.Next())
+	expectSizeEquals(t, dependent, 1)
+
+	// tasks that fit the capacity wait for resources instead
+	scheduler.Put(testTask{2})
+	expectNilTask(t, scheduler.Next())
+	expectSizeEquals(t, scheduler, 1)
+	if len(scheduler.Rejected()) != 1 {
+		t.Errorf("expected 1 rejected task, received %d", len(scheduler.Rejected()))
+	}
+
+	scheduler.Clear()
+	if len(scheduler.Rejected()) != 0 {
+		t.Errorf("expected no rejected tasks, received %d", len(scheduler.Rejected()))
+	}
+
+	// pools without a known capacity never reject
+	mapCalc := func(t Task) Resource {
+		return NewResourceMapRequest(map[string]int{"cpu": t.(testTask).field})
+	}
+	scheduler = NewResourceManagedScheduler(NewFifoScheduler(), NewResourceMapPool(map[string]int{"cpu": 2}), mapCalc)
+	scheduler.Put(testTask{5})
+	expectNilTask(t, scheduler.Next())
+	expectSizeEquals(t, scheduler, 1)
+	if len(scheduler.Rejected()) != 0 {
+		t.Errorf("expected no rejected tasks, received %d", len(scheduler.Rejected()))
+	}
+}
+
+func TestResourceManagedSchedulerWithQueue(t *testing.T) {
+	var calc ResourceCalculator = func(t Task) Resource {
+		if t.(testTask).field%2 == 0 {
+			return &resourceVector{resources: []int{2}}
+		}
+		return &resourceVector{resources: []int{1}}
+	}
+	testCommonDupTask(t, NewResourceManagedSchedulerWithQueue(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc, 2))
+	testCommonSize(t, NewResourceManagedSchedulerWithQueue(NewFifoScheduler(), NewResourceVectorPool([]int{3}), calc, 2))
+	testCommonContains(t, NewResourceManagedSchedulerWithQueue(NewFifoScheduler(), NewResourceVectorPool([]int{3}), calc, 2))
+	testCommonRemove(t, NewResourceManagedSchedulerWithQueue(NewFifoScheduler(), NewResourceVectorPool([]int{4}), calc, 2))
+	testCommonClear(t, NewResourceManagedSchedulerWithQueue(NewFifoScheduler(), NewResourceVectorPool([]int{4}), calc, 2))
+	testCommonNextN(t, NewResourceManagedSchedulerWithQueue(NewFifoScheduler(), NewResourceVectorPool([]int{4}), calc, 2))
+	testCommonEach(t, NewResourceManagedSchedulerWithQueue(NewFifoScheduler(), NewResourceVectorPool([]int{4}), calc, 2))
+	testCommonDrain(t, NewResourceManagedSchedulerWithQueue(NewFifoScheduler(), NewResourceVectorPool([]int{4}), calc, 2), NewResourceManagedSchedulerWithQueue(NewFifoScheduler(), NewResourceVectorPool([]int{4}), calc, 2))
+	testCommonStats(t, NewResourceManagedSchedulerWithQueue(NewFifoScheduler(), NewResourceVectorPool([]int{4}), calc, 2))
+
+	// a large task blocked on resources doesn't block a smaller one behind it
+	scheduler := NewResourceManagedSchedulerWithQueue(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc, 2)
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3}, testTask{5})
+	first := scheduler.Next()
+	expectTaskEquals(t, first.Task(), testTask{1})
+	third := scheduler.Next()
+	expectTaskEquals(t, third.Task(), testTask{3})
+	if scheduler.Waiting() != 1 {
+		t.Errorf("expected 1 waiting task, received %d", scheduler.Waiting())
+	}
+	expectSizeEquals(t, scheduler, 2)
+
+	// the waiting queue is bounded, leaving the rest in the underlying scheduler
+	expectNilTask(t, scheduler.Next())
+	if scheduler.Waiting() != 2 {
+		t.Errorf("expected 2 waiting tasks, received %d", scheduler.Waiting())
+	}
+	expectNilTask(t, scheduler.Next())
+	expectSizeEquals(t, scheduler, 2)
+
+	// waiting tasks are granted in order as resources free up
+	first.Close()
+	third.Close()
+	second := scheduler.Next()
+	expectTaskEquals(t, second.Task(), testTask{2})
+	expectNilTask(t, scheduler.Next())
+	second.Close()
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{5})
+	if scheduler.Waiting() != 0 {
+		t.Errorf("expected no waiting tasks, received %d", scheduler.Waiting())
+	}
+}
+
+// closeTrackingScheduler is a FifoScheduler whose scheduled tasks count how
+// many times they are closed.
+type closeTrackingScheduler struct {
+	*FifoScheduler
+	closed map[string]int
+}
+
+type closeTrackingTask struct {
+	ScheduledTask
+	closed map[string]int
+}
+
+func (c *closeTrackingTask) Close() {
+	c.closed[c.Id()]++
+	c.ScheduledTask.Close()
+}
+
+func (c *closeTrackingScheduler) Next() ScheduledTask {
+	next := c.FifoScheduler.Next()
+	if next == nil {
+		return nil
+	}
+	return &closeTrackingTask{next, c.closed}
+}
+
+func (c *closeTrackingScheduler) NextN(n int) []ScheduledTask {
+	return nextN(c, n)
+}
+
+func TestDependencyScheduler(t *testing.T) {
+	// common
+	testCommonDupTask(t, NewDependencyScheduler(NewFifoScheduler(), nil))
+	testCommonSize(t, NewDependencyScheduler(NewFifoScheduler(), nil))
+	testCommonContains(t, NewDependencyScheduler(NewFifoScheduler(), nil))
+	testCommonRemove(t, NewDependencyScheduler(NewFifoScheduler(), nil))
+	testCommonClear(t, NewDependencyScheduler(NewFifoScheduler(), nil))
+	testCommonNextN(t, NewDependencyScheduler(NewFifoScheduler(), nil))
+	testCommonEach(t, NewDependencyScheduler(NewFifoScheduler(), nil))
+	testCommonDrain(t, NewDependencyScheduler(NewFifoScheduler(), nil), NewDependencyScheduler(NewFifoScheduler(), nil))
+	testCommonStats(t, NewDependencyScheduler(NewFifoScheduler(), nil))
+
+	// diamond: 2 and 3 depend on 1, 4 depends on both 2 and 3
+	diamond := map[string][]string{
+		"2": {"1"},
+		"3": {"1"},
+		"4": {"2", "3"},
+	}
+	scheduler := NewDependencyScheduler(NewFifoScheduler(), diamond)
+	scheduler.Put(testTask{4}, testTask{3}, testTask{2}, testTask{1})
+	expectSizeEquals(t, scheduler, 4)
+	expectContains(t, scheduler, testTask{4}, true)
+
+	first := scheduler.Next()
+	expectTaskEquals(t, first.Task(), testTask{1})
+	expectNilTask(t, scheduler.Next())
+	first.Close()
+
+	second := scheduler.Next()
+	third := scheduler.Next()
+	expectTaskEquals(t, second.Task(), testTask{3})
+	expectTaskEquals(t, third.Task(), testTask{2})
+	expectNilTask(t, scheduler.Next())
+	second.Close()
+	expectNilTask(t, scheduler.Next())
+	expectSizeEquals(t, scheduler, 1)
+	third.Close()
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{4})
+	expectSizeEquals(t, scheduler, 0)
+
+	// a task put after its prerequisites complete is not held back
+	scheduler = NewDependencyScheduler(NewFifoScheduler(), diamond)
+	scheduler.Put(testTask{1})
+	scheduler.Next().Close()
+	scheduler.Put(testTask{2})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{2})
+
+	// a forgotten prerequisite holds back tasks put later until it completes again
+	scheduler.Forget(testTask{1}.Id(), testTask{5}.Id())
+	if len(scheduler.completed) != 0 {
+		t.Errorf("expected no completed ids remembered, received %d", len(scheduler.completed))
+	}
+	scheduler.Put(testTask{3})
+	expectNilTask(t, scheduler.Next())
+	scheduler.Put(testTask{1})
+	scheduler.Next().Close()
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{3})
+
+	// removing a blocked task and putting it back does not release it early
+	scheduler = NewDependencyScheduler(NewFifoScheduler(), diamond)
+	scheduler.Put(testTask{4}, testTask{2}, testTask{3})
+	expectTaskEquals(t, scheduler.Remove(testTask{4}.Id()), testTask{4})
+	scheduler.Put(testTask{4}, testTask{1})
+	expectSizeEquals(t, scheduler, 4)
+	scheduler.Next().Close()
+	scheduler.Next().Close()
+	last := scheduler.Next()
+	expectTaskEquals(t, last.Task(), testTask{3})
+	expectNilTask(t, scheduler.Next())
+	last.Close()
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{4})
+
+	// draining returns ready tasks followed by blocked tasks in the order they were put
+	scheduler = NewDependencyScheduler(NewFifoScheduler(), diamond)
+	scheduler.Put(testTask{4}, testTask{3}, testTask{1}, testTask{2})
+	drained := scheduler.Drain()
+	if len(drained) != 4 {
+		t.Fatalf("expected 4 drained tasks, received %d", len(drained))
+	}
+	for i, field := range []int{1, 4, 3, 2} {
+		expectTaskEquals(t, drained[i], testTask{field})
+	}
+	expectSizeEquals(t, scheduler, 0)
+	scheduler.Put(testTask{2})
+	expectContains(t, scheduler, testTask{2}, true)
+	expectNilTask(t, scheduler.Next())
+}
+
+func TestRateLimitedScheduler(t *testing.T) {
+	// common
+	testCommonDupTask(t, NewRateLimitedScheduler(NewFifoScheduler(), 10, 1))
+	testCommonSize(t, NewRateLimitedScheduler(NewFifoScheduler(), 10, 1))
+	testCommonContains(t, NewRateLimitedScheduler(NewFifoScheduler(), 10, 1))
+	testCommonRemove(t, NewRateLimitedScheduler(NewFifoScheduler(), 10, 1))
+	testCommonClear(t, NewRateLimitedScheduler(NewFifoScheduler(), 10, 1))
+	testCommonNextN(t, NewRateLimitedScheduler(NewFifoScheduler(), 10, 1))
+	testCommonEach(t, NewRateLimitedScheduler(NewFifoScheduler(), 10, 1))
+	testCommonDrain(t, NewRateLimitedScheduler(NewFifoScheduler(), 10, 1), NewRateLimitedScheduler(NewFifoScheduler(), 10, 1))
+	testCommonStats(t, NewRateLimitedScheduler(NewFifoScheduler(), 10, 1))
+
+	// a bucket of 3 refilling 2 tokens per ms
+	scheduler := NewRateLimitedScheduler(NewFifoScheduler(), 3, 2)
+	for i := 0; i < 20; i++ {
+		scheduler.Put(testTask{i})
+	}
+	drain := func() (n int) {
+		for scheduler.Next() != nil {
+			n++
+		}
+		return
+	}
+
+	// the initial burst is bounded by the capacity
+	if n := drain(); n != 3 {
+		t.Errorf("expected 3 tasks in the initial burst, received %d", n)
+	}
+	for ms := 1; ms <= 5; ms++ {
+		scheduler.Advance(1)
+		if n := drain(); n != 2 {
+			t.Errorf("expected 2 tasks at %dms, received %d", ms, n)
+		}
+	}
+	expectSizeEquals(t, scheduler, 7)
+
+	// idle time does not accumulate past the capacity
+	scheduler.Advance(100)
+	if scheduler.Tokens() != 3 {
+		t.Errorf("expected 3 tokens, received %d", scheduler.Tokens())
+	}
+	if n := drain(); n != 3 {
+		t.Errorf("expected 3 tasks after idling, received %d", n)
+	}
+
+	// tokens are not consumed when there are no tasks
+	scheduler.Advance(1)
+	drain()
+	scheduler.Advance(1)
+	if n := drain(); n != 2 {
+		t.Errorf("expected the last 2 tasks, received %d", n)
+	}
+	scheduler.Advance(1)
+	expectNilTask(t, scheduler.Next())
+	if scheduler.Tokens() != 2 {
+		t.Errorf("expected 2 unused tokens, received %d", scheduler.Tokens())
+	}
+}
+
+func TestWeightedFairScheduler(t *testing.T) {
+	schedulerFactory := func() Scheduler {
+		return NewFifoScheduler()
+	}
+	partitioner := func(t Task) (string, uint, SchedulerFactory) {
+		if t.(testTask).field%2 == 0 {
+			return "even", 1, schedulerFactory
+		}
+		return "odd", 1, schedulerFactory
+	}
+	unitCost := func(Task) int { return 1 }
+	weights := map[string]int{"odd": 2, "even": 1}
+
+	// common
+	testCommonDupTask(t, NewWeightedFairScheduler(partitioner, weights, unitCost))
+	testCommonSize(t, NewWeightedFairScheduler(partitioner, weights, unitCost))
+	testCommonContains(t, NewWeightedFairScheduler(partitioner, weights, unitCost))
+	testCommonRemove(t, NewWeightedFairScheduler(partitioner, weights, unitCost))
+	testCommonClear(t, NewWeightedFairScheduler(partitioner, weights, unitCost))
+	testCommonNextN(t, NewWeightedFairScheduler(partitioner, weights, unitCost))
+	testCommonEach(t, NewWeightedFairScheduler(partitioner, weights, unitCost))
+	testCommonDrain(t, NewWeightedFairScheduler(partitioner, weights, unitCost), NewWeightedFairScheduler(partitioner, weights, unitCost))
+	testCommonStats(t, NewWeightedFairScheduler(partitioner, weights, unitCost))
+
+	drain := func(scheduler Scheduler) (ids []int) {
+		for next := scheduler.Next(); next != nil; next = scheduler.Next() {
+			ids = append(ids, next.Task().(testTask).field)
+		}
+		return
+	}
+	expectOrder := func(actual, expected []int) {
+		if len(actual) != len(expected) {
+			t.Fatalf("expected order %v, received %v", expected, actual)
+		}
+		for i := range expected {
+			if actual[i] != expected[i] {
+				t.Errorf("expected order %v, received %v", expected, actual)
+				return
+			}
+		}
+	}
+
+	// a burst of odd tasks arriving ahead of the even ones
+	putSkewed := func(scheduler Scheduler) Scheduler {
+		for i := 1; i <= 11; i += 2 {
+			scheduler.Put(testTask{i})
+		}
+		for i := 2; i <= 12; i += 2 {
+			scheduler.Put(testTask{i})
+		}
+		return scheduler
+	}
+
+	// round robin alternates regardless of weight, while weighted fair queueing
+	// serves two odd tasks for each even task until the odd partition is empty
+	expectOrder(drain(putSkewed(NewPartitionedScheduler(partitioner))), []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
+	expectOrder(drain(putSkewed(NewWeightedFairScheduler(partitioner, weights, unitCost))), []int{1, 2, 3, 5, 4, 7, 9, 6, 11, 8, 10, 12})
+
+	// costlier tasks advance virtual time further
+	cost := func(t Task) int { return t.(testTask).field }
+	scheduler := NewWeightedFairScheduler(partitioner, nil, cost)
+	scheduler.Put(testTask{9}, testTask{11}, testTask{2}, testTask{4}, testTask{6})
+	expectOrder(drain(scheduler), []int{9, 2, 4, 6, 11})
+
+	// an idle partition does not build up credit while others are served, so it
+	// alternates with them once active rather than catching up
+	scheduler = NewWeightedFairScheduler(partitioner, nil, unitCost)
+	for i := 1; i <= 9; i += 2 {
+		scheduler.Put(testTask{i})
+	}
+	drain(scheduler)
+	scheduler.Put(testTask{13}, testTask{15}, testTask{17}, testTask{2}, testTask{4}, testTask{6})
+	expectOrder(drain(scheduler), []int{2, 13, 4, 15, 6, 17})
+
+	// higher priorities are served first
+	priPartitioner := func(t Task) (string, uint, SchedulerFactory) {
+		if t.(testTask).field%2 == 0 {
+			return "even", 2, schedulerFactory
+		}
+		return "odd", 1, schedulerFactory
+	}
+	scheduler = NewWeightedFairScheduler(priPartitioner, weights, unitCost)
+	scheduler.Put(testTask{1}, testTask{3}, testTask{2}, testTask{4})
+	expectOrder(drain(scheduler), []int{2, 4, 1, 3})
+	expectSizeEquals(t, scheduler, 0)
+}
+
+func TestLotteryScheduler(t *testing.T) {
+	schedulerFactory := func() Scheduler {
+		return NewFifoScheduler()
+	}
+	partitioner := func(t Task) (string, uint, SchedulerFactory) {
+		return fmt.Sprintf("rem_%d", t.(testTask).field%3), 1, schedulerFactory
+	}
+	tickets := map[string]int{"rem_0": 1, "rem_1": 3, "rem_2": 6}
+
+	// common
+	testCommonDupTask(t, NewLotteryScheduler(partitioner, tickets, 1))
+	testCommonSize(t, NewLotteryScheduler(partitioner, tickets, 1))
+	testCommonContains(t, NewLotteryScheduler(partitioner, tickets, 1))
+	testCommonRemove(t, NewLotteryScheduler(partitioner, tickets, 1))
+	testCommonClear(t, NewLotteryScheduler(partitioner, tickets, 1))
+	testCommonNextN(t, NewLotteryScheduler(partitioner, tickets, 1))
+	testCommonEach(t, NewLotteryScheduler(partitioner, tickets, 1))
+	testCommonDrain(t, NewLotteryScheduler(partitioner, tickets, 1), NewLotteryScheduler(partitioner, tickets, 1))
+	testCommonStats(t, NewLotteryScheduler(partitioner, tickets, 1))
+
+	// partitions are selected in proportion to their tickets
+	draws := 10000
+	scheduler := NewLotteryScheduler(partitioner, tickets, 42)
+	for i := 0; i < 3*draws; i++ {
+		scheduler.Put(testTask{i})
+	}
+	counts := map[string]int{}
+	for i := 0; i < draws; i++ {
+		key, _, _ := partitioner(scheduler.Next().Task())
+		counts[key]++
+	}
+	for key, count := range tickets {
+		expected := float64(count) / 10
+		actual := float64(counts[key]) / float64(draws)
+		if actual < expected-0.02 || actual > expected+0.02 {
+			t.Errorf("expected %s selected with frequency %.2f, received %.3f", key, expected, actual)
+		}
+	}
+
+	// the same seed draws the same order
+	drain := func(seed int64) (ids []string) {
+		scheduler := NewLotteryScheduler(partitioner, tickets, seed)
+		for i := 0; i < 30; i++ {
+			scheduler.Put(testTask{i})
+		}
+		for next := scheduler.Next(); next != nil; next = scheduler.Next() {
+			ids = append(ids, next.Id())
+		}
+		return
+	}
+	one, two := drain(7), drain(7)
+	if len(one) != 30 || len(two) != 30 {
+		t.Fatalf("expected 30 tasks, received %d and %d", len(one), len(two))
+	}
+	for i := range one {
+		if one[i] != two[i] {
+			t.Errorf("expected equal order at %d, received %s and %s", i, one[i], two[i])
+		}
+	}
+
+	// higher priorities are drawn first
+	priPartitioner := func(t Task) (string, uint, SchedulerFactory) {
+		if t.(testTask).field%2 == 0 {
+			return "even", 2, schedulerFactory
+		}
+		return "odd", 1, schedulerFactory
+	}
+	scheduler = NewLotteryScheduler(priPartitioner, nil, 1)
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3}, testTask{4})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{2})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{4})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{3})
+	expectNilTask(t, scheduler.Next())
+}
+
+func TestGangScheduler(t *testing.T) {
+	var calc ResourceCalculator = func(t Task) Resource {
+		return NewResourceVectorRequest([]int{1})
+	}
+	singles := func(t Task) (string, int) { return t.Id(), 1 }
+
+	// common
+	testCommonDupTask(t, NewGangScheduler(singles, NewResourceVectorPool([]int{100}), calc))
+	testCommonSize(t, NewGangScheduler(singles, NewResourceVectorPool([]int{100}), calc))
+	testCommonContains(t, NewGangScheduler(singles, NewResourceVectorPool([]int{100}), calc))
+	testCommonRemove(t, NewGangScheduler(singles, NewResourceVectorPool([]int{100}), calc))
+	testCommonClear(t, NewGangScheduler(singles, NewResourceVectorPool([]int{100}), calc))
+	testCommonNextN(t, NewGangScheduler(singles, NewResourceVectorPool([]int{100}), calc))
+	testCommonEach(t, NewGangScheduler(singles, NewResourceVectorPool([]int{100}), calc))
+	testCommonDrain(t, NewGangScheduler(singles, NewResourceVectorPool([]int{100}), calc), NewGangScheduler(singles, NewResourceVectorPool([]int{100}), calc))
+	testCommonStats(t, NewGangScheduler(singles, NewResourceVectorPool([]int{100}), calc))
+
+	// tasks 1-3 form a gang of three, and the rest are gangs of one
+	gangs := func(t Task) (string, int) {
+		if field := t.(testTask).field; field <= 3 {
+			return "gang", 3
+		}
+		return t.Id(), 1
+	}
+
+	// a gang of three is never scheduled with two resources
+	pool := NewResourceVectorPool([]int{2})
+	scheduler := NewGangScheduler(gangs, pool, calc)
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3})
+	expectNilTask(t, scheduler.Next())
+	if pool.resources[0] != 2 {
+		t.Errorf("expected no resources held, received %d available", pool.resources[0])
+	}
+
+	// nor is it scheduled before all of its tasks are put
+	pool = NewResourceVectorPool([]int{3})
+	scheduler = NewGangScheduler(gangs, pool, calc)
+	scheduler.Put(testTask{1}, testTask{2})
+	expectNilTask(t, scheduler.Next())
+
+	// and waits until three resources are available at once
+	held := pool.Request(NewResourceVectorRequest([]int{1}))
+	scheduler.Put(testTask{3})
+	expectNilTask(t, scheduler.Next())
+	if pool.resources[0] != 2 {
+		t.Errorf("expected partially granted resources returned, received %d available", pool.resources[0])
+	}
+	held.Return()
+	first := scheduler.Next()
+	expectTaskEquals(t, first.Task(), testTask{1})
+	if pool.resources[0] != 0 {
+		t.Errorf("expected all resources reserved, received %d available", pool.resources[0])
+	}
+	expectSizeEquals(t, scheduler, 2)
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{2})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{3})
+	expectNilTask(t, scheduler.Next())
+	first.Close()
+	if pool.resources[0] != 1 {
+		t.Errorf("expected resource returned on close, received %d available", pool.resources[0])
+	}
+
+	// removing a task of a granted gang returns its resource
+	pool = NewResourceVectorPool([]int{3})
+	scheduler = NewGangScheduler(gangs, pool, calc)
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3}, testTask{4})
+	scheduler.Next()
+	expectTaskEquals(t, scheduler.Remove(testTask{3}.Id()), testTask{3})
+	if pool.resources[0] != 1 {
+		t.Errorf("expected removed task's resource returned, received %d available", pool.resources[0])
+	}
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{2})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{4})
+}
+
+func TestObservableScheduler(t *testing.T) {
+	// common
+	testCommonDupTask(t, NewObservableScheduler(NewFifoScheduler(), SchedulerObserver{}))
+	testCommonSize(t, NewObservableScheduler(NewFifoScheduler(), SchedulerObserver{}))
+	testCommonContains(t, NewObservableScheduler(NewFifoScheduler(), SchedulerObserver{}))
+	testCommonRemove(t, NewObservableScheduler(NewFifoScheduler(), SchedulerObserver{}))
+	testCommonClear(t, NewObservableScheduler(NewFifoScheduler(), SchedulerObserver{}))
+	testCommonNextN(t, NewObservableScheduler(NewFifoScheduler(), SchedulerObserver{}))
+	testCommonEach(t, NewObservableScheduler(NewFifoScheduler(), SchedulerObserver{}))
+	testCommonDrain(t, NewObservableScheduler(NewFifoScheduler(), SchedulerObserver{}), NewObservableScheduler(NewFifoScheduler(), SchedulerObserver{}))
+	testCommonStats(t, NewObservableScheduler(NewFifoScheduler(), SchedulerObserver{}))
+
+	puts, nexts, closes := map[string]int{}, map[string]int{}, map[string]int{}
+	observer := SchedulerObserver{
+		OnPut:   func(task Task) { puts[task.Id()]++ },
+		OnNext:  func(st ScheduledTask) { nexts[st.Id()]++ },
+		OnClose: func(st ScheduledTask) { closes[st.Id()]++ },
+	}
+	expectCounts := func(counts map[string]int, event string, ids ...string) {
+		if len(counts) != len(ids) {
+			t.Errorf("expected %s for %d tasks, received %v", event, len(ids), counts)
+		}
+		for _, id := range ids {
+			if counts[id] != 1 {
+				t.Errorf("expected %s once for task %s, received %d", event, id, counts[id])
+			}
+		}
+	}
+
+	// closes chain through to the resource managed scheduler underneath
+	calc := func(_ Task) Resource { return NewResourceVectorRequest([]int{1}) }
+	pool := NewResourceVectorPool([]int{1})
+	underlying := &closeTrackingScheduler{NewBoundedFifoScheduler(3), map[string]int{}}
+	scheduler := NewObservableScheduler(NewResourceManagedScheduler(underlying, pool, calc), observer)
+
+	// duplicates and tasks dropped by the bounded scheduler are not reported
+	scheduler.Put(testTask{1}, testTask{2}, testTask{1}, testTask{3}, testTask{4})
+	expectCounts(puts, "OnPut", "1", "2", "3")
+	expectCounts(nexts, "OnNext")
+
+	for i := 1; i <= 3; i++ {
+		next := scheduler.Next()
+		expectTaskEquals(t, next.Task(), testTask{i})
+		expectNilTask(t, scheduler.Next())
+		if closes[next.Id()] != 0 {
+			t.Errorf("expected OnClose not called before Close() for task %d", i)
+		}
+		next.Close()
+		next.Close()
+		if underlying.closed[next.Id()] == 0 {
+			t.Errorf("expected underlying task %d closed", i)
+		}
+	}
+	if pool.resources[0] != 1 {
+		t.Errorf("expected resources returned to the pool, received %d available", pool.resources[0])
+	}
+	expectCounts(puts, "OnPut", "1", "2", "3")
+	expectCounts(nexts, "OnNext", "1", "2", "3")
+	expectCounts(closes, "OnClose", "1", "2", "3")
+}
+
+func TestTypedScheduler(t *testing.T) {
+	// partitioners and resource calculators take the concrete task type directly
+	partitioner := TypedPartitioner[*SimTask](func(st *SimTask) (string, uint, SchedulerFactory) {
+		return strconv.Itoa(st.UserId), uint(st.Priority), func() Scheduler { return NewFifoScheduler() }
+	})
+	calc := TypedResourceCalculator[*SimTask](func(st *SimTask) Resource {
+		return NewResourceVectorRequest([]int{st.RuntimeMs})
+	})
+	pool := NewResourceVectorPool([]int{10})
+	scheduler := NewTypedScheduler[*SimTask](NewResourceManagedScheduler(
+		NewPartitionedScheduler(partitioner.Partitioner()), pool, calc.ResourceCalculator()))
+
+	tasks := []*SimTask{
+		{Identifier: 1, UserId: 1, RuntimeMs: 5},
+		{Identifier: 2, UserId: 1, RuntimeMs: 5},
+		{Identifier: 3, UserId: 2, RuntimeMs: 5},
+		{Identifier: 4, UserId: 3, RuntimeMs: 5, Priority: 1},
+	}
+	scheduler.Put(tasks...)
+	expectSizeEquals(t, scheduler.Scheduler(), 4)
+	if !scheduler.Contains(tasks[0]) {
+		t.Error("expected scheduler to contain task 1")
+	}
+
+	visited := []int{}
+	scheduler.Each(func(st *SimTask) bool {
+		visited = append(visited, st.Identifier)
+		return true
+	})
+	if fmt.Sprint(visited) != "[4 1 3 2]" {
+		t.Errorf("expected to visit [4 1 3 2], received %v", visited)
+	}
+
+	first := scheduler.Next()
+	if first.Task().Identifier != 4 {
+		t.Errorf("expected task 4 first, received %d", first.Task().Identifier)
+	}
+	second := scheduler.NextN(2)
+	if len(second) != 1 || second[0].Task().UserId != 1 {
+		t.Fatalf("expected one task from user 1, received %d tasks", len(second))
+	}
+	if scheduler.Next() != nil {
+		t.Error("expected nil task while resources are held")
+	}
+	first.Close()
+	second[0].Close()
+
+	if removed, ok := scheduler.Remove(tasks[2].Id()); !ok || removed.UserId != 2 {
+		t.Errorf("expected to remove task 3, received %v", removed)
+	}
+	if _, ok := scheduler.Remove(tasks[2].Id()); ok {
+		t.Error("expected not to remove task 3 twice")
+	}
+	drained := scheduler.Drain()
+	if len(drained) != 1 || drained[0].Identifier != 2 {
+		t.Errorf("expected to drain task 2, received %v", drained)
+	}
+	if stats := scheduler.Stats(); stats.Puts != 4 || stats.Nexts != 2 || stats.Removes != 1 {
+		t.Errorf("expected 4 puts, 2 nexts and 1 remove, received %+v", stats)
+	}
+	scheduler.Clear()
+	expectSizeEquals(t, scheduler.Scheduler(), 0)
+}
+
+func TestQuotaScheduler(t *testing.T) {
+	parity := func(t Task) string {
+		return strconv.Itoa(t.(testTask).field % 2)
+	}
+	var parityPartitioner Partitioner = func(t Task) (string, uint, SchedulerFactory) {
+		return parity(t), 0, func() Scheduler { return NewFifoScheduler() }
+	}
+
+	// common
+	testCommonDupTask(t, NewQuotaScheduler(NewPartitionedScheduler(parityPartitioner), parity, 10))
+	testCommonSize(t, NewQuotaScheduler(NewPartitionedScheduler(parityPartitioner), parity, 10))
+	testCommonContains(t, NewQuotaScheduler(NewPartitionedScheduler(parityPartitioner), parity, 10))
+	testCommonRemove(t, NewQuotaScheduler(NewPartitionedScheduler(parityPartitioner), parity, 10))
+	testCommonClear(t, NewQuotaScheduler(NewPartitionedScheduler(parityPartitioner), parity, 10))
+	testCommonNextN(t, NewQuotaScheduler(NewPartitionedScheduler(parityPartitioner), parity, 10))
+	testCommonEach(t, NewQuotaScheduler(NewPartitionedScheduler(parityPartitioner), parity, 10))
+	testCommonDrain(t, NewQuotaScheduler(NewPartitionedScheduler(parityPartitioner), parity, 10), NewQuotaScheduler(NewPartitionedScheduler(parityPartitioner), parity, 10))
+	testCommonStats(t, NewQuotaScheduler(NewPartitionedScheduler(parityPartitioner), parity, 10))
+
+	// with one task of each key running at once, a key's second task waits for its first
+	underlying := &closeTrackingScheduler{NewFifoScheduler(), map[string]int{}}
+	scheduler := NewQuotaScheduler(underlying, parity, 1)
+	scheduler.Put(testTask{1}, testTask{3}, testTask{2}, testTask{5})
+	first := scheduler.Next()
+	expectTaskEquals(t, first.Task(), testTask{1})
+	second := scheduler.Next()
+	expectTaskEquals(t, second.Task(), testTask{2})
+	expectNilTask(t, scheduler.Next())
+	if scheduler.Running("1") != 1 || scheduler.Running("0") != 1 {
+		t.Errorf("expected one task of each key running, received %d and %d", scheduler.Running("1"), scheduler.Running("0"))
+	}
+
+	// held tasks are still pending
+	expectSizeEquals(t, scheduler, 2)
+	expectContains(t, scheduler, testTask{3}, true)
+
+	// closing the first frees its key's slot for the next task of that key, once
+	first.Close()
+	first.Close()
+	if underlying.closed["1"] != 2 {
+		t.Errorf("expected close passed to the underlying task, received %d", underlying.closed["1"])
+	}
+	third := scheduler.Next()
+	expectTaskEquals(t, third.Task(), testTask{3})
+	expectNilTask(t, scheduler.Next())
+	second.Close()
+	expectNilTask(t, scheduler.Next())
+	third.Close()
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{5})
+	expectSizeEquals(t, scheduler, 0)
+
+	// removing a held task does not close the task it was taken from the underlying
+	// scheduler with, as it never ran
+	scheduler = NewQuotaScheduler(underlying, parity, 1)
+	scheduler.Put(testTask{7}, testTask{9})
+	running := scheduler.Next()
+	expectNilTask(t, scheduler.Next())
+	expectTaskEquals(t, scheduler.Remove(testTask{9}.Id()), testTask{9})
+	if underlying.closed["9"] != 0 {
+		t.Errorf("expected removed held task not closed, received %d closes", underlying.closed["9"])
+	}
+	running.Close()
+	expectSizeEquals(t, scheduler, 0)
+
+	// so the dependents of a removed, drained or cleared held task stay blocked
+	for _, drop := range []func(*QuotaScheduler){
+		func(q *QuotaScheduler) { q.Remove(testTask{3}.Id()) },
+		func(q *QuotaScheduler) { q.Drain() },
+		func(q *QuotaScheduler) { q.Clear() },
+	} {
+		dependencies := map[string][]string{testTask{4}.Id(): {testTask{3}.Id()}}
+		scheduler = NewQuotaScheduler(NewDependencyScheduler(NewFifoScheduler(), dependencies), parity, 1)
+		scheduler.Put(testTask{1}, testTask{3})
+		running = scheduler.Next()
+		expectNilTask(t, scheduler.Next())
+		drop(scheduler)
+		scheduler.Put(testTask{4})
+		running.Close()
+		expectNilTask(t, scheduler.Next())
+	}
+
+	// and the resources granted to held tasks are returned when they are dropped
+	calc := func(_ Task) Resource { return NewResourceVectorRequest([]int{1}) }
+	pool := NewResourceVectorPool([]int{2})
+	scheduler = NewQuotaScheduler(NewResourceManagedScheduler(NewFifoScheduler(), pool, calc), parity, 1)
+	scheduler.Put(testTask{1}, testTask{3})
+	running = scheduler.Next()
+	expectNilTask(t, scheduler.Next())
+	scheduler.Clear()
+	if pool.resources[0] != 1 {
+		t.Errorf("expected the held task's resource returned, received %d available", pool.resources[0])
+	}
+	running.Close()
+
+	// a key at its limit does not hold up the tasks of other keys put behind it
+	scheduler = NewQuotaScheduler(NewFifoScheduler(), parity, 1)
+	scheduler.Put(testTask{1}, testTask{3}, testTask{5})
+	running = scheduler.Next()
+	expectTaskEquals(t, running.Task(), testTask{1})
+	expectNilTask(t, scheduler.Next())
+	scheduler.Put(testTask{2})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{2})
+	running.Close()
+
+	// held tasks are capped per key, so under a resource managed scheduler only they
+	// are granted resources while their key is at its limit, and the rest of the key
+	// stay queued
+	pool = NewResourceVectorPool([]int{10})
+	rms := NewResourceManagedScheduler(NewFifoScheduler(), pool, calc)
+	scheduler = NewQuotaSchedulerWithLookahead(rms, parity, 1, 2)
+	scheduler.Put(testTask{1}, testTask{3}, testTask{5}, testTask{2}, testTask{7}, testTask{9}, testTask{4})
+	running = scheduler.Next()
+	expectTaskEquals(t, running.Task(), testTask{1})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{2})
+	expectNilTask(t, scheduler.Next())
+	if pool.resources[0] != 6 {
+		t.Errorf("expected resources granted to the running and 2 held tasks only, received %d available", pool.resources[0])
+	}
+	expectSizeEquals(t, rms, 3)
+	expectSizeEquals(t, scheduler, 5)
+
+	// once the key has room the held tasks are returned in order, and the rest are
+	// taken from the underlying scheduler in its order
+	running.Close()
+	running = scheduler.Next()
+	expectTaskEquals(t, running.Task(), testTask{3})
+	for _, field := range []int{5, 7, 9} {
+		running.Close()
+		running = scheduler.Next()
+		expectTaskEquals(t, running.Task(), testTask{field})
+	}
+	expectSizeEquals(t, scheduler, 1)
+}
+
+func TestFlatRoundRobinScheduler(t *testing.T) {
+	var partitioner FlatPartitioner = func(t Task) (string, SchedulerFactory) {
+		return fmt.Sprintf("key_%d", t.(testTask).field%3), func() Scheduler { return NewFifoScheduler() }
+	}
+
+	// common
+	testCommonDupTask(t, NewFlatRoundRobinScheduler(partitioner))
+	testCommonSize(t, NewFlatRoundRobinScheduler(partitioner))
+	testCommonContains(t, NewFlatRoundRobinScheduler(partitioner))
+	testCommonRemove(t, NewFlatRoundRobinScheduler(partitioner))
+	testCommonClear(t, NewFlatRoundRobinScheduler(partitioner))
+	testCommonNextN(t, NewFlatRoundRobinScheduler(partitioner))
+	testCommonEach(t, NewFlatRoundRobinScheduler(partitioner))
+	testCommonDrain(t, NewFlatRoundRobinScheduler(partitioner), NewFlatRoundRobinScheduler(partitioner))
+	testCommonStats(t, NewFlatRoundRobinScheduler(partitioner))
+
+	// three keys are perfectly interleaved however their tasks are put
+	scheduler := NewFlatRoundRobinScheduler(partitioner)
+	scheduler.Put(testTask{0}, testTask{3}, testTask{6}, testTask{9})
+	scheduler.Put(testTask{1}, testTask{4}, testTask{7}, testTask{10})
+	scheduler.Put(testTask{2}, testTask{5}, testTask{8}, testTask{11})
+	for i := 0; i < 12; i++ {
+		expectTaskEquals(t, scheduler.Next().Task(), testTask{i})
+	}
+	expectNilTask(t, scheduler.Next())
+
+	// a key that runs dry drops out of the rotation without disturbing the others
+	scheduler.Put(testTask{0}, testTask{1}, testTask{4}, testTask{2}, testTask{5}, testTask{8})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{0})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
+	expectTaskEquals(t, scheduler.Remove(testTask{5}.Id()), testTask{5})
+	visited := collect(scheduler)
+	for i, field := range []int{2, 4, 8} {
+		expectTaskEquals(t, visited[i], testTask{field})
+		expectTaskEquals(t, scheduler.Next().Task(), testTask{field})
+	}
+	expectNilTask(t, scheduler.Next())
+	if len(scheduler.partitions) != 0 {
+		t.Errorf("expected no partitions, received %d", len(scheduler.partitions))
+	}
+}
+
+func TestChainScheduler(t *testing.T) {
+	// common
+	testCommonDupTask(t, NewChainScheduler(NewFifoScheduler(), NewFifoScheduler()))
+	testCommonSize(t, NewChainScheduler(NewFifoScheduler(), NewFifoScheduler()))
+	testCommonContains(t, NewChainScheduler(NewFifoScheduler(), NewFifoScheduler()))
+	testCommonRemove(t, NewChainScheduler(NewFifoScheduler(), NewFifoScheduler()))
+	testCommonClear(t, NewChainScheduler(NewFifoScheduler(), NewFifoScheduler()))
+	testCommonNextN(t, NewChainScheduler(NewFifoScheduler(), NewFifoScheduler()))
+	testCommonEach(t, NewChainScheduler(NewFifoScheduler(), NewFifoScheduler()))
+	testCommonDrain(t, NewChainScheduler(NewFifoScheduler(), NewFifoScheduler()), NewChainScheduler(NewFifoScheduler(), NewFifoScheduler()))
+	testCommonStats(t, NewChainScheduler(NewFifoScheduler(), NewFifoScheduler()))
+
+	// the primary is emptied before the secondary
+	secondary := NewFifoScheduler()
+	secondary.Put(testTask{1}, testTask{2})
+	scheduler := NewChainScheduler(NewLifoScheduler(), secondary)
+	scheduler.Put(testTask{3}, testTask{4})
+	expectSizeEquals(t, scheduler, 4)
+	expectContains(t, scheduler, testTask{1}, true)
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{4})
+	scheduler.Put(testTask{5})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{5})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{3})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
+
+	// tasks held by the secondary are not put again
+	scheduler.Put(testTask{2})
+	expectSizeEquals(t, scheduler, 1)
+
+	// removing an id present only in the secondary removes it from the secondary
+	expectTaskEquals(t, scheduler.Remove(testTask{2}.Id()), testTask{2})
+	expectSizeEquals(t, secondary, 0)
+	expectContains(t, scheduler, testTask{2}, false)
+	expectNilTask(t, scheduler.Next())
+
+	// each and drain visit the primary before the secondary
+	secondary.Put(testTask{1})
+	scheduler.Put(testTask{2})
+	visited := collect(scheduler)
+	expectTaskEquals(t, visited[0], testTask{2})
+	expectTaskEquals(t, visited[1], testTask{1})
+	drained := scheduler.Drain()
+	expectTaskEquals(t, drained[0], testTask{2})
+	expectTaskEquals(t, drained[1], testTask{1})
+	expectSizeEquals(t, scheduler, 0)
+}
+
+func TestExpiringScheduler(t *testing.T) {
+	// tasks are enqueued at the time of their field
+	enqueued := func(t Task) int { return t.(testTask).field }
+	newScheduler := func() Scheduler { return NewExpiringScheduler(NewFifoScheduler(), enqueued, 100) }
+
+	// common
+	testCommonDupTask(t, newScheduler())
+	testCommonSize(t, newScheduler())
+	testCommonContains(t, newScheduler())
+	testCommonRemove(t, newScheduler())
+	testCommonClear(t, newScheduler())
+	testCommonNextN(t, newScheduler())
+	testCommonEach(t, newScheduler())
+	testCommonDrain(t, newScheduler(), newScheduler())
+	testCommonStats(t, newScheduler())
+
+	// a task is kept until the clock passes its max age
+	scheduler := NewExpiringScheduler(NewFifoScheduler(), enqueued, 100)
+	scheduler.Put(testTask{0}, testTask{50})
+	scheduler.Advance(100)
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{0})
+	scheduler.Put(testTask{0})
+	scheduler.Advance(101)
+	expectSizeEquals(t, scheduler, 2)
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{50})
+	expectNilTask(t, scheduler.Next())
+	if dropped := scheduler.Dropped(); dropped != 1 {
+		t.Errorf("expected 1 task dropped, received %d", dropped)
+	}
+	expectSizeEquals(t, scheduler, 0)
+
+	// moving the clock backwards has no effect
+	scheduler.Advance(50)
+	if now := scheduler.Now(); now != 101 {
+		t.Errorf("expected the clock at 101ms, received %d", now)
+	}
+
+	// the resources of dropped tasks are returned
+	pool := NewResourceVectorPool([]int{1})
+	unit := func(Task) Resource { return NewResourceVectorRequest([]int{1}) }
+	scheduler = NewExpiringScheduler(NewResourceManagedScheduler(NewFifoScheduler(), pool, unit), enqueued, 100)
+	scheduler.Put(testTask{0}, testTask{150})
+	scheduler.Advance(200)
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{150})
+	if dropped := scheduler.Dropped(); dropped != 1 {
+		t.Errorf("expected 1 task dropped, received %d", dropped)
+	}
+	if available := pool.Available(); available[0] != 0 {
+		t.Errorf("expected the dropped task's resource to be returned, received %v available", available)
+	}
+
+	// dropped tasks do not complete, so their dependents stay blocked
+	dependencies := map[string][]string{testTask{160}.Id(): {testTask{0}.Id()}}
+	scheduler = NewExpiringScheduler(NewDependencyScheduler(NewFifoScheduler(), dependencies), enqueued, 100)
+	scheduler.Put(testTask{0}, testTask{160})
+	scheduler.Advance(200)
+	expectNilTask(t, scheduler.Next())
+	expectSizeEquals(t, scheduler, 1)
+}
+
+func TestBestFitScheduler(t *testing.T) {
+	requests := map[int]int{1: 1, 2: 3, 3: 5, 4: 3}
+	calc := func(t Task) Resource {
+		return NewResourceVectorRequest([]int{requests[t.(testTask).field]})
+	}
+	unit := func(_ Task) Resource { return NewResourceVectorRequest([]int{1}) }
+
+	// common
+	testCommonDupTask(t, NewBestFitScheduler(NewResourceVectorPool([]int{100}), unit))
+	testCommonSize(t, NewBestFitScheduler(NewResourceVectorPool([]int{100}), unit))
+	testCommonContains(t, NewBestFitScheduler(NewResourceVectorPool([]int{100}), unit))
+	testCommonRemove(t, NewBestFitScheduler(NewResourceVectorPool([]int{100}), unit))
+	testCommonClear(t, NewBestFitScheduler(NewResourceVectorPool([]int{100}), unit))
+	testCommonNextN(t, NewBestFitScheduler(NewResourceVectorPool([]int{100}), unit))
+	testCommonEach(t, NewBestFitScheduler(NewResourceVectorPool([]int{100}), unit))
+	testCommonDrain(t, NewBestFitScheduler(NewResourceVectorPool([]int{100}), unit), NewBestFitScheduler(NewResourceVectorPool([]int{100}), unit))
+	testCommonStats(t, NewBestFitScheduler(NewResourceVectorPool([]int{100}), unit))
+
+	// the 3 unit task fits 4 units more tightly than the 1 unit task, and the 5 unit task never fits
+	pool := NewResourceVectorPool([]int{4})
+	scheduler := NewBestFitScheduler(pool, calc)
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3})
+	first := scheduler.Next()
+	expectTaskEquals(t, first.Task(), testTask{2})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
+	expectNilTask(t, scheduler.Next())
+	expectSizeEquals(t, scheduler, 1)
+
+	// ties are broken in insertion order once resources are returned
+	first.Close()
+	scheduler.Put(testTask{4}, testTask{2})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{4})
+	expectNilTask(t, scheduler.Next())
+	if pool.resources[0] != 0 {
+		t.Errorf("expected pool exhausted, received %d available", pool.resources[0])
+	}
+
+	// ids leave the index with their tasks, whether granted, removed or cleared
+	scheduler = NewBestFitScheduler(NewResourceVectorPool([]int{100}), unit)
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
+	expectTaskEquals(t, scheduler.Remove(testTask{2}.Id()), testTask{2})
+	expectContains(t, scheduler, testTask{1}, false)
+	expectContains(t, scheduler, testTask{2}, false)
+	expectContains(t, scheduler, testTask{3}, true)
+	scheduler.Put(testTask{1}, testTask{2})
+	expectSizeEquals(t, scheduler, 3)
+	scheduler.Clear()
+	expectContains(t, scheduler, testTask{3}, false)
+	expectNilTask(t, scheduler.Remove(testTask{3}.Id()))
+	scheduler.Put(testTask{3})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{3})
+}
+
+func TestWorstFitScheduler(t *testing.T) {
+	requests := map[int]int{1: 1, 2: 3, 3: 5, 4: 1}
+	calc := func(t Task) Resource {
+		return NewResourceVectorRequest([]int{requests[t.(testTask).field]})
+	}
+	unit := func(_ Task) Resource { return NewResourceVectorRequest([]int{1}) }
+
+	// common
+	testCommonDupTask(t, NewWorstFitScheduler(NewResourceVectorPool([]int{100}), unit))
+	testCommonSize(t, NewWorstFitScheduler(NewResourceVectorPool([]int{100}), unit))
+	testCommonContains(t, NewWorstFitScheduler(NewResourceVectorPool([]int{100}), unit))
+	testCommonRemove(t, NewWorstFitScheduler(NewResourceVectorPool([]int{100}), unit))
+	testCommonClear(t, NewWorstFitScheduler(NewResourceVectorPool([]int{100}), unit))
+	testCommonNextN(t, NewWorstFitScheduler(NewResourceVectorPool([]int{100}), unit))
+	testCommonEach(t, NewWorstFitScheduler(NewResourceVectorPool([]int{100}), unit))
+	testCommonDrain(t, NewWorstFitScheduler(NewResourceVectorPool([]int{100}), unit), NewWorstFitScheduler(NewResourceVectorPool([]int{100}), unit))
+	testCommonStats(t, NewWorstFitScheduler(NewResourceVectorPool([]int{100}), unit))
+
+	// on the same pool and tasks, worst fit takes the smallest request where best fit takes the largest
+	worst := NewWorstFitScheduler(NewResourceVectorPool([]int{4}), calc)
+	best := NewBestFitScheduler(NewResourceVectorPool([]int{4}), calc)
+	for _, s := range []Scheduler{worst, best} {
+		s.Put(testTask{2}, testTask{3}, testTask{1}, testTask{4})
+	}
+	expectTaskEquals(t, worst.Next().Task(), testTask{1})
+	expectTaskEquals(t, best.Next().Task(), testTask{2})
+
+	// worst fit fits the other 1 unit task before the 3 unit task, which then no longer fits
+	expectTaskEquals(t, worst.Next().Task(), testTask{4})
+	expectNilTask(t, worst.Next())
+	expectSizeEquals(t, worst, 2)
+
+	// best fit fills the pool with the 3 and 1 unit tasks
+	expectTaskEquals(t, best.Next().Task(), testTask{1})
+	expectNilTask(t, best.Next())
+	expectSizeEquals(t, best, 2)
+}
+
+func TestDeficitRoundRobinScheduler(t *testing.T) {
+	schedulerFactory := func() Scheduler {
+		return NewFifoScheduler()
+	}
+	partitioner := func(t Task) (string, uint, SchedulerFactory) {
+		if t.(testTask).field%2 == 0 {
+			return "even", 1, schedulerFactory
+		}
+		return "odd", 1, schedulerFactory
+	}
+	unitCost := func(Task) int { return 1 }
+	quanta := map[string]int{"odd": 3, "even": 1}
+
+	// common
+	testCommonDupTask(t, NewDeficitRoundRobinScheduler(partitioner, quanta, unitCost))
+	testCommonSize(t, NewDeficitRoundRobinScheduler(partitioner, quanta, unitCost))
+	testCommonContains(t, NewDeficitRoundRobinScheduler(partitioner, quanta, unitCost))
+	testCommonRemove(t, NewDeficitRoundRobinScheduler(partitioner, quanta, unitCost))
+	testCommonClear(t, NewDeficitRoundRobinScheduler(partitioner, quanta, unitCost))
+	testCommonNextN(t, NewDeficitRoundRobinScheduler(partitioner, quanta, unitCost))
+	testCommonEach(t, NewDeficitRoundRobinScheduler(partitioner, quanta, unitCost))
+	testCommonDrain(t, NewDeficitRoundRobinScheduler(partitioner, quanta, unitCost), NewDeficitRoundRobinScheduler(partitioner, quanta, unitCost))
+	testCommonStats(t, NewDeficitRoundRobinScheduler(partitioner, quanta, unitCost))
+
+	countOdd := func(scheduler Scheduler, n int) (odd int) {
+		for _, st := range scheduler.NextN(n) {
+			odd += st.Task().(testTask).field % 2
+		}
+		return
+	}
+
+	// with unit costs the long run emissions track the 3:1 quanta
+	scheduler := NewDeficitRoundRobinScheduler(partitioner, quanta, unitCost)
+	for i := 0; i < 1000; i++ {
+		scheduler.Put(testTask{i})
+	}
+	if odd := countOdd(scheduler, 400); odd != 300 {
+		t.Errorf("expected 300 of 400 tasks odd, received %d", odd)
+	}
+
+	// quanta share cost rather than tasks, so odd tasks costing double get half the tasks their quanta would
+	doubleOdd := func(t Task) int { return 1 + t.(testTask).field%2 }
+	scheduler = NewDeficitRoundRobinScheduler(partitioner, map[string]int{"odd": 4, "even": 2}, doubleOdd)
+	for i := 0; i < 1000; i++ {
+		scheduler.Put(testTask{i})
+	}
+	if odd := countOdd(scheduler, 300); odd != 150 {
+		t.Errorf("expected 150 of 300 tasks odd, received %d", odd)
+	}
+
+	// a partition that runs dry forfeits its deficit and leaves the rotation
+	scheduler = NewDeficitRoundRobinScheduler(partitioner, quanta, unitCost)
+	scheduler.Put(testTask{1}, testTask{2}, testTask{4}, testTask{6}, testTask{3})
+	for _, field := range []int{1, 3, 2, 4, 6} {
+		expectTaskEquals(t, scheduler.Next().Task(), testTask{field})
+	}
+	expectNilTask(t, scheduler.Next())
+	if len(scheduler.partitions) != 0 {
+		t.Errorf("expected no partitions, received %d", len(scheduler.partitions))
+	}
+
+	// higher priority partitions are served first
+	scheduler = NewDeficitRoundRobinScheduler(func(t Task) (string, uint, SchedulerFactory) {
+		key, _, fact := partitioner(t)
+		return key, uint(t.(testTask).field % 2), fact
+	}, quanta, unitCost)
+	scheduler.Put(testTask{2}, testTask{1}, testTask{3})
+	for _, field := range []int{1, 3, 2} {
+		expectTaskEquals(t, scheduler.Next().Task(), testTask{field})
+	}
+
+	// a partition stalled on resources forfeits its deficit each time it is reached,
+	// so it does not burst once the resources are available
+	pool := NewResourceVectorPool([]int{3})
+	unit := func(Task) Resource { return NewResourceVectorRequest([]int{1}) }
+	scheduler = NewDeficitRoundRobinScheduler(func(t Task) (string, uint, SchedulerFactory) {
+		if t.(testTask).field%2 == 1 {
+			return "odd", 1, func() Scheduler { return NewResourceManagedScheduler(NewFifoScheduler(), pool, unit) }
+		}
+		return "even", 1, schedulerFactory
+	}, map[string]int{}, unitCost)
+	blocker := pool.Request(NewResourceVectorRequest([]int{3}))
+	scheduler.Put(testTask{1}, testTask{3}, testTask{5}, testTask{7})
+	for i := 0; i < 10; i++ {
+		scheduler.Put(testTask{2 * i})
+	}
+	for _, field := range []int{0, 2, 4, 6} {
+		expectTaskEquals(t, scheduler.Next().Task(), testTask{field})
+	}
+	blocker.Return()
+	for _, field := range []int{1, 8, 3, 10, 5, 12} {
+		next := scheduler.Next()
+		expectTaskEquals(t, next.Task(), testTask{field})
+		next.Close()
+	}
+}
+
+func TestFactoryByName(t *testing.T) {
+	// fifo and lifo are registered
+	for name, expected := range map[string]Scheduler{"fifo": &FifoScheduler{}, "lifo": &LifoScheduler{}} {
+		f, ok := FactoryByName(name)
+		if !ok {
+			t.Fatalf("expected a factory registered for %q", name)
+		}
+		if s := f(); reflect.TypeOf(s) != reflect.TypeOf(expected) {
+			t.Errorf("expected %q to create a %T, received %T", name, expected, s)
+		}
+	}
+
+	// registered factories are resolved by name
+	cost := func(t Task) int { return t.(testTask).field }
+	RegisterFactory("test_sjf", func() Scheduler { return NewShortestJobScheduler(cost) })
+	RegisterFactory("test_bounded", func() Scheduler { return NewBoundedFifoScheduler(1) })
+	f, ok := FactoryByName("test_sjf")
+	if !ok {
+		t.Fatal("expected a factory registered for \"test_sjf\"")
+	}
+	scheduler := f()
+	scheduler.Put(testTask{3}, testTask{1}, testTask{2})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{1})
+	if f, ok = FactoryByName("test_bounded"); !ok {
+		t.Fatal("expected a factory registered for \"test_bounded\"")
+	}
+	scheduler = f()
+	scheduler.Put(testTask{1}, testTask{2})
+	expectSizeEquals(t, scheduler, 1)
+
+	// registering a name again replaces its factory
+	RegisterFactory("test_bounded", func() Scheduler { return NewBoundedFifoScheduler(2) })
+	f, _ = FactoryByName("test_bounded")
+	scheduler = f()
+	scheduler.Put(testTask{1}, testTask{2})
+	expectSizeEquals(t, scheduler, 2)
+
+	// unknown names are not resolved
+	if f, ok := FactoryByName("unknown"); ok || f != nil {
+		t.Error("expected no factory for an unknown name")
+	}
+}
+
+func TestPeekN(t *testing.T) {
+	partitioner := func(t Task) (string, uint, SchedulerFactory) {
+		field := t.(testTask).field
+		return strconv.Itoa(field % 3), uint(field % 2), func() Scheduler { return NewFifoScheduler() }
+	}
+	cost := func(t Task) int { return -t.(testTask).field }
+	for _, scheduler := range []Scheduler{
+		NewFifoScheduler(),
+		NewLifoScheduler(),
+		NewShortestJobScheduler(cost),
+		NewPartitionedScheduler(partitioner),
+		NewResourceManagedScheduler(NewPartitionedScheduler(partitioner), NewResourceVectorPool([]int{0}), func(Task) Resource {
+			return NewResourceVectorRequest([]int{1})
+		}),
+	} {
+		for i := 1; i <= 10; i++ {
+			scheduler.Put(testTask{i})
+		}
+		// advance the round robin position of partitioned schedulers
+		if next := scheduler.Next(); next != nil {
+			next.Close()
+		}
+		size := scheduler.Size()
+		peeked := scheduler.PeekN(4)
+		again := scheduler.PeekN(size)
+		expectSizeEquals(t, scheduler, size)
+		drained := scheduler.Drain()
+		if len(again) != len(drained) {
+			t.Fatalf("%T: expected to peek %d tasks, received %d", scheduler, len(drained), len(again))
+		}
+		for i := range drained {
+			expectTaskEquals(t, again[i], drained[i])
+			if i < len(peeked) {
+				expectTaskEquals(t, peeked[i], drained[i])
+			}
+		}
+	}
+}
+
+func TestHierarchicalPartitionedScheduler(t *testing.T) {
+	// tasks are split in to a fast lane below 100 and a slow lane, then by user in the tens
+	lanesThenUsers := func(t Task) ([]string, uint, SchedulerFactory) {
+		field := t.(testTask).field
+		lane := "fast"
+		if field >= 100 {
+			lane = "slow"
+		}
+		return []string{lane, strconv.Itoa(field % 100 / 10)}, 0, func() Scheduler { return NewFifoScheduler() }
+	}
+
+	// common
+	testCommonDupTask(t, NewHierarchicalPartitionedScheduler(lanesThenUsers))
+	testCommonSize(t, NewHierarchicalPartitionedScheduler(lanesThenUsers))
+	testCommonContains(t, NewHierarchicalPartitionedScheduler(lanesThenUsers))
+	testCommonRemove(t, NewHierarchicalPartitionedScheduler(lanesThenUsers))
+	testCommonClear(t, NewHierarchicalPartitionedScheduler(lanesThenUsers))
+	testCommonNextN(t, NewHierarchicalPartitionedScheduler(lanesThenUsers))
+	testCommonEach(t, NewHierarchicalPartitionedScheduler(lanesThenUsers))
+	testCommonDrain(t, NewHierarchicalPartitionedScheduler(lanesThenUsers), NewHierarchicalPartitionedScheduler(lanesThenUsers))
+	testCommonStats(t, NewHierarchicalPartitionedScheduler(lanesThenUsers))
+
+	// round robin over lanes, and over the users of each lane
+	scheduler := NewHierarchicalPartitionedScheduler(lanesThenUsers)
+	scheduler.Put(
+		testTask{1}, testTask{2}, testTask{11}, testTask{21},
+		testTask{101}, testTask{111}, testTask{112},
+	)
+	for _, expected := range []int{1, 101, 11, 111, 21, 112, 2} {
+		expectTaskEquals(t, scheduler.Next().Task(), testTask{expected})
+	}
+	expectNilTask(t, scheduler.Next())
+
+	// the top level holds a partition per lane
+	scheduler.Put(testTask{1}, testTask{11}, testTask{101})
+	if sizes := scheduler.PartitionSizes(); len(sizes) != 2 || sizes["fast"] != 2 || sizes["slow"] != 1 {
+		t.Errorf("expected 2 fast and 1 slow tasks, received %v", sizes)
+	}
+	expectContains(t, scheduler, testTask{11}, true)
+	expectTaskEquals(t, scheduler.Remove(testTask{11}.Id()), testTask{11})
+	expectContains(t, scheduler, testTask{11}, false)
+
+	// a task with no keys is partitioned under an empty key
+	flat := NewHierarchicalPartitionedScheduler(func(Task) ([]string, uint, SchedulerFactory) {
+		return nil, 0, func() Scheduler { return NewLifoScheduler() }
+	})
+	flat.Put(testTask{1}, testTask{2})
+	expectTaskEquals(t, flat.Next().Task(), testTask{2})
+	if sizes := flat.PartitionSizes(); sizes[""] != 1 {
+		t.Errorf("expected 1 task under the empty key, received %v", sizes)
+	}
+}
+
+func TestCopyInto(t *testing.T) {
+	fifo := NewFifoScheduler()
+	fifo.Put(testTask{3}, testTask{1}, testTask{2})
+	sjf := NewShortestJobScheduler(func(t Task) int { return t.(testTask).field })
+	fifo.CopyInto(sjf)
+
+	// both hold the same ids, and the source is left as it was
+	expectSizeEquals(t, fifo, 3)
+	expectSizeEquals(t, sjf, 3)
+	for _, field := range []int{1, 2, 3} {
+		expectContains(t, sjf, testTask{field}, true)
+	}
+	for _, field := range []int{1, 2, 3} {
+		expectTaskEquals(t, sjf.Next().Task(), testTask{field})
+	}
+	for _, field := range []int{3, 1, 2} {
+		expectTaskEquals(t, fifo.Next().Task(), testTask{field})
+	}
+
+	// tasks are put in emission order, so a bounded destination keeps the first
+	partitioned := NewPartitionedScheduler(func(t Task) (string, uint, SchedulerFactory) {
+		return strconv.Itoa(t.(testTask).field % 2), 0, func() Scheduler { return NewFifoScheduler() }
+	})
+	partitioned.Put(testTask{1}, testTask{3}, testTask{2}, testTask{4})
+	bounded := NewBoundedFifoScheduler(2)
+	partitioned.CopyInto(bounded)
+	expectTaskEquals(t, bounded.Next().Task(), testTask{1})
+	expectTaskEquals(t, bounded.Next().Task(), testTask{2})
+	expectNilTask(t, bounded.Next())
+	expectSizeEquals(t, partitioned, 4)
+}
+
+func TestPutResult(t *testing.T) {
+	expectOutcomes := func(actual []PutOutcome, expected ...PutOutcome) {
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected outcomes %v, received %v", expected, actual)
+		}
+	}
+	accepted, duplicate, rejected := PutAccepted, PutDuplicate, PutRejected
+
+	// new ids are accepted, and ids already held or repeated in the same put are duplicates
+	fifo := NewFifoScheduler()
+	fifo.Put(testTask{1})
+	expectOutcomes(fifo.PutResult(testTask{1}, testTask{2}, testTask{3}, testTask{2}), duplicate, accepted, accepted, duplicate)
+	expectSizeEquals(t, fifo, 3)
+	if stats := fifo.Stats(); stats.Puts != 3 {
+		t.Errorf("expected 3 puts, received %d", stats.Puts)
+	}
+
+	// tasks past the capacity are rejected, but duplicates are still duplicates
+	bounded := NewBoundedFifoScheduler(2)
+	expectOutcomes(bounded.PutResult(testTask{1}, testTask{2}, testTask{3}, testTask{1}), accepted, accepted, rejected, duplicate)
+
+	// replaced duplicates are duplicates
+	replacing := NewFifoSchedulerWithDuplicatePolicy(ReplaceDuplicate)
+	expectOutcomes(replacing.PutResult(testTask{1}, testTask{1}), accepted, duplicate)
+	expectOutcomes(replacing.PutResult(), []PutOutcome{}...)
+
+	// tasks put in to a partition at its cap are rejected
+	partitioned := NewPartitionedSchedulerWithPartitionCap(func(t Task) (string, uint, SchedulerFactory) {
+		return strconv.Itoa(t.(testTask).field % 2), 0, func() Scheduler { return NewFifoScheduler() }
+	}, 2)
+	partitioned.Put(testTask{2})
+	expectOutcomes(partitioned.PutResult(testTask{2}, testTask{4}, testTask{6}, testTask{1}, testTask{3}, testTask{1}),
+		duplicate, accepted, rejected, accepted, accepted, duplicate)
+	expectSizeEquals(t, partitioned, 4)
+	if stats := partitioned.Stats(); stats.Puts != 4 {
+		t.Errorf("expected 4 puts, received %d", stats.Puts)
+	}
+}
+
+func TestWrapperUnderlying(t *testing.T) {
+	underlying := NewFifoScheduler()
+	key := func(t Task) string { return "" }
+	wrappers := []Wrapper{
+		NewResourceManagedScheduler(underlying, NewInfinitePool(), nil),
+		NewObservableScheduler(underlying, SchedulerObserver{}),
+		NewDependencyScheduler(underlying, nil),
+		NewExpiringScheduler(underlying, func(Task) int { return 0 }, 100),
+		NewQuotaScheduler(underlying, key, 1),
+		NewRateLimitedScheduler(underlying, 1, 1),
+	}
+	for _, w := range wrappers {
+		if w.Underlying() != Scheduler(underlying) {
+			t.Errorf("expected %T to return the scheduler it was constructed with", w)
+		}
+	}
+}
+
+func TestDispatchingResourceCalculator(t *testing.T) {
+	// test tasks take the first resource and sim tasks the second, with anything
+	// else falling back to a unit of each
+	key := func(t Task) string {
+		switch t.(type) {
+		case testTask:
+			return "test"
+		case *SimTask:
+			return "sim"
+		}
+		return ""
+	}
+	calcs := map[string]ResourceCalculator{
+		"test": func(t Task) Resource { return NewResourceVectorRequest([]int{t.(testTask).field, 0}) },
+		"sim":  func(t Task) Resource { return NewResourceVectorRequest([]int{0, t.(*SimTask).RuntimeMs}) },
+	}
+	fallback := func(Task) Resource { return NewResourceVectorRequest([]int{1, 1}) }
+	calc := NewDispatchingResourceCalculator(key, calcs, fallback)
+	for _, c := range []struct {
+		task     Task
+		expected []int
+	}{
+		{testTask{2}, []int{2, 0}},
+		{&SimTask{Identifier: 1, RuntimeMs: 3}, []int{0, 3}},
+		{versionedTask{1, 1}, []int{1, 1}},
+	} {
+		if requested := calc(c.task); !EqualResources(requested, NewResourceVectorRequest(c.expected)) {
+			t.Errorf("expected %v requested for %v, received %v", c.expected, c.task, requested)
+		}
+	}
+
+	// tasks of both types run side by side on the resources of their type
+	scheduler := NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2, 3}), calc)
+	scheduler.Put(testTask{2}, &SimTask{Identifier: 1, RuntimeMs: 3}, testTask{1})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{2})
+	if next := scheduler.Next(); next == nil || next.Task().Id() != (&SimTask{Identifier: 1}).Id() {
+		t.Error("expected the sim task scheduled alongside the test task")
+	}
+	expectNilTask(t, scheduler.Next())
+}
+
+func TestFairShareScheduler(t *testing.T) {
+	// tasks from 100 belong to the second key
+	partitioner := func(t Task) (string, uint, SchedulerFactory) {
+		if t.(testTask).field >= 100 {
+			return "b", 1, func() Scheduler { return NewFifoScheduler() }
+		}
+		return "a", 1, func() Scheduler { return NewFifoScheduler() }
+	}
+	runtime := func(Task) int { return 10 }
+
+	// common
+	testCommonDupTask(t, NewFairShareScheduler(partitioner, runtime))
+	testCommonSize(t, NewFairShareScheduler(partitioner, runtime))
+	testCommonContains(t, NewFairShareScheduler(partitioner, runtime))
+	testCommonRemove(t, NewFairShareScheduler(partitioner, runtime))
+	testCommonClear(t, NewFairShareScheduler(partitioner, runtime))
+	testCommonNextN(t, NewFairShareScheduler(partitioner, runtime))
+	testCommonEach(t, NewFairShareScheduler(partitioner, runtime))
+	testCommonDrain(t, NewFairShareScheduler(partitioner, runtime), NewFairShareScheduler(partitioner, runtime))
+	testCommonStats(t, NewFairShareScheduler(partitioner, runtime))
+
+	// the first key monopolizes the scheduler while it is alone
+	scheduler := NewFairShareScheduler(partitioner, runtime)
+	scheduler.Put(testTask{1}, testTask{2}, testTask{3})
+	for _, field := range []int{1, 2, 3} {
+		next := scheduler.Next()
+		expectTaskEquals(t, next.Task(), testTask{field})
+		next.Close()
+		next.Close()
+	}
+	if usage := scheduler.Usage("a"); usage != 30 {
+		t.Errorf("expected usage of 30, received %d", usage)
+	}
+
+	// the second key is preferred until its usage catches up, with ties going to
+	// the partition created first
+	scheduler.Put(testTask{4}, testTask{5}, testTask{100}, testTask{101}, testTask{102}, testTask{103})
+	expected := []int{100, 101, 102, 4, 103, 5}
+	peeked := scheduler.PeekN(3)
+	for i, field := range expected[:3] {
+		expectTaskEquals(t, peeked[i], testTask{field})
+	}
+	for _, field := range expected {
+		next := scheduler.Next()
+		expectTaskEquals(t, next.Task(), testTask{field})
+		next.Close()
+	}
+	expectNilTask(t, scheduler.Next())
+
+	// usage is charged on close, so tasks still running do not count against their key
+	scheduler.Put(testTask{6}, testTask{104}, testTask{105})
+	running := scheduler.Next()
+	expectTaskEquals(t, running.Task(), testTask{104})
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{105})
+	running.Close()
+	expectTaskEquals(t, scheduler.Next().Task(), testTask{6})
+
+	// usage is kept when the scheduler is cleared
+	scheduler.Clear()
+	if usage := scheduler.Usage("b"); usage != 50 {
+		t.Errorf("expected usage of 50 kept, received %d", usage)
+	}
 }
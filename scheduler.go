@@ -1,5 +1,11 @@
 package schedule
 
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
 // Task represents an object to be queued.
 type Task interface {
 	Id() string
@@ -14,22 +20,70 @@ type ScheduledTask interface {
 	Close()
 }
 
-// defaultScheduledTask implements a no-op Close()
+// A CompletionNotifier is a ScheduledTask that can run callbacks once it completes.
+// The ScheduledTasks returned by the schedulers of this package implement it.
+type CompletionNotifier interface {
+	// OnComplete registers f to be called the first time the task is closed, after
+	// any other callbacks registered before it. If the task has already been
+	// closed, f is called immediately.
+	OnComplete(f func())
+}
+
+// completion holds the callbacks registered with OnComplete until they are run.
+type completion struct {
+	callbacks []func()
+	completed bool
+}
+
+func (c *completion) OnComplete(f func()) {
+	if c.completed {
+		f()
+		return
+	}
+	c.callbacks = append(c.callbacks, f)
+}
+
+// complete runs the registered callbacks the first time it is called.
+func (c *completion) complete() {
+	if c.completed {
+		return
+	}
+	c.completed = true
+	callbacks := c.callbacks
+	c.callbacks = nil
+	for _, f := range callbacks {
+		f()
+	}
+}
+
+// onComplete registers f with st if it is a CompletionNotifier, for ScheduledTasks
+// that complete along with the ScheduledTask they wrap.
+func onComplete(st ScheduledTask, f func()) {
+	if n, ok := st.(CompletionNotifier); ok {
+		n.OnComplete(f)
+	}
+}
+
+// defaultScheduledTask implements a Close() that only runs its completion callbacks
 type defaultScheduledTask struct {
 	t Task
+	completion
 }
 
 func (d *defaultScheduledTask) Task() Task { return d.t }
 
 func (d *defaultScheduledTask) Id() string { return d.t.Id() }
 
-func (d *defaultScheduledTask) Close() { return }
+func (d *defaultScheduledTask) Close() { d.complete() }
 
 // A Scheduler manages a pool of tasks by returning them in a specified order
 type Scheduler interface {
 	// Contains returns true if and only if the scheduler contains the task
 	Contains(t Task) bool
 
+	// ContainsId returns true if and only if the scheduler contains a task with the given id
+	ContainsId(id string) bool
+
 	// Put inserts each task in to the scheduler. If a task already exists with the id
 	// the task is not replaced and the put is ignored.
 	Put(t ...Task)
@@ -37,51 +91,280 @@ type Scheduler interface {
 	// Next returns the next task wrapped in a ScheduledTask
 	Next() ScheduledTask
 
+	// NextN returns up to n tasks, in the order Next would return them. It
+	// returns fewer than n once Next returns nil.
+	NextN(n int) []ScheduledTask
+
+	// Drain removes and returns all tasks in the order Next would return them,
+	// leaving the scheduler empty.
+	Drain() []Task
+
+	// Each calls f for each task in the order Next would return them without
+	// removing them, stopping early if f returns false.
+	Each(f func(Task) bool)
+
+	// PeekN returns up to n tasks in the order Next would return them without
+	// removing them or acquiring resources for them.
+	PeekN(n int) []Task
+
+	// CopyInto puts the tasks of the scheduler in to dst in the order Next would
+	// return them, without removing them.
+	CopyInto(dst Scheduler)
+
 	// Size returns the number of tasks present in the scheduler.
 	Size() int
 
 	// Remove removes the task with the given id. It returns nil if the scheduler
 	// does not contain a task with that id.
 	Remove(id string) Task
+
+	// Clear removes all tasks from the scheduler and resets its internal state.
+	Clear()
+
+	// Stats returns a snapshot of the scheduler's counters.
+	Stats() SchedulerStats
+}
+
+// A Wrapper is a Scheduler that wraps another, such as a ResourceManagedScheduler,
+// exposing the scheduler it was constructed with for introspection and composition.
+type Wrapper interface {
+	Scheduler
+
+	// Underlying returns the scheduler passed to the wrapper's constructor.
+	Underlying() Scheduler
+}
+
+// nextN calls s.Next up to n times, stopping at the first nil.
+func nextN(s Scheduler, n int) []ScheduledTask {
+	tasks := []ScheduledTask{}
+	for len(tasks) < n {
+		next := s.Next()
+		if next == nil {
+			break
+		}
+		tasks = append(tasks, next)
+	}
+	return tasks
+}
+
+// peekN returns up to the first n tasks visited by s.Each.
+func peekN(s Scheduler, n int) []Task {
+	tasks := []Task{}
+	if n <= 0 {
+		return tasks
+	}
+	s.Each(func(t Task) bool {
+		tasks = append(tasks, t)
+		return len(tasks) < n
+	})
+	return tasks
+}
+
+// copyInto puts the tasks visited by s.Each in to dst in a single call to Put.
+func copyInto(s, dst Scheduler) {
+	dst.Put(collect(s)...)
+}
+
+// drain removes and returns tasks by calling pop until it returns nil. It is only
+// suitable for schedulers whose next task does not depend on resources.
+func drain(pop func() ScheduledTask) []Task {
+	tasks := []Task{}
+	for next := pop(); next != nil; next = pop() {
+		tasks = append(tasks, next.Task())
+		next.Close()
+	}
+	return tasks
 }
 
+// collect returns the tasks of s in the order visited by s.Each.
+func collect(s Scheduler) []Task {
+	tasks := []Task{}
+	s.Each(func(t Task) bool {
+		tasks = append(tasks, t)
+		return true
+	})
+	return tasks
+}
+
+// estimatedWaitMs sums the cost of the tasks visited by s.Each before the task with
+// the given id. It returns -1 if s does not contain a task with that id.
+func estimatedWaitMs(s Scheduler, id string, cost func(Task) int) int {
+	wait, found := 0, false
+	s.Each(func(t Task) bool {
+		if t.Id() == id {
+			found = true
+			return false
+		}
+		wait += cost(t)
+		return true
+	})
+	if !found {
+		return -1
+	}
+	return wait
+}
+
+// visit calls f for each task, stopping early if f returns false. It returns
+// false if stopped early.
+func visit(tasks []Task, f func(Task) bool) bool {
+	for _, t := range tasks {
+		if !f(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// A DuplicatePolicy decides what a scheduler does with a task put while it already
+// holds a task with the same id.
+type DuplicatePolicy int
+
+const (
+	// IgnoreDuplicate keeps the task already held and drops the new one.
+	IgnoreDuplicate DuplicatePolicy = iota
+	// ReplaceDuplicate swaps the new task in to the place of the one already held.
+	ReplaceDuplicate
+)
+
+// A PutOutcome is the result of putting a single task, as reported by PutResult.
+type PutOutcome int
+
+const (
+	// PutAccepted means the task was admitted to the scheduler.
+	PutAccepted PutOutcome = iota
+	// PutDuplicate means the scheduler already held a task with the same id,
+	// whether the new task was ignored or replaced it.
+	PutDuplicate
+	// PutRejected means the task was dropped because the scheduler, or the
+	// partition for the task, was at capacity.
+	PutRejected
+)
+
 // A FifoScheduler is a scheduler that returns tasks in first in, first out (FIFO) order.
 type FifoScheduler struct {
 	elements            []Task
 	elementMap          map[string]struct{}
 	maxUnusedSliceSpace uint8
 	unusedSliceCount    uint8
+	capacity            int
+	duplicates          DuplicatePolicy
+	schedulerStatsRecorder
+}
+
+// A FifoOption configures a FifoScheduler created with NewFifoScheduler.
+type FifoOption func(*FifoScheduler)
+
+// WithReallocThreshold sets the number of slots vacated by puts and removes after
+// which a FifoScheduler reallocates its backing array, 16 by default. Busy queues
+// reallocate less with a higher threshold, while small queues hold less unused
+// memory with a lower one. A threshold of zero is treated as 1.
+func WithReallocThreshold(n uint8) FifoOption {
+	if n == 0 {
+		n = 1
+	}
+	return func(f *FifoScheduler) {
+		f.maxUnusedSliceSpace = n
+	}
 }
 
-func NewFifoScheduler() *FifoScheduler {
-	return &FifoScheduler{
+func NewFifoScheduler(opts ...FifoOption) *FifoScheduler {
+	f := &FifoScheduler{
 		elements:            []Task{},
 		elementMap:          map[string]struct{}{},
 		maxUnusedSliceSpace: 16,
 		unusedSliceCount:    0,
 	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// NewBoundedFifoScheduler returns a FifoScheduler that holds at most capacity tasks.
+// Tasks put while the scheduler is full are dropped. A capacity below 1 is treated as 1.
+func NewBoundedFifoScheduler(capacity int) *FifoScheduler {
+	if capacity < 1 {
+		capacity = 1
+	}
+	f := NewFifoScheduler()
+	f.capacity = capacity
+	return f
+}
+
+// NewFifoSchedulerWithDuplicatePolicy returns a FifoScheduler that handles tasks put
+// with the id of a task it already holds according to the given policy. Replaced
+// tasks keep the position in the queue of the tasks they replace.
+func NewFifoSchedulerWithDuplicatePolicy(policy DuplicatePolicy) *FifoScheduler {
+	f := NewFifoScheduler()
+	f.duplicates = policy
+	return f
 }
 
 func (f *FifoScheduler) Contains(t Task) bool {
-	_, ok := f.elementMap[t.Id()]
+	return f.ContainsId(t.Id())
+}
+
+func (f *FifoScheduler) ContainsId(id string) bool {
+	_, ok := f.elementMap[id]
 	return ok
 }
 
 func (f *FifoScheduler) Put(tasks ...Task) {
+	f.PutN(tasks...)
+}
+
+// PutN behaves like Put and returns the number of tasks admitted, excluding
+// duplicates, whether ignored or replaced, and tasks dropped because the scheduler
+// is at capacity.
+func (f *FifoScheduler) PutN(tasks ...Task) (n int) {
 	for _, t := range tasks {
-		_, ok := f.elementMap[t.Id()]
-		if !ok {
-			f.elements = append(f.elements, t)
-			f.unusedSliceCount++
-			f.elementMap[t.Id()] = struct{}{}
+		if f.put(t) == PutAccepted {
+			n++
 		}
 	}
-	if f.unusedSliceCount >= f.maxUnusedSliceSpace {
-		// reallocate the element slice so there's no memory leak
-		newElements := make([]Task, len(f.elements))
-		copy(newElements, f.elements)
-		f.elements = newElements // reassign so old slice is garbage collected
-		f.unusedSliceCount = 0
+	f.reclaim()
+	f.recordPut(n, len(f.elements))
+	return
+}
+
+// PutResult behaves like Put and returns the outcome of putting each task.
+func (f *FifoScheduler) PutResult(tasks ...Task) []PutOutcome {
+	outcomes := make([]PutOutcome, len(tasks))
+	n := 0
+	for i, t := range tasks {
+		if outcomes[i] = f.put(t); outcomes[i] == PutAccepted {
+			n++
+		}
+	}
+	f.reclaim()
+	f.recordPut(n, len(f.elements))
+	return outcomes
+}
+
+// put puts a single task without reclaiming space or recording stats.
+func (f *FifoScheduler) put(t Task) PutOutcome {
+	if _, ok := f.elementMap[t.Id()]; ok {
+		if f.duplicates == ReplaceDuplicate {
+			f.replace(t)
+		}
+		return PutDuplicate
+	}
+	if f.capacity > 0 && len(f.elements) >= f.capacity {
+		return PutRejected
+	}
+	f.elements = append(f.elements, t)
+	f.unusedSliceCount++
+	f.elementMap[t.Id()] = struct{}{}
+	return PutAccepted
+}
+
+// replace swaps t in to the place of the held task with the same id.
+func (f *FifoScheduler) replace(t Task) {
+	for e := range f.elements {
+		if f.elements[e].Id() == t.Id() {
+			f.elements[e] = t
+			return
+		}
 	}
 }
 
@@ -90,9 +373,39 @@ func (f *FifoScheduler) Next() ScheduledTask {
 		return nil
 	}
 	s := f.elements[0]
+	f.elements[0] = nil // clear the vacated slot so the task can be garbage collected
 	f.elements = f.elements[1:]
 	delete(f.elementMap, s.Id())
-	return &defaultScheduledTask{s}
+	return f.recordNext(&defaultScheduledTask{t: s})
+}
+
+func (f *FifoScheduler) NextN(n int) []ScheduledTask {
+	return nextN(f, n)
+}
+
+func (f *FifoScheduler) PeekN(n int) []Task {
+	return peekN(f, n)
+}
+
+func (f *FifoScheduler) CopyInto(dst Scheduler) {
+	copyInto(f, dst)
+}
+
+func (f *FifoScheduler) Each(fn func(Task) bool) {
+	visit(f.elements, fn)
+}
+
+// EstimatedWaitMs returns the sum of the costs of the tasks ahead of the task with
+// the given id, or -1 if the scheduler does not contain it.
+func (f *FifoScheduler) EstimatedWaitMs(id string, cost func(Task) int) int {
+	return estimatedWaitMs(f, id, cost)
+}
+
+func (f *FifoScheduler) Drain() []Task {
+	tasks := make([]Task, len(f.elements))
+	copy(tasks, f.elements)
+	f.Clear()
+	return tasks
 }
 
 func (f *FifoScheduler) Remove(id string) (t Task) {
@@ -100,17 +413,185 @@ func (f *FifoScheduler) Remove(id string) (t Task) {
 		if f.elements[e].Id() == id {
 			t = f.elements[e]
 			delete(f.elementMap, t.Id())
-			f.elements = append(f.elements[:e], f.elements[e+1:]...)
-			return
+			last := len(f.elements) - 1
+			copy(f.elements[e:], f.elements[e+1:])
+			f.elements[last] = nil
+			f.elements = f.elements[:last]
+			f.unusedSliceCount++
+			f.reclaim()
+			return f.recordRemove(t)
 		}
 	}
 	return nil
 }
 
+// reclaim reallocates the element slice once enough unused slots accumulate
+// so the backing array doesn't grow without bound.
+func (f *FifoScheduler) reclaim() {
+	if f.unusedSliceCount >= f.maxUnusedSliceSpace {
+		// reallocate the element slice so there's no memory leak
+		newElements := make([]Task, len(f.elements))
+		copy(newElements, f.elements)
+		f.elements = newElements // reassign so old slice is garbage collected
+		f.unusedSliceCount = 0
+	}
+}
+
 func (f *FifoScheduler) Size() int {
 	return len(f.elements)
 }
 
+func (f *FifoScheduler) Stats() SchedulerStats {
+	return f.stats(f.Size())
+}
+
+func (f *FifoScheduler) Clear() {
+	for e := range f.elements {
+		f.elements[e] = nil
+	}
+	f.elements = f.elements[:0]
+	for id := range f.elementMap {
+		delete(f.elementMap, id)
+	}
+	f.unusedSliceCount = 0
+}
+
+// A LifoScheduler is a scheduler that returns tasks in last in, first out (LIFO) order.
+type LifoScheduler struct {
+	elements            []Task
+	elementMap          map[string]struct{}
+	maxUnusedSliceSpace uint8
+	unusedSliceCount    uint8
+	schedulerStatsRecorder
+}
+
+func NewLifoScheduler() *LifoScheduler {
+	return &LifoScheduler{
+		elements:            []Task{},
+		elementMap:          map[string]struct{}{},
+		maxUnusedSliceSpace: 16,
+		unusedSliceCount:    0,
+	}
+}
+
+func (l *LifoScheduler) Contains(t Task) bool {
+	return l.ContainsId(t.Id())
+}
+
+func (l *LifoScheduler) ContainsId(id string) bool {
+	_, ok := l.elementMap[id]
+	return ok
+}
+
+func (l *LifoScheduler) Put(tasks ...Task) {
+	n := 0
+	for _, t := range tasks {
+		_, ok := l.elementMap[t.Id()]
+		if !ok {
+			l.elements = append(l.elements, t)
+			l.elementMap[t.Id()] = struct{}{}
+			n++
+		}
+	}
+	l.recordPut(n, len(l.elements))
+}
+
+func (l *LifoScheduler) Next() ScheduledTask {
+	if len(l.elements) == 0 {
+		return nil
+	}
+	last := len(l.elements) - 1
+	s := l.elements[last]
+	l.elements[last] = nil // clear the vacated slot so the task can be garbage collected
+	l.elements = l.elements[:last]
+	delete(l.elementMap, s.Id())
+	l.unusedSliceCount++
+	l.reclaim()
+	return l.recordNext(&defaultScheduledTask{t: s})
+}
+
+func (l *LifoScheduler) NextN(n int) []ScheduledTask {
+	return nextN(l, n)
+}
+
+func (l *LifoScheduler) PeekN(n int) []Task {
+	return peekN(l, n)
+}
+
+func (l *LifoScheduler) CopyInto(dst Scheduler) {
+	copyInto(l, dst)
+}
+
+func (l *LifoScheduler) Each(f func(Task) bool) {
+	for i := len(l.elements) - 1; i >= 0; i-- {
+		if !f(l.elements[i]) {
+			return
+		}
+	}
+}
+
+// EstimatedWaitMs returns the sum of the costs of the tasks ahead of the task with
+// the given id, or -1 if the scheduler does not contain it.
+func (l *LifoScheduler) EstimatedWaitMs(id string, cost func(Task) int) int {
+	return estimatedWaitMs(l, id, cost)
+}
+
+func (l *LifoScheduler) Drain() []Task {
+	tasks := make([]Task, len(l.elements))
+	for i, t := range l.elements {
+		tasks[len(tasks)-1-i] = t
+	}
+	l.Clear()
+	return tasks
+}
+
+func (l *LifoScheduler) Remove(id string) (t Task) {
+	for e := range l.elements {
+		if l.elements[e].Id() == id {
+			t = l.elements[e]
+			delete(l.elementMap, t.Id())
+			last := len(l.elements) - 1
+			copy(l.elements[e:], l.elements[e+1:])
+			l.elements[last] = nil
+			l.elements = l.elements[:last]
+			l.unusedSliceCount++
+			l.reclaim()
+			return l.recordRemove(t)
+		}
+	}
+	return nil
+}
+
+func (l *LifoScheduler) Size() int {
+	return len(l.elements)
+}
+
+func (l *LifoScheduler) Stats() SchedulerStats {
+	return l.stats(l.Size())
+}
+
+func (l *LifoScheduler) Clear() {
+	for e := range l.elements {
+		l.elements[e] = nil
+	}
+	l.elements = l.elements[:0]
+	for id := range l.elementMap {
+		delete(l.elementMap, id)
+	}
+	l.unusedSliceCount = 0
+}
+
+// reclaim reallocates the element slice once enough vacated slots accumulate
+// so a burst of puts doesn't pin a large backing array.
+func (l *LifoScheduler) reclaim() {
+	if l.unusedSliceCount >= l.maxUnusedSliceSpace {
+		newElements := make([]Task, len(l.elements))
+		copy(newElements, l.elements)
+		l.elements = newElements // reassign so old slice is garbage collected
+		l.unusedSliceCount = 0
+	}
+}
+
 type SchedulerFactory func() Scheduler
 
 // A Partitioner is a function that takes a task and returns the partition of
@@ -118,10 +599,28 @@ type SchedulerFactory func() Scheduler
 // to route tasks to their proper schedulers.
 type Partitioner func(t Task) (key string, priority uint, factory SchedulerFactory)
 
+// A PartitionInfo describes a partition of a PartitionedScheduler.
+type PartitionInfo struct {
+	Key      string
+	Priority uint
+	Size     int
+}
+
+// A TieBreaker returns true if partition a should be served before partition b
+// when both have the same priority.
+type TieBreaker func(a, b PartitionInfo) bool
+
+// LargestPartitionFirst is a TieBreaker that serves the partition with the most
+// tasks first.
+func LargestPartitionFirst(a, b PartitionInfo) bool {
+	return a.Size > b.Size
+}
+
 type partition struct {
-	key   string
-	value Scheduler
-	cache map[string]struct{}
+	key        string
+	value      Scheduler
+	cache      map[string]uint
+	lastServed uint64
 }
 type priorityIterator struct {
 	priority   uint
@@ -131,96 +630,524 @@ type priorityIterator struct {
 
 // A PartitionedScheduler partitions tasks into an arbitrary number of Schedulers
 // as defined by the Partitioner and round robins over each partition, starting
-// at the highest priorities first.
+// at the highest priorities first. A partition is discarded once its scheduler is
+// empty, and a new one is created from the factory when its key is next seen.
 type PartitionedScheduler struct {
 	partitioner           Partitioner
 	prioritizedPartitions []*priorityIterator
+	agingRate             float64
+	tieBreaker            TieBreaker
+	affinity              bool
+	maxPartitionSize      int
+	dropped               map[string]int
+	dequeues              uint64
+	index                 map[string]partitionRef
+	softPriority          bool
+	softLevel             uint
+	softTurns             int
+	minService            int
+	schedulerStatsRecorder
+}
+
+// allLevels is the softLevel of a PartitionedScheduler starting a cycle over its
+// priorities from the highest.
+const allLevels = ^uint(0)
+
+// partitionRef locates the partition holding a task by the key and priority it was
+// put with, which find resolves even after the partition has moved under affinity.
+type partitionRef struct {
+	key      string
+	priority uint
 }
 
 func NewPartitionedScheduler(p Partitioner) *PartitionedScheduler {
-	return &PartitionedScheduler{p, []*priorityIterator{}}
+	return &PartitionedScheduler{
+		partitioner:           p,
+		prioritizedPartitions: []*priorityIterator{},
+		dropped:               map[string]int{},
+		index:                 map[string]partitionRef{},
+		softLevel:             allLevels,
+	}
 }
 
-func (p *PartitionedScheduler) Contains(t Task) bool {
-	for _, pi := range p.prioritizedPartitions {
-		for _, part := range pi.partitions {
-			if _, ok := part.cache[t.Id()]; ok {
-				return true
-			}
-		}
+// NewPartitionedSchedulerWithAging returns a PartitionedScheduler that ages partitions
+// to prevent starvation. Rather than strictly serving the highest priority first, Next()
+// serves the partition with the highest effective priority: its priority plus rate
+// times the number of tasks returned since the partition last returned one. Ties are
+// served as they would be without aging.
+func NewPartitionedSchedulerWithAging(p Partitioner, rate float64) *PartitionedScheduler {
+	s := NewPartitionedScheduler(p)
+	s.agingRate = rate
+	return s
+}
+
+// NewPartitionedSchedulerWithTieBreaker returns a PartitionedScheduler that serves
+// the partitions of the same priority in the order given by tb rather than round
+// robinning over them. Partitions that tb does not order are round robinned.
+func NewPartitionedSchedulerWithTieBreaker(p Partitioner, tb TieBreaker) *PartitionedScheduler {
+	s := NewPartitionedScheduler(p)
+	s.tieBreaker = tb
+	return s
+}
+
+// NewPartitionedSchedulerWithAffinity returns a PartitionedScheduler whose partitions
+// are identified by key alone, so tasks with the same key share a scheduler whatever
+// their priority. Each partition is served at the highest priority of the tasks it holds.
+func NewPartitionedSchedulerWithAffinity(p Partitioner) *PartitionedScheduler {
+	s := NewPartitionedScheduler(p)
+	s.affinity = true
+	return s
+}
+
+// NewPartitionedSchedulerWithSoftPriority returns a PartitionedScheduler whose
+// priorities bias rather than strictly order service, so lower priorities are never
+// starved. Next() cycles over the priorities from the highest, round robinning over
+// the partitions of each for as many turns as its priority, so a priority of 2 is
+// served twice as often as a priority of 1. A priority of 0 gets a single turn, as
+// does a priority of 1. Priorities with no task to return are skipped. Aging and
+// tie breaking take precedence over soft priorities.
+func NewPartitionedSchedulerWithSoftPriority(p Partitioner) *PartitionedScheduler {
+	s := NewPartitionedScheduler(p)
+	s.softPriority = true
+	return s
+}
+
+// NewPartitionedSchedulerWithMinService returns a PartitionedScheduler guaranteeing
+// every partition at least one task in each n returned by Next(), as for modeling
+// service level agreements. A partition that has gone n-1 tasks without being served
+// is served next whatever its priority, the longest waiting first, falling back to
+// the usual order if it has no task to return. A partition is considered served when
+// it is created.
+func NewPartitionedSchedulerWithMinService(p Partitioner, n int) *PartitionedScheduler {
+	s := NewPartitionedScheduler(p)
+	s.minService = n
+	return s
+}
+
+// NewPartitionedSchedulerWithPartitionCap returns a PartitionedScheduler whose
+// partitions each hold at most max tasks. Tasks put in to a partition at its cap
+// are dropped and counted by Dropped().
+func NewPartitionedSchedulerWithPartitionCap(p Partitioner, max int) *PartitionedScheduler {
+	s := NewPartitionedScheduler(p)
+	s.maxPartitionSize = max
+	return s
+}
+
+// Dropped returns the number of tasks dropped for each key because its partition
+// was at its cap.
+func (p *PartitionedScheduler) Dropped() map[string]int {
+	dropped := make(map[string]int, len(p.dropped))
+	for key, n := range p.dropped {
+		dropped[key] = n
 	}
-	return false
+	return dropped
+}
+
+func (p *PartitionedScheduler) Contains(t Task) bool {
+	return p.ContainsId(t.Id())
+}
+
+func (p *PartitionedScheduler) ContainsId(id string) bool {
+	_, ok := p.index[id]
+	return ok
 }
 
 func (p *PartitionedScheduler) Put(tasks ...Task) {
+	before := p.Size()
 	for _, t := range tasks {
-		if p.Contains(t) {
+		p.put(t, false)
+	}
+	p.recordPut(p.Size()-before, p.Size())
+}
+
+// PutResult behaves like Put and returns the outcome of putting each task. Tasks
+// dropped because their partition is at its cap are rejected.
+func (p *PartitionedScheduler) PutResult(tasks ...Task) []PutOutcome {
+	before := p.Size()
+	outcomes := make([]PutOutcome, len(tasks))
+	for i, t := range tasks {
+		outcomes[i], _ = p.put(t, false)
+	}
+	p.recordPut(p.Size()-before, p.Size())
+	return outcomes
+}
+
+// PutErr behaves like Put but also checks that the factory of each task creates
+// the same type of scheduler as the partition for its key, or if there is none,
+// the other partitions at its priority. It stops at the first task that fails
+// the check and returns an error without putting it or any of the tasks after
+// it. The check creates a scheduler from the factory of each task put in to a
+// priority that already has partitions.
+func (p *PartitionedScheduler) PutErr(tasks ...Task) (err error) {
+	before := p.Size()
+	for _, t := range tasks {
+		if _, err = p.put(t, true); err != nil {
+			break
+		}
+	}
+	p.recordPut(p.Size()-before, p.Size())
+	return
+}
+
+func (p *PartitionedScheduler) put(t Task, check bool) (PutOutcome, error) {
+	if p.Contains(t) {
+		return PutDuplicate, nil
+	}
+	key, pri, fact := p.partitioner(t)
+	iter, idx := p.find(key, pri)
+	if iter == nil {
+		iter = p.priorityIterator(pri)
+	}
+	if check && len(iter.partitions) > 0 {
+		existing := iter.partitions[0].value
+		if idx != -1 {
+			existing = iter.partitions[idx].value
+		}
+		if requested := fact(); reflect.TypeOf(requested) != reflect.TypeOf(existing) {
+			return PutRejected, fmt.Errorf("schedule: task %s requested a %T for partition %q at priority %d, which uses a %T",
+				t.Id(), requested, key, pri, existing)
+		}
+	}
+	if idx != -1 && p.maxPartitionSize > 0 && iter.partitions[idx].value.Size() >= p.maxPartitionSize {
+		p.dropped[key]++
+		return PutRejected, nil
+	}
+	if idx == -1 {
+		iter.partitions = append(iter.partitions, partition{key, fact(), map[string]uint{}, p.dequeues})
+		idx = len(iter.partitions) - 1
+	}
+	iter.partitions[idx].cache[t.Id()] = pri
+	p.index[t.Id()] = partitionRef{key, pri}
+	iter.partitions[idx].value.Put(t)
+	if pri > iter.priority {
+		p.move(iter, idx, pri)
+	}
+	return PutAccepted, nil
+}
+
+// find returns the partition with the given key, looking at the given priority, or at
+// every priority with affinity, without moving the round robin position. It returns a
+// nil iterator if there is no iterator for the priority and an index of -1 if there is
+// no partition with the key.
+func (p *PartitionedScheduler) find(key string, pri uint) (*priorityIterator, int) {
+	var iter *priorityIterator
+	for _, pi := range p.prioritizedPartitions {
+		if !p.affinity && pi.priority != pri {
 			continue
 		}
-		key, pri, fact := p.partitioner(t)
-		var iter *priorityIterator
-		for i, pi := range p.prioritizedPartitions {
-			if pi.priority == pri {
-				iter = pi
-				break
-			} else if pi.priority < pri {
-				newIter := &priorityIterator{pri, []partition{}, 0}
-				p.prioritizedPartitions = append(p.prioritizedPartitions[:i], append([]*priorityIterator{newIter}, p.prioritizedPartitions[i:]...)...)
-				iter = newIter
-				break
+		for i := range pi.partitions {
+			if pi.partitions[i].key == key {
+				return pi, i
 			}
 		}
-		if iter == nil {
-			newIter := &priorityIterator{pri, []partition{}, 0}
-			p.prioritizedPartitions = append(p.prioritizedPartitions, newIter)
-			iter = newIter
+		if pi.priority == pri {
+			iter = pi
+		}
+	}
+	return iter, -1
+}
+
+// priorityIterator returns the iterator for the given priority, inserting a new one
+// if necessary so that prioritizedPartitions stays sorted by descending priority.
+func (p *PartitionedScheduler) priorityIterator(pri uint) *priorityIterator {
+	i := 0
+	for ; i < len(p.prioritizedPartitions); i++ {
+		if p.prioritizedPartitions[i].priority == pri {
+			return p.prioritizedPartitions[i]
+		} else if p.prioritizedPartitions[i].priority < pri {
+			break
+		}
+	}
+	iter := &priorityIterator{pri, []partition{}, 0}
+	p.prioritizedPartitions = append(p.prioritizedPartitions, nil)
+	copy(p.prioritizedPartitions[i+1:], p.prioritizedPartitions[i:])
+	p.prioritizedPartitions[i] = iter
+	return iter
+}
+
+func (p *PartitionedScheduler) Next() (t ScheduledTask) {
+	if p.minService > 0 {
+		if t = p.nextOverdue(); t != nil {
+			return p.recordNext(t)
+		}
+	}
+	if p.agingRate > 0 || p.tieBreaker != nil {
+		return p.recordNext(p.nextSorted())
+	}
+	if p.softPriority {
+		return p.recordNext(p.nextSoft())
+	}
+	for _, pi := range p.prioritizedPartitions {
+		if t = p.nextAt(pi); t != nil {
+			return p.recordNext(t)
 		}
+	}
+	return
+}
 
-		idx := -1
-		for i := 0; i < len(iter.partitions); i++ {
-			iter.pos = (iter.pos + 1) % len(iter.partitions)
-			if iter.partitions[iter.pos].key == key {
-				idx = iter.pos
-				break
+// nextOverdue returns the next task from the partition that has gone longest
+// without being served, if it has gone long enough that the minimum service
+// guarantee requires serving it now. Ties are broken by priority and then round
+// robin order.
+func (p *PartitionedScheduler) nextOverdue() ScheduledTask {
+	var overdue *priorityIterator
+	idx := -1
+	for _, pi := range p.prioritizedPartitions {
+		for i := range pi.partitions {
+			j := (pi.pos + i) % len(pi.partitions)
+			waited := p.dequeues - pi.partitions[j].lastServed
+			if waited+1 < uint64(p.minService) {
+				continue
+			}
+			if idx == -1 || pi.partitions[j].lastServed < overdue.partitions[idx].lastServed {
+				overdue, idx = pi, j
 			}
 		}
-		if idx == -1 {
-			iter.partitions = append(iter.partitions, partition{key, fact(), map[string]struct{}{}})
-			iter.pos = len(iter.partitions) - 1
+	}
+	if idx == -1 {
+		return nil
+	}
+	t := overdue.partitions[idx].value.Next()
+	if t != nil {
+		p.emitted(overdue, idx, t)
+	}
+	return t
+}
+
+// nextAt returns the next task from the partitions of pi in round robin order, or
+// nil if none of them returns one.
+func (p *PartitionedScheduler) nextAt(pi *priorityIterator) ScheduledTask {
+	for i := 0; i < len(pi.partitions); i++ {
+		idx := (pi.pos + i) % len(pi.partitions)
+		if t := pi.partitions[idx].value.Next(); t != nil {
+			p.emitted(pi, idx, t)
+			return t
 		}
-		iter.partitions[iter.pos].cache[t.Id()] = struct{}{}
-		iter.partitions[iter.pos].value.Put(t)
 	}
+	return nil
 }
 
-func (p *PartitionedScheduler) Next() (t ScheduledTask) {
+// nextSoft returns the next task of the priority being served in the cycle over
+// priorities, moving on to the next lower priority once it has had its turns or has
+// no task to return.
+func (p *PartitionedScheduler) nextSoft() ScheduledTask {
+	levels := p.prioritizedPartitions
+	start := 0
+	for start < len(levels) && levels[start].priority > p.softLevel {
+		start++
+	}
+	if start == len(levels) {
+		start = 0
+	}
+	for i := 0; i < len(levels); i++ {
+		pi := levels[(start+i)%len(levels)]
+		if pi.priority != p.softLevel {
+			p.softLevel, p.softTurns = pi.priority, 0
+		}
+		pri := pi.priority
+		t := p.nextAt(pi)
+		if t == nil {
+			continue
+		}
+		p.softTurns++
+		if turns := int(pri); p.softTurns >= turns {
+			p.softLevel, p.softTurns = allLevels, 0
+			if pri > 0 {
+				p.softLevel = pri - 1
+			}
+		}
+		return t
+	}
+	return nil
+}
+
+// nextSorted returns the next task from the partition with the highest effective
+// priority, breaking ties with the tie breaker and then the round robin order.
+func (p *PartitionedScheduler) nextSorted() ScheduledTask {
+	type candidate struct {
+		pi        *priorityIterator
+		idx       int
+		effective float64
+		info      PartitionInfo
+	}
+	candidates := []candidate{}
 	for _, pi := range p.prioritizedPartitions {
-		for i := 0; i < len(pi.partitions); i++ {
+		for i := range pi.partitions {
 			idx := (pi.pos + i) % len(pi.partitions)
-			t = pi.partitions[idx].value.Next()
-			if t != nil {
-				delete(pi.partitions[idx].cache, t.Task().Id())
-				pi.pos = (pi.pos + i + 1) % len(pi.partitions)
-				return
-			}
+			part := pi.partitions[idx]
+			waited := p.dequeues - part.lastServed
+			info := PartitionInfo{part.key, pi.priority, part.value.Size()}
+			candidates = append(candidates, candidate{pi, idx, float64(pi.priority) + p.agingRate*float64(waited), info})
 		}
 	}
-	return
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.effective != b.effective || a.info.Priority != b.info.Priority || p.tieBreaker == nil {
+			return a.effective > b.effective
+		}
+		return p.tieBreaker(a.info, b.info)
+	})
+	for _, c := range candidates {
+		if t := c.pi.partitions[c.idx].value.Next(); t != nil {
+			p.emitted(c.pi, c.idx, t)
+			return t
+		}
+	}
+	return nil
 }
 
-func (p *PartitionedScheduler) Remove(id string) (t Task) {
-	for _, pri := range p.prioritizedPartitions {
-		for _, prt := range pri.partitions {
-			t = prt.value.Remove(id)
-			if t != nil {
-				delete(prt.cache, id)
-				return
+// emitted records that the partition at idx returned t, moving the round robin
+// position past it.
+func (p *PartitionedScheduler) emitted(pi *priorityIterator, idx int, t ScheduledTask) {
+	delete(pi.partitions[idx].cache, t.Task().Id())
+	delete(p.index, t.Task().Id())
+	p.dequeues++
+	pi.partitions[idx].lastServed = p.dequeues
+	pi.pos = (idx + 1) % len(pi.partitions)
+	p.prune(pi, idx)
+}
+
+func (p *PartitionedScheduler) NextN(n int) []ScheduledTask {
+	return nextN(p, n)
+}
+
+func (p *PartitionedScheduler) PeekN(n int) []Task {
+	return peekN(p, n)
+}
+
+func (p *PartitionedScheduler) CopyInto(dst Scheduler) {
+	copyInto(p, dst)
+}
+
+// Drain drains each partition and orders their tasks as Next would. The partitions
+// are drained rather than emitted from, so resources are not requested for their tasks.
+// Dropped tasks are still counted.
+func (p *PartitionedScheduler) Drain() []Task {
+	tasks := drain(p.shadow(Scheduler.Drain).Next)
+	dropped := p.dropped
+	p.Clear()
+	p.dropped = dropped
+	return tasks
+}
+
+// Each visits the tasks of each partition in the order Next would return them.
+func (p *PartitionedScheduler) Each(f func(Task) bool) {
+	visit(drain(p.shadow(collect).Next), f)
+}
+
+// EstimatedWaitMs returns the sum of the costs of the tasks returned by Next ahead of
+// the task with the given id, or -1 if the scheduler does not contain it.
+func (p *PartitionedScheduler) EstimatedWaitMs(id string, cost func(Task) int) int {
+	return estimatedWaitMs(p, id, cost)
+}
+
+// shadow returns a copy of the scheduler whose partitions are FifoSchedulers holding
+// the tasks taken from each partition using take, so the order of Next can be
+// replayed without emitting from the partitions themselves.
+func (p *PartitionedScheduler) shadow(take func(Scheduler) []Task) *PartitionedScheduler {
+	s := NewPartitionedScheduler(p.partitioner)
+	s.agingRate, s.tieBreaker, s.affinity, s.dequeues = p.agingRate, p.tieBreaker, p.affinity, p.dequeues
+	s.softPriority, s.softLevel, s.softTurns = p.softPriority, p.softLevel, p.softTurns
+	s.minService = p.minService
+	for _, pi := range p.prioritizedPartitions {
+		spi := &priorityIterator{pi.priority, make([]partition, len(pi.partitions)), pi.pos}
+		for i, part := range pi.partitions {
+			f := NewFifoScheduler()
+			f.Put(take(part.value)...)
+			cache := make(map[string]uint, len(part.cache))
+			for id, pri := range part.cache {
+				cache[id] = pri
 			}
+			spi.partitions[i] = partition{part.key, f, cache, part.lastServed}
 		}
+		s.prioritizedPartitions = append(s.prioritizedPartitions, spi)
+	}
+	for id, ref := range p.index {
+		s.index[id] = ref
+	}
+	return s
+}
+
+// Remove removes the task with the given id. A partition left empty is discarded
+// without disturbing the round robin order of the others at its priority.
+func (p *PartitionedScheduler) Remove(id string) (t Task) {
+	ref, ok := p.index[id]
+	if !ok {
+		return
+	}
+	pi, idx := p.find(ref.key, ref.priority)
+	if idx == -1 {
+		return
+	}
+	if t = pi.partitions[idx].value.Remove(id); t != nil {
+		delete(pi.partitions[idx].cache, id)
+		delete(p.index, id)
+		p.prune(pi, idx)
+		return p.recordRemove(t)
 	}
 	return
 }
 
+// prune removes the partition at idx from the iterator if its scheduler is empty.
+// With affinity, a partition left holding only tasks of lower priority is moved to
+// the highest of their priorities.
+func (p *PartitionedScheduler) prune(pi *priorityIterator, idx int) {
+	if pi.partitions[idx].value.Size() == 0 {
+		p.detach(pi, idx)
+		return
+	}
+	if !p.affinity {
+		return
+	}
+	var highest uint
+	for _, pri := range pi.partitions[idx].cache {
+		if pri > highest {
+			highest = pri
+		}
+	}
+	if highest < pi.priority {
+		p.move(pi, idx, highest)
+	}
+}
+
+// move moves the partition at idx to the iterator of the given priority.
+func (p *PartitionedScheduler) move(pi *priorityIterator, idx int, pri uint) {
+	part := pi.partitions[idx]
+	p.detach(pi, idx)
+	iter := p.priorityIterator(pri)
+	iter.partitions = append(iter.partitions, part)
+}
+
+// detach removes the partition at idx from the iterator, keeping the round robin
+// position on the partition that would have been served next. The iterator itself
+// is removed once it holds no partitions. Since partitions put in to a priority
+// without an iterator start a new one at the first of them, a priority that empties
+// and refills serves the first refilled partition first.
+func (p *PartitionedScheduler) detach(pi *priorityIterator, idx int) {
+	last := len(pi.partitions) - 1
+	copy(pi.partitions[idx:], pi.partitions[idx+1:])
+	pi.partitions[last] = partition{}
+	pi.partitions = pi.partitions[:last]
+	if idx < pi.pos {
+		pi.pos--
+	}
+	if pi.pos >= len(pi.partitions) {
+		pi.pos = 0
+	}
+	if len(pi.partitions) > 0 {
+		return
+	}
+	for i := range p.prioritizedPartitions {
+		if p.prioritizedPartitions[i] == pi {
+			last = len(p.prioritizedPartitions) - 1
+			copy(p.prioritizedPartitions[i:], p.prioritizedPartitions[i+1:])
+			p.prioritizedPartitions[last] = nil
+			p.prioritizedPartitions = p.prioritizedPartitions[:last]
+			return
+		}
+	}
+}
+
 func (p *PartitionedScheduler) Size() (size int) {
 	for _, pri := range p.prioritizedPartitions {
 		for _, prt := range pri.partitions {
@@ -230,22 +1157,130 @@ func (p *PartitionedScheduler) Size() (size int) {
 	return
 }
 
-// resourceTask is a ScheduledTask that attaches a task to the resource that
-// has been granted to it. Upon completion, Close() returns the resource
-// back to the pool.
+// PartitionSizes returns the size of each partition by key. The sizes of partitions
+// with the same key at different priorities are summed.
+func (p *PartitionedScheduler) PartitionSizes() map[string]int {
+	sizes := map[string]int{}
+	for _, pi := range p.prioritizedPartitions {
+		for _, part := range pi.partitions {
+			sizes[part.key] += part.value.Size()
+		}
+	}
+	return sizes
+}
+
+// A PartitionView is a read-only snapshot of a partition of a PartitionedScheduler,
+// as returned by Partitions().
+type PartitionView struct {
+	info PartitionInfo
+}
+
+// Key returns the key of the partition.
+func (v PartitionView) Key() string { return v.info.Key }
+
+// Priority returns the priority the partition is served at.
+func (v PartitionView) Priority() uint { return v.info.Priority }
+
+// Size returns the number of tasks the partition held when the snapshot was taken.
+func (v PartitionView) Size() int { return v.info.Size }
+
+// Partitions returns a view of each partition in descending order of priority, and
+// within a priority in the round robin order Next() would serve them in.
+func (p *PartitionedScheduler) Partitions() []PartitionView {
+	views := []PartitionView{}
+	for _, pi := range p.prioritizedPartitions {
+		for i := range pi.partitions {
+			part := pi.partitions[(pi.pos+i)%len(pi.partitions)]
+			views = append(views, PartitionView{PartitionInfo{part.key, pi.priority, part.value.Size()}})
+		}
+	}
+	return views
+}
+
+// StarvationReport returns, for the key of each partition with pending tasks, the
+// number of tasks returned from Next() since that partition last returned one, or
+// since it was created if it never has. For a key with partitions at different
+// priorities, the partition served most recently is reported.
+func (p *PartitionedScheduler) StarvationReport() map[string]int {
+	report := map[string]int{}
+	for _, pi := range p.prioritizedPartitions {
+		for _, part := range pi.partitions {
+			waited := int(p.dequeues - part.lastServed)
+			if prev, ok := report[part.key]; !ok || waited < prev {
+				report[part.key] = waited
+			}
+		}
+	}
+	return report
+}
+
+func (p *PartitionedScheduler) Stats() SchedulerStats {
+	return p.stats(p.Size())
+}
+
+// Clear removes all tasks, resets the round robin and aging state and forgets dropped tasks.
+func (p *PartitionedScheduler) Clear() {
+	for i := range p.prioritizedPartitions {
+		p.prioritizedPartitions[i] = nil
+	}
+	p.prioritizedPartitions = p.prioritizedPartitions[:0]
+	p.dropped = map[string]int{}
+	p.dequeues = 0
+	p.index = map[string]partitionRef{}
+	p.softLevel, p.softTurns = allLevels, 0
+}
+
+// resourceTask is a ScheduledTask that attaches a scheduled task to the resource
+// that has been granted to it. Upon completion, Close() returns the resource
+// back to the pool and closes the wrapped task.
 type resourceTask struct {
-	// TODO(tshprecher): make this wrap a ScheduledTask for proper chaining of Close()
-	t        Task
-	resource Resource
+	st        ScheduledTask
+	resource  Resource
+	scheduler *ResourceManagedScheduler
+	returned  bool
+	cancelled bool
+	completion
+}
+
+func newResourceTask(st ScheduledTask, allocated Resource) *resourceTask {
+	return &resourceTask{st: st, resource: allocated}
 }
 
-func (r *resourceTask) Task() Task { return r.t }
+func (r *resourceTask) Task() Task { return r.st.Task() }
 
-func (r *resourceTask) Id() string { return r.t.Id() }
+func (r *resourceTask) Id() string { return r.st.Id() }
 
-// Close returns the resource associated with this ScheduledTask
+// Cancelled returns true if the task was cancelled with ResourceManagedScheduler.Cancel().
+func (r *resourceTask) Cancelled() bool { return r.cancelled }
+
+// Close closes the ScheduledTask it wraps and returns the resource associated with
+// this ScheduledTask, unless it has already been returned. Completion callbacks run
+// once both are done.
 func (r *resourceTask) Close() {
+	r.returnResource()
+	r.st.Close()
+	r.complete()
+}
+
+// returnResource returns the resource the first time it is called, and no longer
+// tracks the task in the scheduler it was emitted from.
+func (r *resourceTask) returnResource() bool {
+	if r.returned {
+		return false
+	}
+	r.returned = true
 	r.resource.Return()
+	if r.scheduler != nil && r.scheduler.running[r.Id()] == r {
+		delete(r.scheduler.running, r.Id())
+	}
+	return true
+}
+
+func (r *resourceTask) release(res []int) bool {
+	if v, ok := r.resource.(*resourceVector); ok {
+		return v.release(res)
+	}
+	return releasePartial(r.st, res)
 }
 
 // A ResourceCalculator takes a task and returns the resource necessary
@@ -253,62 +1288,308 @@ func (r *resourceTask) Close() {
 // can be used to grant one via a call to ResourcePool.Request().
 type ResourceCalculator func(Task) Resource
 
+// An AdaptiveResourceCalculator is a ResourceCalculator that also takes the resources
+// currently available in the pool, so it can adapt its request to them, such as
+// requesting fewer units when the pool is nearly empty.
+type AdaptiveResourceCalculator func(t Task, available []int) Resource
+
+// NewDispatchingResourceCalculator returns a ResourceCalculator for mixed workloads
+// that calculates the resource of each task with the calculator registered in calcs
+// under its type key, as returned by key, or with fallback if none is registered.
+func NewDispatchingResourceCalculator(key func(Task) string, calcs map[string]ResourceCalculator, fallback ResourceCalculator) ResourceCalculator {
+	return func(t Task) Resource {
+		if calc, ok := calcs[key(t)]; ok {
+			return calc(t)
+		}
+		return fallback(t)
+	}
+}
+
 // A ResourceManagedScheduler returns the next task iff a resource exists
 // to run it. If the necessary resource exists in the resource pool, the resource
 // is requested from the pool and cleared when task.Close() is called.
+//
+// Tasks taken from the underlying scheduler that cannot be granted a resource are
+// held in a bounded waiting queue. Each call to Next() attempts to grant each waiting
+// task in order before taking more from the underlying scheduler, so a task needing
+// fewer resources can be scheduled ahead of a more expensive one.
+//
+// Tasks requesting more than the capacity of a pool created with NewResourceVectorPool
+// can never be scheduled. Rather than waiting on them forever, they are rejected and
+// reported by Rejected(). A rejected task never ran, so the ScheduledTask it was
+// emitted from the underlying scheduler with is not closed.
+//
+// Scheduled tasks are tracked until they are closed, so a running task can be
+// cancelled with Cancel() to return its resource before it completes.
 type ResourceManagedScheduler struct {
-	waiting            Task
+	waiting            []ScheduledTask
+	rejected           []Task
+	running            map[string]*resourceTask
+	maxWaiting         int
 	underlying         Scheduler
 	pool               ResourcePool
 	resourceCalculator ResourceCalculator
+	schedulerStatsRecorder
 }
 
+// NewResourceManagedScheduler returns a ResourceManagedScheduler that holds at most
+// one waiting task, so tasks are granted resources in the order of the underlying
+// scheduler.
 func NewResourceManagedScheduler(underlying Scheduler, pool ResourcePool, calc ResourceCalculator) *ResourceManagedScheduler {
-	return &ResourceManagedScheduler{nil, underlying, pool, calc}
+	return NewResourceManagedSchedulerWithQueue(underlying, pool, calc, 1)
+}
+
+// NewResourceManagedSchedulerWithQueue returns a ResourceManagedScheduler that holds
+// up to maxWaiting tasks waiting on resources.
+func NewResourceManagedSchedulerWithQueue(underlying Scheduler, pool ResourcePool, calc ResourceCalculator, maxWaiting int) *ResourceManagedScheduler {
+	if maxWaiting < 1 {
+		maxWaiting = 1
+	}
+	return &ResourceManagedScheduler{[]ScheduledTask{}, []Task{}, map[string]*resourceTask{}, maxWaiting, underlying, pool, calc, schedulerStatsRecorder{}}
+}
+
+// NewAdaptiveResourceManagedScheduler returns a ResourceManagedScheduler that calls
+// calc with the resources available in the pool each time it requests resources
+// for a task.
+func NewAdaptiveResourceManagedScheduler(underlying Scheduler, pool ResourceVectorPool, calc AdaptiveResourceCalculator) *ResourceManagedScheduler {
+	return NewResourceManagedScheduler(underlying, pool, func(t Task) Resource {
+		return calc(t, pool.Available())
+	})
+}
+
+// NewMultiPoolResourceManagedScheduler returns a ResourceManagedScheduler that grants
+// each task a resource from every pool, requested with the calculator at the same
+// index, such as CPU from one pool and a database connection from another. A task
+// is granted only if every pool grants its request. The pools are reserved from in
+// the order given and committed to only once all have succeeded, so a task waiting
+// on resources never holds any of them and tasks can't deadlock on each other's
+// partial grants. A calculator returning nil requests nothing from its pool. Closing
+// the task returns all of its resources. It panics if the number of pools and
+// calculators differ.
+func NewMultiPoolResourceManagedScheduler(underlying Scheduler, pools []ResourcePool, calcs []ResourceCalculator) *ResourceManagedScheduler {
+	if len(pools) != len(calcs) {
+		panic(fmt.Sprintf("schedule: %d pools given %d resource calculators", len(pools), len(calcs)))
+	}
+	set := &poolSet{append([]ResourcePool{}, pools...)}
+	calcs = append([]ResourceCalculator{}, calcs...)
+	return NewResourceManagedScheduler(underlying, set, func(t Task) Resource {
+		bundle := &resourceBundle{make([]Resource, len(calcs))}
+		for i, calc := range calcs {
+			bundle.resources[i] = calc(t)
+		}
+		return bundle
+	})
 }
 
 func (r *ResourceManagedScheduler) Contains(t Task) bool {
-	return (r.waiting != nil && r.waiting.Id() == t.Id()) || r.underlying.Contains(t)
+	return r.ContainsId(t.Id())
+}
+
+// ContainsId returns true if a task with the given id is waiting on resources or
+// held by the underlying scheduler.
+func (r *ResourceManagedScheduler) ContainsId(id string) bool {
+	for _, w := range r.waiting {
+		if w.Id() == id {
+			return true
+		}
+	}
+	return r.underlying.ContainsId(id)
 }
 
 func (r *ResourceManagedScheduler) Put(tasks ...Task) {
+	before := r.Size()
 	r.underlying.Put(tasks...)
+	r.recordPut(r.Size()-before, r.Size())
 }
 
 func (r *ResourceManagedScheduler) Next() ScheduledTask {
-	if r.waiting != nil {
-		needed := r.resourceCalculator(r.waiting)
-		allocated := r.pool.Request(needed)
-		if allocated == nil {
+	for i, w := range r.waiting {
+		allocated := r.pool.Request(r.resourceCalculator(w.Task()))
+		if allocated != nil {
+			r.removeWaiting(i)
+			return r.recordNext(r.grant(w, allocated))
+		}
+	}
+	for len(r.waiting) < r.maxWaiting {
+		next := r.underlying.Next()
+		if next == nil {
 			return nil
 		}
-		task := &resourceTask{r.waiting, allocated}
-		r.waiting = nil
-		return task
+		requested := r.resourceCalculator(next.Task())
+		allocated := r.pool.Request(requested)
+		if allocated != nil {
+			return r.recordNext(r.grant(next, allocated))
+		}
+		if exceedsCapacity(r.pool, requested) {
+			r.rejected = append(r.rejected, next.Task())
+			returnEarly(next)
+			continue
+		}
+		r.waiting = append(r.waiting, next)
 	}
-	next := r.underlying.Next()
-	if next == nil {
-		return nil
+	return nil
+}
+
+// grant attaches the resource allocated to st and tracks it until it is closed.
+func (r *ResourceManagedScheduler) grant(st ScheduledTask, allocated Resource) *resourceTask {
+	granted := newResourceTask(st, allocated)
+	granted.scheduler = r
+	r.running[st.Id()] = granted
+	return granted
+}
+
+func (r *ResourceManagedScheduler) NextN(n int) []ScheduledTask {
+	return nextN(r, n)
+}
+
+func (r *ResourceManagedScheduler) PeekN(n int) []Task {
+	return peekN(r, n)
+}
+
+func (r *ResourceManagedScheduler) CopyInto(dst Scheduler) {
+	copyInto(r, dst)
+}
+
+func (r *ResourceManagedScheduler) Underlying() Scheduler {
+	return r.underlying
+}
+
+// Cancel returns the resource held by the running task with the given id to the
+// pool without waiting for the task to be closed, and marks it cancelled. Closing
+// the task later closes the ScheduledTask it wraps but returns nothing. Cancel
+// returns false if no task with that id is running.
+func (r *ResourceManagedScheduler) Cancel(id string) bool {
+	running, ok := r.running[id]
+	if !ok {
+		return false
 	}
-	needed := r.resourceCalculator(next.Task())
-	allocated := r.pool.Request(needed)
-	if allocated == nil {
-		r.waiting = next.Task()
-		return nil
+	running.cancelled = true
+	return running.returnResource()
+}
+
+// A Requeuer is a Scheduler that can take back a task it returned from Next() that
+// could not be run, such as for retries, releasing what the ScheduledTask holds.
+type Requeuer interface {
+	Requeue(st ScheduledTask)
+}
+
+// Requeue puts the task of st, as returned from Next(), back in to the underlying
+// scheduler. The resource held by st is returned to the pool unless it already has
+// been, as by Cancel(). Neither st nor the ScheduledTask it wraps is closed, since
+// the task never ran, so an underlying scheduler such as a DependencyScheduler does
+// not see it complete, and the callbacks registered with st are not run. st must
+// not be used after it is requeued.
+func (r *ResourceManagedScheduler) Requeue(st ScheduledTask) {
+	if running, ok := st.(*resourceTask); ok {
+		running.returnResource()
+	} else {
+		returnEarly(st)
 	}
-	return &resourceTask{next.Task(), allocated}
+	r.Put(st.Task())
 }
 
+// Each visits the tasks waiting on resources followed by the tasks of the
+// underlying scheduler.
+func (r *ResourceManagedScheduler) Each(f func(Task) bool) {
+	for _, w := range r.waiting {
+		if !f(w.Task()) {
+			return
+		}
+	}
+	r.underlying.Each(f)
+}
+
+// Drain returns the tasks waiting on resources followed by the tasks of the
+// underlying scheduler without requesting any resources. The waiting tasks are
+// dropped without being closed, as with Remove.
+func (r *ResourceManagedScheduler) Drain() []Task {
+	tasks := []Task{}
+	for i, w := range r.waiting {
+		tasks = append(tasks, w.Task())
+		returnEarly(w)
+		r.waiting[i] = nil
+	}
+	r.waiting = r.waiting[:0]
+	return append(tasks, r.underlying.Drain()...)
+}
+
+// Rejected returns the tasks removed from the scheduler because they request more
+// than the capacity of the pool, in the order they were rejected.
+func (r *ResourceManagedScheduler) Rejected() []Task {
+	rejected := make([]Task, len(r.rejected))
+	copy(rejected, r.rejected)
+	return rejected
+}
+
+// Waiting returns the number of tasks taken from the underlying scheduler
+// that are waiting on resources.
+func (r *ResourceManagedScheduler) Waiting() int {
+	return len(r.waiting)
+}
+
+// Remove removes the task with the given id. If it is waiting on resources, the
+// ScheduledTask it was emitted from the underlying scheduler with is dropped without
+// being closed, as the task never ran, returning any resource it holds.
 func (r *ResourceManagedScheduler) Remove(id string) Task {
-	if r.waiting != nil && r.waiting.Id() == id {
-		return r.waiting
+	for i, w := range r.waiting {
+		if w.Id() == id {
+			r.removeWaiting(i)
+			returnEarly(w)
+			return r.recordRemove(w.Task())
+		}
 	}
-	return r.underlying.Remove(id)
+	return r.recordRemove(r.underlying.Remove(id))
+}
+
+// RemoveScheduled removes the task with the given id and returns it as a
+// ScheduledTask for the caller to Close. A running task is no longer tracked by the
+// scheduler, so it can't be cancelled, and closing the returned task returns its
+// resource to the pool. A task still waiting on resources or queued in the underlying
+// scheduler is removed as by Remove, but is returned unclosed. It returns nil if no
+// task with that id is running or queued.
+func (r *ResourceManagedScheduler) RemoveScheduled(id string) ScheduledTask {
+	if running, ok := r.running[id]; ok {
+		delete(r.running, id)
+		return running
+	}
+	for i, w := range r.waiting {
+		if w.Id() == id {
+			r.removeWaiting(i)
+			r.recordRemove(w.Task())
+			return w
+		}
+	}
+	if t := r.recordRemove(r.underlying.Remove(id)); t != nil {
+		return &defaultScheduledTask{t: t}
+	}
+	return nil
+}
+
+func (r *ResourceManagedScheduler) removeWaiting(i int) {
+	last := len(r.waiting) - 1
+	copy(r.waiting[i:], r.waiting[i+1:])
+	r.waiting[last] = nil
+	r.waiting = r.waiting[:last]
 }
 
 func (r *ResourceManagedScheduler) Size() int {
-	if r.waiting == nil {
-		return r.underlying.Size()
+	return len(r.waiting) + r.underlying.Size()
+}
+
+func (r *ResourceManagedScheduler) Stats() SchedulerStats {
+	return r.stats(r.Size())
+}
+
+// Clear removes all pending tasks, including those waiting on resources, which are
+// dropped without being closed as with Remove, and forgets rejected tasks. Resources
+// already granted to scheduled tasks are unaffected and are still returned to the
+// pool when those tasks are closed.
+func (r *ResourceManagedScheduler) Clear() {
+	for i, w := range r.waiting {
+		returnEarly(w)
+		r.waiting[i] = nil
 	}
-	return 1 + r.underlying.Size()
+	r.waiting = r.waiting[:0]
+	r.rejected = []Task{}
+	r.underlying.Clear()
 }
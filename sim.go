@@ -2,59 +2,382 @@ package schedule
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"sort"
 	"strconv"
+	"time"
 )
 
+// A SimTask is a task to be simulated. It is registered with RegisterTaskType
+// as "SimTask" so schedulers holding SimTasks can be marshaled with MarshalTasks.
 type SimTask struct {
-	Identifier int
-	UserId     int
-	RuntimeMs  int
+	Identifier int `json:"id"`
+	UserId     int `json:"user_id"`
+	// RuntimeMs is how long the task runs once started. A task with a RuntimeMs
+	// that is not positive completes as soon as it starts.
+	RuntimeMs int `json:"runtime_ms"`
+	ArrivalMs int `json:"arrival_ms"`
+	// DeadlineMs is the clock time by which the task should complete.
+	// Zero means the task has no deadline.
+	DeadlineMs int `json:"deadline_ms,omitempty"`
+	// Priority is available to partitioners and priority schedulers.
+	// It does not affect the simulation itself.
+	Priority int `json:"priority,omitempty"`
+	// Releases holds the resources the task returns before it completes.
+	Releases []SimRelease `json:"releases,omitempty"`
+	// HoldMs is how long the task holds its resource after it starts. The resource
+	// is returned after HoldMs while the task runs on for the rest of RuntimeMs.
+	// Zero means the task holds its resource for all of RuntimeMs.
+	HoldMs int `json:"hold_ms,omitempty"`
+}
+
+// A SimRelease returns part of the resources granted to a running SimTask to the
+// pool AtMs milliseconds after the task starts. Resources are released only from
+// pools created with NewResourceVectorPool, and never more than the task holds.
+type SimRelease struct {
+	AtMs      int   `json:"at_ms"`
+	Resources []int `json:"resources"`
+}
+
+// pendingRelease is a SimRelease of a running task due at a clock time. Nil
+// resources return all of the task's resource, as at the end of its HoldMs.
+type pendingRelease struct {
+	atMs      int
+	task      ScheduledTask
+	resources []int
+}
+
+func init() {
+	RegisterTaskType("SimTask", func() Task { return &SimTask{} })
 }
 
 func (s *SimTask) Id() string {
 	return strconv.Itoa(s.Identifier)
 }
 
+// AsSimTask returns t as a *SimTask, and false if it is not one.
+func AsSimTask(t Task) (*SimTask, bool) {
+	st, ok := t.(*SimTask)
+	return st, ok && st != nil
+}
+
+// MustSimTask returns t as a *SimTask, as for partitioners of SimTasks. It panics
+// with a message naming the task if t is not one.
+func MustSimTask(t Task) *SimTask {
+	st, ok := AsSimTask(t)
+	if !ok {
+		if _, isNil := t.(*SimTask); t == nil || isNil {
+			panic("schedule: expected a *SimTask, received a nil task")
+		}
+		panic(fmt.Sprintf("schedule: expected a *SimTask, received a %T with id %s", t, t.Id()))
+	}
+	return st
+}
+
+// A UserResult holds the simulated results of a single user's tasks.
+type UserResult struct {
+	UserId int
+	// ClockTimeMs is the time at which the user's last task completed.
+	ClockTimeMs int
+	// Throughput is the number of the user's tasks completed per second of clock time.
+	// It is zero if they all completed at time zero.
+	Throughput float32
+	// LatenciesMs holds the time from arrival to completion of each of the
+	// user's tasks, sorted in ascending order.
+	LatenciesMs []int
+	// DeadlineMisses is the number of the user's tasks completed after their deadline.
+	DeadlineMisses int
+	// WindowedCompletions holds the number of the user's tasks completed in each
+	// window of the width given by WithThroughputWindow, from time zero through the
+	// window of the user's last completion. It is nil without that option.
+	WindowedCompletions []int
+}
+
+// A SimResult holds the results of a simulation for each user, ordered by user id.
+type SimResult struct {
+	Users []UserResult
+	// Fairness is Jain's fairness index over the throughput of each user. It is
+	// 1 when every user has equal throughput and approaches 1/n as a single user
+	// of n dominates.
+	Fairness float32
+	// Gini is the Gini coefficient of the throughput of each user. It is 0 when
+	// every user has equal throughput and approaches 1 - 1/n as a single user of n
+	// dominates. It is only computed with WithGini, and is zero without it.
+	Gini float32
+	// Utilization is the average fraction of each resource of the pool given to
+	// WithUtilization that was granted over the simulation. It is nil without that
+	// option.
+	Utilization []float32
+	// Stuck holds the tasks left in the scheduler when the simulation stopped
+	// because the scheduler could not return them and no task was running to
+	// free up the resources they wait on.
+	Stuck []*SimTask
+}
+
+// A SimOption configures a simulation.
+type SimOption func(*simConfig)
+
+type simConfig struct {
+	windowMs int
+	gini     bool
+	usage    ResourceVectorPool
+	clock    Clock
+	pools    []ExpiringPool
+	onTick   func(runningCount int, clockMs int)
+}
+
+// A Clock drives the time of a simulation in milliseconds. The simulation
+// measures time from the first call to Now and calls Sleep to wait for the next
+// arrival, release or completion.
+type Clock interface {
+	Now() int
+	Sleep(ms int)
+}
+
+// virtualClock is the default Clock, which advances immediately when slept.
+type virtualClock struct {
+	nowMs int
+}
+
+func (c *virtualClock) Now() int { return c.nowMs }
+
+func (c *virtualClock) Sleep(ms int) { c.nowMs += ms }
+
+// A RealTimeClock is a Clock that follows wall clock time, so a simulation driven
+// by it takes as long as the tasks it simulates, as for live demonstrations.
+type RealTimeClock struct {
+	start time.Time
+}
+
+func NewRealTimeClock() *RealTimeClock {
+	return &RealTimeClock{time.Now()}
+}
+
+// Now returns the milliseconds elapsed since the clock was created.
+func (c *RealTimeClock) Now() int {
+	return int(time.Since(c.start) / time.Millisecond)
+}
+
+func (c *RealTimeClock) Sleep(ms int) {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+}
+
+// WithExpiringPool advances the clock of pool along with the simulation, so grants
+// leased from it expire at the right time and tasks waiting on their resources
+// proceed without the tasks holding them completing. It may be given more than once.
+func WithExpiringPool(pool ExpiringPool) SimOption {
+	return func(c *simConfig) {
+		c.pools = append(c.pools, pool)
+	}
+}
+
+// WithClock drives the simulation with the given clock rather than a virtual
+// clock that advances without waiting.
+func WithClock(clock Clock) SimOption {
+	return func(c *simConfig) {
+		c.clock = clock
+	}
+}
+
+// WithThroughputWindow buckets the completions of each user into windows of
+// widthMs milliseconds, reported in UserResult.WindowedCompletions.
+func WithThroughputWindow(widthMs int) SimOption {
+	return func(c *simConfig) {
+		c.windowMs = widthMs
+	}
+}
+
+// WithGini computes the Gini coefficient of the throughput of each user, reported
+// in SimResult.Gini, as an alternative measure of fairness to Jain's index.
+func WithGini() SimOption {
+	return func(c *simConfig) {
+		c.gini = true
+	}
+}
+
+// WithUtilization integrates the resources granted from pool over the clock time of
+// the simulation, reported in SimResult.Utilization as the fraction of its capacity
+// granted on average. The pool is sampled between events, after tasks are started.
+func WithUtilization(pool ResourceVectorPool) SimOption {
+	return func(c *simConfig) {
+		c.usage = pool
+	}
+}
+
+// WithOnTick calls onTick each time the simulation clock advances, with the number
+// of tasks that were running as it reached clockMs, before those finishing then are
+// completed, as for reporting live progress. It does not affect the scheduling.
+func WithOnTick(onTick func(runningCount int, clockMs int)) SimOption {
+	return func(c *simConfig) {
+		c.onTick = onTick
+	}
+}
+
 // Simulate takes a scheduler and a slice of SimTasks, simulates
 // the runtime of those tasks as they are removed from the scheduler,
 // and prints latency results to standard output.
-func Simulate(scheduler Scheduler, tasks []*SimTask) {
-	for _, t := range tasks {
-		scheduler.Put(t)
+func Simulate(scheduler Scheduler, tasks []*SimTask, opts ...SimOption) {
+	SimulateTo(os.Stdout, scheduler, tasks, opts...)
+}
+
+// SimulateTo is like Simulate but writes the latency results to w.
+func SimulateTo(w io.Writer, scheduler Scheduler, tasks []*SimTask, opts ...SimOption) {
+	config := newSimConfig(opts)
+	result := SimulateResult(scheduler, tasks, opts...)
+	for _, user := range result.Users {
+		fmt.Fprintf(w, "\t\tuser %d:\n", user.UserId)
+		fmt.Fprintf(w, "\t\t\tclock time:\t\t\t %d ms\n", user.ClockTimeMs)
+		fmt.Fprintf(w, "\t\t\tthroughput (tasks / sec):\t %f\n", user.Throughput)
+		fmt.Fprintf(w, "\t\t\tlatency p50:\t\t\t %d ms\n", percentile(user.LatenciesMs, 50))
+		fmt.Fprintf(w, "\t\t\tlatency p95:\t\t\t %d ms\n", percentile(user.LatenciesMs, 95))
+		fmt.Fprintf(w, "\t\t\tlatency p99:\t\t\t %d ms\n", percentile(user.LatenciesMs, 99))
+		fmt.Fprintf(w, "\t\t\tdeadline misses:\t\t %d\n", user.DeadlineMisses)
+		if user.WindowedCompletions != nil {
+			fmt.Fprintf(w, "\t\t\tcompletions per %d ms:\t\t %v\n", config.windowMs, user.WindowedCompletions)
+		}
+	}
+	fmt.Fprintf(w, "\t\tfairness index:\t\t\t\t %f\n", result.Fairness)
+	if config.gini {
+		fmt.Fprintf(w, "\t\tgini coefficient:\t\t\t %f\n", result.Gini)
 	}
+	if result.Utilization != nil {
+		fmt.Fprintf(w, "\t\tutilization:\t\t\t\t %v\n", result.Utilization)
+	}
+	if len(result.Stuck) > 0 {
+		fmt.Fprintf(w, "\t\tstuck tasks:\t\t\t\t %d\n", len(result.Stuck))
+	}
+}
+
+// SimulateResult takes a scheduler and a slice of SimTasks, simulates
+// the runtime of those tasks as they are removed from the scheduler,
+// and returns the results. Each task is put in to the scheduler once
+// the simulation clock reaches its arrival time, and its latency is
+// measured from arrival to completion.
+func SimulateResult(scheduler Scheduler, tasks []*SimTask, opts ...SimOption) SimResult {
+	config := newSimConfig(opts)
+	pending := make([]*SimTask, len(tasks))
+	copy(pending, tasks)
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].ArrivalMs < pending[j].ArrivalMs
+	})
+	startMs := config.clock.Now()
 	currentTimeMs := 0
 	endtimesPerUser := make(map[int][]int)
 	taskLatencyPerUser := make(map[int][]int)
+	deadlineMissesPerUser := make(map[int]int)
 	runningTasks := map[ScheduledTask]int{}
-	for scheduler.Size() > 0 || len(runningTasks) > 0 {
+	releases := []pendingRelease{}
+	var busyMs []int
+	if config.usage != nil {
+		busyMs = make([]int, len(config.usage.Capacity()))
+	}
+	var stuck []*SimTask
+	for len(pending) > 0 || scheduler.Size() > 0 || len(runningTasks) > 0 {
+		for _, pool := range config.pools {
+			pool.Advance(currentTimeMs)
+		}
+		for len(pending) > 0 && pending[0].ArrivalMs <= currentTimeMs {
+			scheduler.Put(pending[0])
+			pending = pending[1:]
+		}
 		if scheduler.Size() > 0 {
 			for nextTask := scheduler.Next(); nextTask != nil; nextTask = scheduler.Next() {
 				st := nextTask.Task().(*SimTask)
-				runningTasks[nextTask] = currentTimeMs + st.RuntimeMs
+				runtimeMs := st.RuntimeMs
+				if runtimeMs < 0 {
+					runtimeMs = 0
+				}
+				runningTasks[nextTask] = currentTimeMs + runtimeMs
+				for _, rel := range st.Releases {
+					if rel.AtMs < runtimeMs {
+						releases = append(releases, pendingRelease{currentTimeMs + rel.AtMs, nextTask, rel.Resources})
+					}
+				}
+				if st.HoldMs > 0 && st.HoldMs < runtimeMs {
+					releases = append(releases, pendingRelease{currentTimeMs + st.HoldMs, nextTask, nil})
+				}
 			}
 		}
-		if len(runningTasks) > 0 {
-			// simulate completion of shortest task
-			earliestCompTimeMs := -1
-			earliestCompTimeTasks := []ScheduledTask{}
-			for ta, tm := range runningTasks {
-				if earliestCompTimeMs == -1 || tm < earliestCompTimeMs {
-					earliestCompTimeMs = tm
-					earliestCompTimeTasks = nil
-				}
-				if tm == earliestCompTimeMs {
-					earliestCompTimeTasks = append(earliestCompTimeTasks, ta)
+
+		// nothing can free up resources for the tasks left in the scheduler
+		expiring := false
+		for _, pool := range config.pools {
+			if _, ok := pool.NextExpiry(); ok {
+				expiring = true
+			}
+		}
+		if len(pending) == 0 && len(runningTasks) == 0 && !expiring && scheduler.Size() > 0 {
+			scheduler.Each(func(t Task) bool {
+				stuck = append(stuck, t.(*SimTask))
+				return true
+			})
+			break
+		}
+
+		// advance the clock to the next completion, release, expiry or arrival, whichever comes first
+		nextTimeMs := -1
+		for _, pool := range config.pools {
+			if ms, ok := pool.NextExpiry(); ok && (nextTimeMs == -1 || ms < nextTimeMs) {
+				nextTimeMs = ms
+			}
+		}
+		for _, tm := range runningTasks {
+			if nextTimeMs == -1 || tm < nextTimeMs {
+				nextTimeMs = tm
+			}
+		}
+		for _, rel := range releases {
+			if nextTimeMs == -1 || rel.atMs < nextTimeMs {
+				nextTimeMs = rel.atMs
+			}
+		}
+		if len(pending) > 0 && (nextTimeMs == -1 || pending[0].ArrivalMs < nextTimeMs) {
+			nextTimeMs = pending[0].ArrivalMs
+		}
+		if nextTimeMs > currentTimeMs {
+			var granted []int
+			if config.usage != nil {
+				granted = config.usage.Capacity()
+				for i, available := range config.usage.Available() {
+					granted[i] -= available
 				}
 			}
-			if len(earliestCompTimeTasks) > 0 {
-				currentTimeMs += earliestCompTimeTasks[0].Task().(*SimTask).RuntimeMs
-				for i := range earliestCompTimeTasks {
-					st := earliestCompTimeTasks[i].Task().(*SimTask)
-					endtimesPerUser[st.UserId] = append(endtimesPerUser[st.UserId], earliestCompTimeMs)
-					taskLatencyPerUser[st.UserId] = append(taskLatencyPerUser[st.UserId], currentTimeMs)
-					earliestCompTimeTasks[i].Close()
-					delete(runningTasks, earliestCompTimeTasks[i])
+			config.clock.Sleep(nextTimeMs - currentTimeMs)
+			elapsedMs := config.clock.Now() - startMs - currentTimeMs
+			currentTimeMs += elapsedMs
+			for i := range granted {
+				busyMs[i] += granted[i] * elapsedMs
+			}
+			if config.onTick != nil {
+				config.onTick(len(runningTasks), currentTimeMs)
+			}
+		}
+
+		// release the resources due at the current time from tasks still running
+		remaining := releases[:0]
+		for _, rel := range releases {
+			if rel.atMs > currentTimeMs {
+				remaining = append(remaining, rel)
+			} else if rel.resources == nil {
+				returnEarly(rel.task)
+			} else {
+				releasePartial(rel.task, rel.resources)
+			}
+		}
+		releases = remaining
+
+		// simulate completion of the tasks finishing at the current time
+		for ta, tm := range runningTasks {
+			if tm <= currentTimeMs {
+				st := ta.Task().(*SimTask)
+				endtimesPerUser[st.UserId] = append(endtimesPerUser[st.UserId], tm)
+				taskLatencyPerUser[st.UserId] = append(taskLatencyPerUser[st.UserId], tm-st.ArrivalMs)
+				if st.DeadlineMs > 0 && tm > st.DeadlineMs {
+					deadlineMissesPerUser[st.UserId]++
 				}
+				ta.Close()
+				delete(runningTasks, ta)
 			}
 		}
 	}
@@ -68,10 +391,118 @@ func Simulate(scheduler Scheduler, tasks []*SimTask) {
 		}
 	}
 
+	result := SimResult{Stuck: stuck}
 	for _, id := range userIds {
 		et := endtimesPerUser[id]
-		fmt.Printf("\t\tuser %d:\n", id)
-		fmt.Printf("\t\t\tclock time:\t\t\t %d ms\n", et[len(et)-1])
-		fmt.Printf("\t\t\tthroughput (tasks / sec):\t %f\n", float32(len(et))/float32(et[len(et)-1])*1000)
+		latencies := taskLatencyPerUser[id]
+		sort.Ints(latencies)
+		result.Users = append(result.Users, UserResult{
+			UserId:              id,
+			ClockTimeMs:         et[len(et)-1],
+			Throughput:          throughput(len(et), et[len(et)-1]),
+			LatenciesMs:         latencies,
+			DeadlineMisses:      deadlineMissesPerUser[id],
+			WindowedCompletions: windowed(et, config.windowMs),
+		})
+	}
+	result.Fairness = fairness(result.Users)
+	if config.usage != nil {
+		result.Utilization = utilization(busyMs, config.usage.Capacity(), currentTimeMs)
+	}
+	if config.gini {
+		result.Gini = gini(result.Users)
+	}
+	return result
+}
+
+func newSimConfig(opts []SimOption) simConfig {
+	config := simConfig{clock: &virtualClock{}}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return config
+}
+
+// windowed counts the completion times falling in each window of widthMs, through
+// the window of the last completion. It returns nil if widthMs is not positive.
+func windowed(endtimes []int, widthMs int) []int {
+	if widthMs <= 0 {
+		return nil
+	}
+	counts := []int{}
+	for _, tm := range endtimes {
+		for len(counts) <= tm/widthMs {
+			counts = append(counts, 0)
+		}
+		counts[tm/widthMs]++
+	}
+	return counts
+}
+
+// fairness returns Jain's fairness index over the throughput of each user, or
+// zero if there are no users.
+func fairness(users []UserResult) float32 {
+	var sum, sumSquares float64
+	for _, user := range users {
+		sum += float64(user.Throughput)
+		sumSquares += float64(user.Throughput) * float64(user.Throughput)
+	}
+	if sumSquares == 0 {
+		return 0
+	}
+	return float32(sum * sum / (float64(len(users)) * sumSquares))
+}
+
+// throughput returns the number of completions per second of clock time, or zero
+// if no clock time has passed.
+func throughput(completions, clockTimeMs int) float32 {
+	if clockTimeMs <= 0 {
+		return 0
+	}
+	return float32(completions) / float32(clockTimeMs) * 1000
+}
+
+// utilization returns the fraction of each capacity used on average, given the
+// integral of the resources granted over totalMs. A resource with no capacity, or a
+// simulation taking no time, has a utilization of zero.
+func utilization(busyMs, capacity []int, totalMs int) []float32 {
+	used := make([]float32, len(capacity))
+	for i := range capacity {
+		if capacity[i] > 0 && totalMs > 0 {
+			used[i] = float32(busyMs[i]) / float32(capacity[i]*totalMs)
+		}
+	}
+	return used
+}
+
+// gini returns the Gini coefficient over the throughput of each user, or zero if
+// there are no users or none has any throughput.
+func gini(users []UserResult) float32 {
+	throughputs := make([]float64, len(users))
+	for i, user := range users {
+		throughputs[i] = float64(user.Throughput)
+	}
+	sort.Float64s(throughputs)
+	var sum, weighted float64
+	for i, tp := range throughputs {
+		sum += tp
+		weighted += float64(i+1) * tp
+	}
+	if sum == 0 {
+		return 0
+	}
+	n := float64(len(throughputs))
+	return float32(2*weighted/(n*sum) - (n+1)/n)
+}
+
+// percentile returns the pth percentile of the sorted values using the nearest-rank method.
+func percentile(sorted []int, p int) int {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
 	}
+	return sorted[rank-1]
 }
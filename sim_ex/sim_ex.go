@@ -8,18 +8,16 @@ import (
 
 // userPartitioner partitions over user ids into FIFO schedulers, all with the same priority level.
 func userPartitioner(t schedule.Task) (key string, priority uint, factory schedule.SchedulerFactory) {
-	st := t.(*schedule.SimTask)
+	st := schedule.MustSimTask(t)
 	key = strconv.Itoa(st.UserId)
 	priority = 0
-	factory = func() schedule.Scheduler {
-		return schedule.NewFifoScheduler()
-	}
+	factory, _ = schedule.FactoryByName("fifo")
 	return
 }
 
 // timeAndUserPartitioner partitions tasks into fast and slow lanes, with each lane partitioned with userParitioner.
 func timeAndUserPartitioner(t schedule.Task) (key string, priority uint, factory schedule.SchedulerFactory) {
-	st := t.(*schedule.SimTask)
+	st := schedule.MustSimTask(t)
 	key = "fast"
 	if st.RuntimeMs >= 50 {
 		key = "slow"
@@ -0,0 +1,550 @@
+package schedule
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// captureSimulate runs SimulateTo and returns what it writes.
+func captureSimulate(t *testing.T, scheduler Scheduler, tasks []*SimTask, opts ...SimOption) string {
+	var buf bytes.Buffer
+	SimulateTo(&buf, scheduler, tasks, opts...)
+	return buf.String()
+}
+
+func expectOutputContains(t *testing.T, out, expected string) {
+	if !strings.Contains(out, expected) {
+		t.Errorf("expected output to contain %q, received %q", expected, out)
+	}
+}
+
+func TestSimulateArrival(t *testing.T) {
+	// a task arriving at 100ms starts no earlier than 100ms on an idle scheduler
+	out := captureSimulate(t, NewFifoScheduler(), []*SimTask{
+		{Identifier: 1, UserId: 1, RuntimeMs: 10, ArrivalMs: 100},
+	})
+	expectOutputContains(t, out, "clock time:\t\t\t 110 ms")
+
+	// the clock advances to the next arrival once the running tasks complete
+	calc := func(_ Task) Resource { return NewResourceVectorRequest([]int{1}) }
+	out = captureSimulate(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{1}), calc), []*SimTask{
+		{Identifier: 1, UserId: 1, RuntimeMs: 10, ArrivalMs: 0},
+		{Identifier: 2, UserId: 2, RuntimeMs: 10, ArrivalMs: 100},
+		{Identifier: 3, UserId: 1, RuntimeMs: 10, ArrivalMs: 5},
+	})
+	expectOutputContains(t, out, "user 1:\n\t\t\tclock time:\t\t\t 20 ms")
+	expectOutputContains(t, out, "user 2:\n\t\t\tclock time:\t\t\t 110 ms")
+
+	// a task arriving while resources are held waits for them to free up
+	out = captureSimulate(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{1}), calc), []*SimTask{
+		{Identifier: 1, UserId: 1, RuntimeMs: 50, ArrivalMs: 0},
+		{Identifier: 2, UserId: 2, RuntimeMs: 10, ArrivalMs: 20},
+	})
+	expectOutputContains(t, out, "user 2:\n\t\t\tclock time:\t\t\t 60 ms")
+}
+
+func TestSimulateTo(t *testing.T) {
+	var buf bytes.Buffer
+	SimulateTo(&buf, NewFifoScheduler(), []*SimTask{{Identifier: 1, UserId: 1, RuntimeMs: 10}})
+	expectOutputContains(t, buf.String(), "user 1:")
+	expectOutputContains(t, buf.String(), "clock time:\t\t\t 10 ms")
+}
+
+func TestSimulateResult(t *testing.T) {
+	calc := func(_ Task) Resource { return NewResourceVectorRequest([]int{1}) }
+	tasks := []*SimTask{}
+	for i := 1; i <= 10; i++ {
+		tasks = append(tasks, &SimTask{Identifier: i, UserId: 1, RuntimeMs: i})
+	}
+	result := SimulateResult(NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{1}), calc), tasks)
+	if len(result.Users) != 1 {
+		t.Fatalf("expected results for 1 user, received %d", len(result.Users))
+	}
+	user := result.Users[0]
+	if user.UserId != 1 {
+		t.Errorf("expected user id 1, received %d", user.UserId)
+	}
+	if user.ClockTimeMs != 55 {
+		t.Errorf("expected clock time 55, received %d", user.ClockTimeMs)
+	}
+	if expected := float32(10) / float32(55) * 1000; user.Throughput != expected {
+		t.Errorf("expected throughput %f, received %f", expected, user.Throughput)
+	}
+	expected := []int{1, 3, 6, 10, 15, 21, 28, 36, 45, 55}
+	if len(user.LatenciesMs) != len(expected) {
+		t.Fatalf("expected %d latencies, received %d", len(expected), len(user.LatenciesMs))
+	}
+	for i := range expected {
+		if user.LatenciesMs[i] != expected[i] {
+			t.Errorf("expected latency %d at %d, received %d", expected[i], i, user.LatenciesMs[i])
+		}
+	}
+}
+
+func TestSimulateDeadlineMisses(t *testing.T) {
+	calc := func(_ Task) Resource { return NewResourceVectorRequest([]int{1}) }
+	tasks := func() []*SimTask {
+		return []*SimTask{
+			{Identifier: 1, UserId: 1, RuntimeMs: 50, DeadlineMs: 100},
+			{Identifier: 2, UserId: 1, RuntimeMs: 10, DeadlineMs: 20},
+			{Identifier: 3, UserId: 1, RuntimeMs: 10, DeadlineMs: 30},
+			{Identifier: 4, UserId: 1, RuntimeMs: 10},
+		}
+	}
+
+	// under FIFO the long first task pushes the next two past their deadlines
+	result := SimulateResult(NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{1}), calc), tasks())
+	if misses := result.Users[0].DeadlineMisses; misses != 2 {
+		t.Errorf("expected 2 deadline misses under FIFO, received %d", misses)
+	}
+	out := captureSimulate(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{1}), calc), tasks())
+	expectOutputContains(t, out, "deadline misses:\t\t 2\n")
+
+	// earliest deadline first meets every deadline
+	edf := NewEarliestDeadlineScheduler(func(t Task) int {
+		if d := t.(*SimTask).DeadlineMs; d > 0 {
+			return d
+		}
+		return math.MaxInt32
+	})
+	result = SimulateResult(NewResourceManagedScheduler(edf, NewResourceVectorPool([]int{1}), calc), tasks())
+	if misses := result.Users[0].DeadlineMisses; misses != 0 {
+		t.Errorf("expected no deadline misses under EDF, received %d", misses)
+	}
+}
+
+func TestSimulateFairness(t *testing.T) {
+	// equal throughput is perfectly fair
+	result := SimulateResult(NewFifoScheduler(), []*SimTask{
+		{Identifier: 1, UserId: 1, RuntimeMs: 10},
+		{Identifier: 2, UserId: 2, RuntimeMs: 10},
+	})
+	if result.Fairness != 1 {
+		t.Errorf("expected fairness 1, received %f", result.Fairness)
+	}
+
+	// user 1 completes 10 tasks in 10ms while user 2 completes 1 task in 1s
+	tasks := []*SimTask{{Identifier: 100, UserId: 2, RuntimeMs: 1000}}
+	for i := 1; i <= 10; i++ {
+		tasks = append(tasks, &SimTask{Identifier: i, UserId: 1, RuntimeMs: 10})
+	}
+	result = SimulateResult(NewFifoScheduler(), tasks)
+	if result.Fairness < 0.5 || result.Fairness > 0.51 {
+		t.Errorf("expected fairness near 0.5, received %f", result.Fairness)
+	}
+	out := captureSimulate(t, NewFifoScheduler(), tasks)
+	expectOutputContains(t, out, "fairness index:\t\t\t\t 0.50")
+
+	if fairness(nil) != 0 {
+		t.Error("expected zero fairness with no users")
+	}
+}
+
+func TestSimulateGini(t *testing.T) {
+	// equal throughput is perfectly equal
+	balanced := []*SimTask{
+		{Identifier: 1, UserId: 1, RuntimeMs: 10},
+		{Identifier: 2, UserId: 2, RuntimeMs: 10},
+	}
+	result := SimulateResult(NewFifoScheduler(), balanced, WithGini())
+	if result.Gini != 0 {
+		t.Errorf("expected a gini coefficient of 0, received %f", result.Gini)
+	}
+
+	// user 1 completes 10 tasks in 10ms while user 2 completes 1 task in 1s, so user 1
+	// has 1000 times the throughput and the coefficient nears its maximum of 1/2
+	skewed := []*SimTask{{Identifier: 100, UserId: 2, RuntimeMs: 1000}}
+	for i := 1; i <= 10; i++ {
+		skewed = append(skewed, &SimTask{Identifier: i, UserId: 1, RuntimeMs: 10})
+	}
+	result = SimulateResult(NewFifoScheduler(), skewed, WithGini())
+	if result.Gini < 0.49 || result.Gini > 0.5 {
+		t.Errorf("expected a gini coefficient near 0.5, received %f", result.Gini)
+	}
+	out := captureSimulate(t, NewFifoScheduler(), skewed, WithGini())
+	expectOutputContains(t, out, "gini coefficient:\t\t\t 0.49")
+
+	// without the option the coefficient is neither computed nor reported
+	if result = SimulateResult(NewFifoScheduler(), skewed); result.Gini != 0 {
+		t.Errorf("expected no gini coefficient, received %f", result.Gini)
+	}
+	if out = captureSimulate(t, NewFifoScheduler(), skewed); strings.Contains(out, "gini") {
+		t.Errorf("expected no gini coefficient in output, received %q", out)
+	}
+
+	if gini(nil) != 0 {
+		t.Error("expected a zero gini coefficient with no users")
+	}
+}
+
+func TestSimulateUtilization(t *testing.T) {
+	calc := func(t Task) Resource { return NewResourceVectorRequest([]int{1, t.(*SimTask).UserId}) }
+	tasks := func() []*SimTask {
+		return []*SimTask{
+			{Identifier: 1, UserId: 1, RuntimeMs: 50},
+			{Identifier: 2, UserId: 1, RuntimeMs: 50},
+			{Identifier: 3, UserId: 1, RuntimeMs: 30, ArrivalMs: 10},
+		}
+	}
+
+	// the first resource is always fully granted and the second half granted
+	pool := NewResourceVectorPool([]int{1, 2})
+	result := SimulateResult(NewResourceManagedScheduler(NewFifoScheduler(), pool, calc), tasks(), WithUtilization(pool))
+	if fmt.Sprint(result.Utilization) != "[1 0.5]" {
+		t.Errorf("expected utilization [1 0.5], received %v", result.Utilization)
+	}
+	out := captureSimulate(t, NewResourceManagedScheduler(NewFifoScheduler(), pool, calc), tasks(), WithUtilization(pool))
+	expectOutputContains(t, out, "utilization:\t\t\t\t [1 0.5]\n")
+
+	// idle time between arrivals counts against utilization
+	pool = NewResourceVectorPool([]int{1, 2})
+	idle := []*SimTask{{Identifier: 1, UserId: 2, RuntimeMs: 25}, {Identifier: 2, UserId: 2, RuntimeMs: 25, ArrivalMs: 75}}
+	result = SimulateResult(NewResourceManagedScheduler(NewFifoScheduler(), pool, calc), idle, WithUtilization(pool))
+	if fmt.Sprint(result.Utilization) != "[0.5 0.5]" {
+		t.Errorf("expected utilization [0.5 0.5], received %v", result.Utilization)
+	}
+
+	// without the option utilization is neither measured nor reported
+	if result = SimulateResult(NewFifoScheduler(), tasks()); result.Utilization != nil {
+		t.Errorf("expected no utilization, received %v", result.Utilization)
+	}
+	if out = captureSimulate(t, NewFifoScheduler(), tasks()); strings.Contains(out, "utilization") {
+		t.Errorf("expected no utilization in output, received %q", out)
+	}
+
+	if used := utilization([]int{10}, []int{0}, 10); used[0] != 0 {
+		t.Errorf("expected no utilization of an empty resource, received %f", used[0])
+	}
+}
+
+func TestAsSimTask(t *testing.T) {
+	task := &SimTask{Identifier: 1}
+	if st, ok := AsSimTask(task); !ok || st != task {
+		t.Errorf("expected the SimTask back, received %v", st)
+	}
+	for _, other := range []Task{testTask{1}, nil, (*SimTask)(nil)} {
+		if st, ok := AsSimTask(other); ok || st != nil {
+			t.Errorf("expected %v not to be a SimTask, received %v", other, st)
+		}
+	}
+}
+
+func TestMustSimTask(t *testing.T) {
+	task := &SimTask{Identifier: 1}
+	if st := MustSimTask(task); st != task {
+		t.Errorf("expected the SimTask back, received %v", st)
+	}
+
+	expectPanic := func(other Task, expected string) {
+		defer func() {
+			if r := recover(); r != expected {
+				t.Errorf("expected panic %q, received %v", expected, r)
+			}
+		}()
+		MustSimTask(other)
+	}
+	expectPanic(testTask{1}, "schedule: expected a *SimTask, received a schedule.testTask with id 1")
+	expectPanic(nil, "schedule: expected a *SimTask, received a nil task")
+	expectPanic((*SimTask)(nil), "schedule: expected a *SimTask, received a nil task")
+}
+
+func TestPercentile(t *testing.T) {
+	values := []int{}
+	for i := 1; i <= 20; i++ {
+		values = append(values, i*10)
+	}
+	for _, c := range []struct{ p, expected int }{{50, 100}, {95, 190}, {99, 200}, {100, 200}, {1, 10}, {0, 10}} {
+		if actual := percentile(values, c.p); actual != c.expected {
+			t.Errorf("expected p%d of %d, received %d", c.p, c.expected, actual)
+		}
+	}
+	if percentile([]int{7}, 99) != 7 {
+		t.Error("expected single value percentile")
+	}
+	if percentile(nil, 50) != 0 {
+		t.Error("expected zero percentile of no values")
+	}
+}
+
+func TestSimulateLatencyPercentiles(t *testing.T) {
+	// latency covers both waiting and running time, measured from arrival
+	calc := func(_ Task) Resource { return NewResourceVectorRequest([]int{1}) }
+	tasks := []*SimTask{}
+	for i := 1; i <= 10; i++ {
+		tasks = append(tasks, &SimTask{Identifier: i, UserId: 1, RuntimeMs: 10, ArrivalMs: 5})
+	}
+	out := captureSimulate(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{1}), calc), tasks)
+	expectOutputContains(t, out, "latency p50:\t\t\t 50 ms")
+	expectOutputContains(t, out, "latency p95:\t\t\t 100 ms")
+	expectOutputContains(t, out, "latency p99:\t\t\t 100 ms")
+}
+
+func TestSimulateDeadlock(t *testing.T) {
+	// the map pool can't tell the scheduler the second task will never fit
+	calc := func(t Task) Resource {
+		return NewResourceMapRequest(map[string]int{"conn": t.(*SimTask).Identifier})
+	}
+	scheduler := NewResourceManagedScheduler(NewFifoScheduler(), NewResourceMapPool(map[string]int{"conn": 1}), calc)
+	result := SimulateResult(scheduler, []*SimTask{
+		{Identifier: 1, UserId: 1, RuntimeMs: 10},
+		{Identifier: 2, UserId: 2, RuntimeMs: 10},
+	})
+	if len(result.Users) != 1 || result.Users[0].ClockTimeMs != 10 {
+		t.Errorf("expected only user 1 to complete at 10ms, received %+v", result.Users)
+	}
+	if len(result.Stuck) != 1 || result.Stuck[0].Identifier != 2 {
+		t.Errorf("expected task 2 stuck, received %v", result.Stuck)
+	}
+
+	out := captureSimulate(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceMapPool(map[string]int{"conn": 1}), calc), []*SimTask{
+		{Identifier: 3, UserId: 1, RuntimeMs: 10, ArrivalMs: 5},
+	})
+	expectOutputContains(t, out, "stuck tasks:\t\t\t\t 1\n")
+}
+
+func TestSimulateThroughputWindow(t *testing.T) {
+	calc := func(_ Task) Resource { return NewResourceVectorRequest([]int{1}) }
+	tasks := func() []*SimTask {
+		tasks := []*SimTask{}
+		for i := 1; i <= 10; i++ {
+			tasks = append(tasks, &SimTask{Identifier: i, UserId: 1 + i%2, RuntimeMs: 30})
+		}
+		return tasks
+	}
+
+	// one task completes every 30ms, alternating between the users
+	result := SimulateResult(NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{1}), calc), tasks(), WithThroughputWindow(100))
+	total := 0
+	for _, user := range result.Users {
+		for _, n := range user.WindowedCompletions {
+			total += n
+		}
+	}
+	if total != 10 {
+		t.Errorf("expected windowed completions to sum to 10, received %d", total)
+	}
+	expected := map[int]string{1: "[1 2 1 1]", 2: "[2 1 2]"}
+	for _, user := range result.Users {
+		if actual := fmt.Sprint(user.WindowedCompletions); actual != expected[user.UserId] {
+			t.Errorf("expected user %d completions %s, received %s", user.UserId, expected[user.UserId], actual)
+		}
+	}
+	out := captureSimulate(t, NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{1}), calc), tasks(), WithThroughputWindow(100))
+	expectOutputContains(t, out, "completions per 100 ms:\t\t [2 1 2]\n")
+
+	// without the option no windows are reported
+	result = SimulateResult(NewFifoScheduler(), tasks())
+	if result.Users[0].WindowedCompletions != nil {
+		t.Errorf("expected no windowed completions, received %v", result.Users[0].WindowedCompletions)
+	}
+	out = captureSimulate(t, NewFifoScheduler(), tasks())
+	if strings.Contains(out, "completions per") {
+		t.Errorf("expected no windowed completions in output, received %q", out)
+	}
+}
+
+func TestSimulatePartialRelease(t *testing.T) {
+	calc := func(t Task) Resource {
+		if t.(*SimTask).Identifier == 1 {
+			return NewResourceVectorRequest([]int{2})
+		}
+		return NewResourceVectorRequest([]int{1})
+	}
+	tasks := func(releases []SimRelease) []*SimTask {
+		return []*SimTask{
+			{Identifier: 1, UserId: 1, RuntimeMs: 100, Releases: releases},
+			{Identifier: 2, UserId: 2, RuntimeMs: 10, ArrivalMs: 5},
+		}
+	}
+
+	// the waiting task starts once the long task completes
+	result := SimulateResult(NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{2}), calc), tasks(nil))
+	if clock := result.Users[1].ClockTimeMs; clock != 110 {
+		t.Errorf("expected user 2 to complete at 110ms, received %d", clock)
+	}
+
+	// or as soon as the long task releases half its resources
+	pool := NewResourceVectorPool([]int{2})
+	result = SimulateResult(NewResourceManagedScheduler(NewFifoScheduler(), pool, calc), tasks([]SimRelease{{AtMs: 20, Resources: []int{1}}}))
+	if clock := result.Users[1].ClockTimeMs; clock != 30 {
+		t.Errorf("expected user 2 to complete at 30ms, received %d", clock)
+	}
+	if clock := result.Users[0].ClockTimeMs; clock != 100 {
+		t.Errorf("expected user 1 to complete at 100ms, received %d", clock)
+	}
+	if pool.resources[0] != 2 {
+		t.Errorf("expected all resources returned, received %d available", pool.resources[0])
+	}
+
+	// releases due at or after completion are left to Close
+	pool = NewResourceVectorPool([]int{2})
+	result = SimulateResult(NewResourceManagedScheduler(NewFifoScheduler(), pool, calc), tasks([]SimRelease{{AtMs: 100, Resources: []int{1}}}))
+	if clock := result.Users[1].ClockTimeMs; clock != 110 || pool.resources[0] != 2 {
+		t.Errorf("expected user 2 to complete at 110ms with all resources returned, received %d and %d", clock, pool.resources[0])
+	}
+}
+
+func TestSimulateHold(t *testing.T) {
+	calc := func(Task) Resource { return NewResourceVectorRequest([]int{1}) }
+	tasks := func(holdMs int) []*SimTask {
+		return []*SimTask{
+			{Identifier: 1, UserId: 1, RuntimeMs: 100, HoldMs: holdMs},
+			{Identifier: 2, UserId: 2, RuntimeMs: 10, ArrivalMs: 5},
+		}
+	}
+
+	// a task without a hold keeps its resource until it completes
+	result := SimulateResult(NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{1}), calc), tasks(0))
+	if clock := result.Users[1].ClockTimeMs; clock != 110 {
+		t.Errorf("expected user 2 to complete at 110ms, received %d", clock)
+	}
+
+	// a short hold lets the waiting task take the resource while the first still runs
+	pool := NewResourceVectorPool([]int{1})
+	result = SimulateResult(NewResourceManagedScheduler(NewFifoScheduler(), pool, calc), tasks(20))
+	if clock := result.Users[1].ClockTimeMs; clock != 30 {
+		t.Errorf("expected user 2 to complete at 30ms, received %d", clock)
+	}
+	if clock := result.Users[0].ClockTimeMs; clock != 100 {
+		t.Errorf("expected user 1 to complete at 100ms, received %d", clock)
+	}
+	if pool.resources[0] != 1 {
+		t.Errorf("expected the resource returned once, received %d available", pool.resources[0])
+	}
+
+	// a hold as long as the runtime is left to Close
+	pool = NewResourceVectorPool([]int{1})
+	result = SimulateResult(NewResourceManagedScheduler(NewFifoScheduler(), pool, calc), tasks(100))
+	if clock := result.Users[1].ClockTimeMs; clock != 110 || pool.resources[0] != 1 {
+		t.Errorf("expected user 2 to complete at 110ms with the resource returned, received %d and %d", clock, pool.resources[0])
+	}
+}
+
+// fakeClock is a virtual Clock that records the durations it is asked to sleep.
+type fakeClock struct {
+	nowMs  int
+	sleeps []int
+}
+
+func (c *fakeClock) Now() int { return c.nowMs }
+
+func (c *fakeClock) Sleep(ms int) {
+	c.sleeps = append(c.sleeps, ms)
+	c.nowMs += ms
+}
+
+func TestSimulateClock(t *testing.T) {
+	tasks := func() []*SimTask {
+		return []*SimTask{
+			{Identifier: 1, UserId: 1, RuntimeMs: 50},
+			{Identifier: 2, UserId: 1, RuntimeMs: 100},
+			{Identifier: 3, UserId: 2, RuntimeMs: 40, ArrivalMs: 30},
+		}
+	}
+
+	// the clock sleeps until each arrival and completion, measured from its start
+	clock := &fakeClock{nowMs: 1000}
+	result := SimulateResult(NewFifoScheduler(), tasks(), WithClock(clock))
+	if actual := fmt.Sprint(clock.sleeps); actual != "[30 20 20 30]" {
+		t.Errorf("expected sleeps [30 20 20 30], received %s", actual)
+	}
+	if clock.nowMs != 1100 {
+		t.Errorf("expected clock at 1100ms, received %d", clock.nowMs)
+	}
+
+	// the results match those of the default virtual clock
+	if expected := SimulateResult(NewFifoScheduler(), tasks()); fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("expected results %v, received %v", expected, result)
+	}
+
+	// a real time clock takes as long as the tasks it simulates
+	realTime := NewRealTimeClock()
+	SimulateResult(NewFifoScheduler(), []*SimTask{{Identifier: 1, UserId: 1, RuntimeMs: 20}}, WithClock(realTime))
+	if elapsed := realTime.Now(); elapsed < 20 {
+		t.Errorf("expected at least 20ms to elapse, received %d", elapsed)
+	}
+}
+
+func TestSimulateExpiringPool(t *testing.T) {
+	calc := func(_ Task) Resource { return NewResourceVectorRequest([]int{1}) }
+	tasks := func() []*SimTask {
+		return []*SimTask{
+			{Identifier: 1, UserId: 1, RuntimeMs: 200},
+			{Identifier: 2, UserId: 2, RuntimeMs: 10},
+		}
+	}
+
+	// the second task waits on the first to complete without leases
+	result := SimulateResult(NewResourceManagedScheduler(NewFifoScheduler(), NewResourceVectorPool([]int{1}), calc), tasks())
+	if clock := result.Users[1].ClockTimeMs; clock != 210 {
+		t.Errorf("expected user 2 to complete at 210ms, received %d", clock)
+	}
+
+	// the first task's lease expires at 50ms, letting the second proceed without the first closing
+	pool := NewLeasedPool(NewResourceVectorPool([]int{1}), 50)
+	result = SimulateResult(NewResourceManagedScheduler(NewFifoScheduler(), pool, calc), tasks(), WithExpiringPool(pool))
+	if clock := result.Users[1].ClockTimeMs; clock != 60 {
+		t.Errorf("expected user 2 to complete at 60ms, received %d", clock)
+	}
+	if clock := result.Users[0].ClockTimeMs; clock != 200 {
+		t.Errorf("expected user 1 to complete at 200ms, received %d", clock)
+	}
+	if pool.resources[0] != 1 {
+		t.Errorf("expected all resources returned, received %d available", pool.resources[0])
+	}
+}
+
+func TestSimulateOnTick(t *testing.T) {
+	tasks := []*SimTask{
+		{Identifier: 1, UserId: 1, RuntimeMs: 50},
+		{Identifier: 2, UserId: 1, RuntimeMs: 50},
+		{Identifier: 3, UserId: 2, RuntimeMs: 30, ArrivalMs: 10},
+		{Identifier: 4, UserId: 2, RuntimeMs: 10, ArrivalMs: 70},
+	}
+
+	// a tick for each arrival and completion, counting the tasks running up to it
+	ticks := []string{}
+	onTick := func(runningCount int, clockMs int) {
+		ticks = append(ticks, fmt.Sprintf("%d@%d", runningCount, clockMs))
+	}
+	result := SimulateResult(NewFifoScheduler(), tasks, WithOnTick(onTick))
+	expected := "[2@10 3@40 2@50 0@70 1@80]"
+	if fmt.Sprint(ticks) != expected {
+		t.Errorf("expected ticks %s, received %v", expected, ticks)
+	}
+
+	// the hook does not change the results
+	if fmt.Sprint(result) != fmt.Sprint(SimulateResult(NewFifoScheduler(), tasks)) {
+		t.Error("expected the same results with and without the hook")
+	}
+}
+
+func TestSimulateZeroRuntime(t *testing.T) {
+	// a task without a positive runtime completes as soon as it starts
+	tasks := []*SimTask{
+		{Identifier: 1, UserId: 1, RuntimeMs: 20},
+		{Identifier: 2, UserId: 1, RuntimeMs: 0, ArrivalMs: 5},
+		{Identifier: 3, UserId: 1, RuntimeMs: -10, ArrivalMs: 30},
+	}
+	result := SimulateResult(NewFifoScheduler(), tasks)
+	if user := result.Users[0]; fmt.Sprint(user.LatenciesMs) != "[0 0 20]" || user.ClockTimeMs != 30 {
+		t.Errorf("expected latencies [0 0 20] ending at 30ms, received %v ending at %dms", user.LatenciesMs, user.ClockTimeMs)
+	}
+
+	// a user whose tasks all complete at time zero has no throughput, rather than
+	// an infinite one
+	instant := []*SimTask{{Identifier: 1, UserId: 2}, {Identifier: 2, UserId: 2, RuntimeMs: -5}}
+	result = SimulateResult(NewFifoScheduler(), instant, WithGini())
+	if user := result.Users[0]; user.Throughput != 0 || user.ClockTimeMs != 0 || fmt.Sprint(user.LatenciesMs) != "[0 0]" {
+		t.Errorf("expected no throughput at 0ms, received %f at %dms", user.Throughput, user.ClockTimeMs)
+	}
+	if result.Fairness != 0 || result.Gini != 0 {
+		t.Errorf("expected no fairness or gini, received %f and %f", result.Fairness, result.Gini)
+	}
+	out := captureSimulate(t, NewFifoScheduler(), instant)
+	expectOutputContains(t, out, "throughput (tasks / sec):\t 0.000000\n")
+	expectOutputContains(t, out, "fairness index:\t\t\t\t 0.000000\n")
+}
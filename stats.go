@@ -0,0 +1,107 @@
+package schedule
+
+// SchedulerStats is a snapshot of the counters of a Scheduler. The counters are
+// totals over the life of the scheduler and are not reset by Clear().
+type SchedulerStats struct {
+	// Puts is the number of tasks admitted by Put, excluding duplicates and
+	// tasks otherwise dropped.
+	Puts int
+
+	// Nexts is the number of tasks returned by Next.
+	Nexts int
+
+	// Removes is the number of tasks returned by Remove.
+	Removes int
+
+	// Size is the number of tasks present in the scheduler.
+	Size int
+
+	// PeakSize is the largest size the scheduler has been observed to have.
+	PeakSize int
+}
+
+// schedulerStatsRecorder tracks the counters of a SchedulerStats. Schedulers embed
+// it and record each operation as it completes.
+type schedulerStatsRecorder struct {
+	puts     int
+	nexts    int
+	removes  int
+	peakSize int
+}
+
+// recordPut records n tasks admitted by a put that left the scheduler with size tasks.
+func (r *schedulerStatsRecorder) recordPut(n, size int) {
+	r.puts += n
+	r.observeSize(size)
+}
+
+// recordNext counts t as returned by Next unless it is nil, and returns it.
+func (r *schedulerStatsRecorder) recordNext(t ScheduledTask) ScheduledTask {
+	if t != nil {
+		r.nexts++
+	}
+	return t
+}
+
+// recordRemove counts t as returned by Remove unless it is nil, and returns it.
+func (r *schedulerStatsRecorder) recordRemove(t Task) Task {
+	if t != nil {
+		r.removes++
+	}
+	return t
+}
+
+func (r *schedulerStatsRecorder) observeSize(size int) {
+	if size > r.peakSize {
+		r.peakSize = size
+	}
+}
+
+// stats returns a snapshot of the counters for a scheduler of the given size.
+func (r *schedulerStatsRecorder) stats(size int) SchedulerStats {
+	r.observeSize(size)
+	return SchedulerStats{r.puts, r.nexts, r.removes, size, r.peakSize}
+}
+
+// PoolStats is a snapshot of the counters of a pool created with NewResourceVectorPool,
+// for sizing the pool. The counters are totals over the life of the pool.
+type PoolStats struct {
+	// Granted is the number of requests granted.
+	Granted int
+
+	// Denied is the number of requests denied.
+	Denied int
+
+	// DeniedBy holds, for each dimension of the pool, the number of denied requests
+	// for more of it than was available. A request can be denied by several dimensions.
+	DeniedBy []int
+}
+
+// poolStatsRecorder tracks the counters of a PoolStats. The caller must hold the
+// lock of the pool.
+type poolStatsRecorder struct {
+	granted  int
+	denied   int
+	deniedBy []int
+}
+
+// recordRequest records the outcome of a request for requested from pool, counting
+// each dimension the pool can't satisfy if it was denied.
+func (r *poolStatsRecorder) recordRequest(pool *resourceVectorPool, requested []int, granted bool) {
+	if granted {
+		r.granted++
+		return
+	}
+	r.denied++
+	for i := range requested {
+		if requested[i] > pool.resources[i]-pool.floor[i] {
+			r.deniedBy[i]++
+		}
+	}
+}
+
+func (r *poolStatsRecorder) snapshot() PoolStats {
+	deniedBy := make([]int, len(r.deniedBy))
+	copy(deniedBy, r.deniedBy)
+	return PoolStats{r.granted, r.denied, deniedBy}
+}
@@ -0,0 +1,136 @@
+package schedule
+
+// A TypedPartitioner is a Partitioner of tasks of a single concrete type T.
+type TypedPartitioner[T Task] func(t T) (key string, priority uint, factory SchedulerFactory)
+
+// Partitioner returns a Partitioner that calls p. It must only be given tasks of type T,
+// as it is by schedulers wrapped in a TypedScheduler[T].
+func (p TypedPartitioner[T]) Partitioner() Partitioner {
+	return func(t Task) (string, uint, SchedulerFactory) {
+		return p(t.(T))
+	}
+}
+
+// A TypedResourceCalculator is a ResourceCalculator of tasks of a single concrete type T.
+type TypedResourceCalculator[T Task] func(t T) Resource
+
+// ResourceCalculator returns a ResourceCalculator that calls c. It must only be given
+// tasks of type T, as it is by schedulers wrapped in a TypedScheduler[T].
+func (c TypedResourceCalculator[T]) ResourceCalculator() ResourceCalculator {
+	return func(t Task) Resource {
+		return c(t.(T))
+	}
+}
+
+// A TypedScheduledTask is a ScheduledTask of a task of type T.
+type TypedScheduledTask[T Task] struct {
+	ScheduledTask
+}
+
+// Task returns the scheduled task as a T.
+func (t *TypedScheduledTask[T]) Task() T {
+	return t.ScheduledTask.Task().(T)
+}
+
+func (t *TypedScheduledTask[T]) OnComplete(f func()) { onComplete(t.ScheduledTask, f) }
+
+// A TypedScheduler wraps a Scheduler so that only tasks of type T can be put in to it
+// and tasks come out of it as a T. Since the underlying scheduler only ever holds tasks
+// of type T, the partitioners and resource calculators it uses can be written as a
+// TypedPartitioner[T] or TypedResourceCalculator[T].
+type TypedScheduler[T Task] struct {
+	underlying Scheduler
+}
+
+func NewTypedScheduler[T Task](underlying Scheduler) *TypedScheduler[T] {
+	return &TypedScheduler[T]{underlying}
+}
+
+// Scheduler returns the underlying scheduler.
+func (s *TypedScheduler[T]) Scheduler() Scheduler {
+	return s.underlying
+}
+
+func (s *TypedScheduler[T]) Contains(t T) bool {
+	return s.underlying.Contains(t)
+}
+
+func (s *TypedScheduler[T]) ContainsId(id string) bool {
+	return s.underlying.ContainsId(id)
+}
+
+func (s *TypedScheduler[T]) Put(tasks ...T) {
+	untyped := make([]Task, len(tasks))
+	for i, t := range tasks {
+		untyped[i] = t
+	}
+	s.underlying.Put(untyped...)
+}
+
+// Next returns the next task, or nil if the underlying scheduler returns nil.
+func (s *TypedScheduler[T]) Next() *TypedScheduledTask[T] {
+	next := s.underlying.Next()
+	if next == nil {
+		return nil
+	}
+	return &TypedScheduledTask[T]{next}
+}
+
+func (s *TypedScheduler[T]) NextN(n int) []*TypedScheduledTask[T] {
+	untyped := s.underlying.NextN(n)
+	tasks := make([]*TypedScheduledTask[T], len(untyped))
+	for i, st := range untyped {
+		tasks[i] = &TypedScheduledTask[T]{st}
+	}
+	return tasks
+}
+
+func (s *TypedScheduler[T]) PeekN(n int) []T {
+	untyped := s.underlying.PeekN(n)
+	tasks := make([]T, len(untyped))
+	for i, t := range untyped {
+		tasks[i] = t.(T)
+	}
+	return tasks
+}
+
+func (s *TypedScheduler[T]) CopyInto(dst Scheduler) {
+	s.underlying.CopyInto(dst)
+}
+
+func (s *TypedScheduler[T]) Drain() []T {
+	untyped := s.underlying.Drain()
+	tasks := make([]T, len(untyped))
+	for i, t := range untyped {
+		tasks[i] = t.(T)
+	}
+	return tasks
+}
+
+func (s *TypedScheduler[T]) Each(f func(T) bool) {
+	s.underlying.Each(func(t Task) bool {
+		return f(t.(T))
+	})
+}
+
+// Remove removes the task with the given id. ok is false if the scheduler does not
+// contain a task with that id.
+func (s *TypedScheduler[T]) Remove(id string) (t T, ok bool) {
+	removed := s.underlying.Remove(id)
+	if removed == nil {
+		return t, false
+	}
+	return removed.(T), true
+}
+
+func (s *TypedScheduler[T]) Size() int {
+	return s.underlying.Size()
+}
+
+func (s *TypedScheduler[T]) Stats() SchedulerStats {
+	return s.underlying.Stats()
+}
+
+func (s *TypedScheduler[T]) Clear() {
+	s.underlying.Clear()
+}
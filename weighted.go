@@ -0,0 +1,156 @@
+package schedule
+
+import (
+	"sort"
+)
+
+// A WeightedFairScheduler partitions tasks like a PartitionedScheduler but shares
+// each priority level among its partitions by weight rather than round robinning.
+// Each partition accumulates a virtual finish time, advanced by cost(t) / weight
+// for each task t it returns, and Next() returns a task from the partition with the
+// smallest virtual finish time at the highest priority. A partition that becomes
+// active starts from the scheduler's current virtual time, so idle partitions
+// do not build up credit. Keys without a positive weight have a weight of 1.
+type WeightedFairScheduler struct {
+	partitioner Partitioner
+	weights     map[string]int
+	cost        func(Task) int
+	virtualTime float64
+	partitionSet
+	schedulerStatsRecorder
+}
+
+func NewWeightedFairScheduler(p Partitioner, weights map[string]int, cost func(Task) int) *WeightedFairScheduler {
+	return &WeightedFairScheduler{p, weights, cost, 0, newPartitionSet(partitionKey(p)), schedulerStatsRecorder{}}
+}
+
+func (w *WeightedFairScheduler) weight(key string) float64 {
+	if weight := w.weights[key]; weight > 0 {
+		return float64(weight)
+	}
+	return 1
+}
+
+func (w *WeightedFairScheduler) Put(tasks ...Task) {
+	before := w.Size()
+	for _, t := range tasks {
+		key, pri, fact := w.partitioner(t)
+		part := w.partition(key)
+		if part == nil {
+			part = w.add(key, pri, fact())
+			part.finish = w.virtualTime
+		}
+		if part.scheduler.Size() == 0 && part.finish < w.virtualTime {
+			part.finish = w.virtualTime
+		}
+		part.scheduler.Put(t)
+	}
+	w.recordPut(w.Size()-before, w.Size())
+}
+
+func (w *WeightedFairScheduler) Next() ScheduledTask {
+	active := []*keyedPartition{}
+	for _, part := range w.partitions {
+		if part.scheduler.Size() > 0 {
+			active = append(active, part)
+		}
+	}
+	sort.SliceStable(active, func(i, j int) bool {
+		if active[i].priority != active[j].priority {
+			return active[i].priority > active[j].priority
+		}
+		return active[i].finish < active[j].finish
+	})
+	for _, part := range active {
+		next := part.scheduler.Next()
+		if next == nil {
+			continue
+		}
+		if part.finish > w.virtualTime {
+			w.virtualTime = part.finish
+		}
+		part.finish += float64(w.cost(next.Task())) / w.weight(part.key)
+		w.prune(w.stateful)
+		return w.recordNext(next)
+	}
+	return nil
+}
+
+func (w *WeightedFairScheduler) NextN(n int) []ScheduledTask {
+	return nextN(w, n)
+}
+
+func (w *WeightedFairScheduler) PeekN(n int) []Task {
+	return peekN(w, n)
+}
+
+func (w *WeightedFairScheduler) CopyInto(dst Scheduler) {
+	copyInto(w, dst)
+}
+
+// Drain drains each partition and orders their tasks by virtual finish time as Next
+// would, without requesting resources from partitions that manage them.
+func (w *WeightedFairScheduler) Drain() []Task {
+	tasks := w.order(Scheduler.Drain)
+	w.Clear()
+	return tasks
+}
+
+// Each visits the tasks in order of virtual finish time as Next would.
+func (w *WeightedFairScheduler) Each(f func(Task) bool) {
+	visit(w.order(collect), f)
+}
+
+// order takes the tasks of each partition using take and orders them by virtual
+// finish time without advancing the virtual finish time of any partition.
+func (w *WeightedFairScheduler) order(take func(Scheduler) []Task) []Task {
+	taken := make([][]Task, len(w.partitions))
+	finish := make([]float64, len(w.partitions))
+	for i, part := range w.partitions {
+		taken[i] = take(part.scheduler)
+		finish[i] = part.finish
+	}
+	tasks := []Task{}
+	for {
+		best := -1
+		for i, part := range w.partitions {
+			if len(taken[i]) == 0 {
+				continue
+			}
+			if best == -1 || part.priority > w.partitions[best].priority ||
+				(part.priority == w.partitions[best].priority && finish[i] < finish[best]) {
+				best = i
+			}
+		}
+		if best == -1 {
+			break
+		}
+		t := taken[best][0]
+		taken[best] = taken[best][1:]
+		finish[best] += float64(w.cost(t)) / w.weight(w.partitions[best].key)
+		tasks = append(tasks, t)
+	}
+	return tasks
+}
+
+// stateful returns false for empty partitions that would start from the current
+// virtual time when next active, as they hold no state worth keeping.
+func (w *WeightedFairScheduler) stateful(part *keyedPartition) bool {
+	return nonEmpty(part) || part.finish > w.virtualTime
+}
+
+func (w *WeightedFairScheduler) Remove(id string) Task {
+	if t := w.remove(id); t != nil {
+		return w.recordRemove(t)
+	}
+	return nil
+}
+
+func (w *WeightedFairScheduler) Stats() SchedulerStats {
+	return w.stats(w.Size())
+}
+
+func (w *WeightedFairScheduler) Clear() {
+	w.clear()
+	w.virtualTime = 0
+}